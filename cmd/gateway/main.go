@@ -1,28 +1,211 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"flag"
+	"github.com/gin-gonic/gin"
 	"github.com/semirm-dev/faceit/gateway"
+	"github.com/semirm-dev/faceit/internal/certs"
+	"github.com/semirm-dev/faceit/internal/grpc"
+	"github.com/semirm-dev/faceit/internal/health"
+	"github.com/semirm-dev/faceit/internal/logging"
+	"github.com/semirm-dev/faceit/internal/tracing"
 	"github.com/semirm-dev/faceit/internal/web"
+	"github.com/semirm-dev/faceit/user"
+	pbUser "github.com/semirm-dev/faceit/user/proto"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+const (
+	serviceName       = "gateway"
+	defaultRateLimits = "POST /users=10/1m:ip,PUT /users/:id/password=5/1m:user,*=300/1m:client"
 )
 
 var (
 	httpAddr    = flag.String("http", ":8000", "Http address")
 	accountAddr = flag.String("account_uri", ":8001", "User Account Service address")
+	grpcTimeout = flag.Duration("grpc_timeout", 10*time.Second, "Default timeout of user service grpc calls, 0 disables it")
+	grpcToken   = flag.String("grpc_token", "", "Bearer token sent to user service")
+
+	accountResolver      = flag.String("account_resolver", grpc.ResolverPassthrough, "How account_uri is resolved: passthrough (single address), dns (all addresses of host), static (comma separated list)")
+	grpcLoadBalancing    = flag.String("grpc_lb", grpc.RoundRobin, "Load balancing across user service instances: round_robin, pick_first")
+	grpcHealthCheck      = flag.Bool("grpc_health_check", true, "Skip user service instances that report not serving")
+	grpcKeepaliveTime    = flag.Duration("grpc_keepalive_time", 30*time.Second, "Ping user service instances after this idle time, 0 disables keepalive")
+	grpcKeepaliveTimeout = flag.Duration("grpc_keepalive_timeout", 10*time.Second, "Close connection if keepalive ping is not answered in time")
+
+	grpcReadTimeout    = flag.Duration("grpc_read_timeout", 3*time.Second, "Timeout of user service read calls, including retries")
+	grpcReadAttempts   = flag.Int("grpc_read_attempts", 3, "Max attempts of user service read calls, retried when service is unavailable")
+	breakerFailures    = flag.Int("breaker_failures", 5, "Consecutive user service failures that open circuit breaker, 0 disables it")
+	breakerOpenTimeout = flag.Duration("breaker_open_timeout", 10*time.Second, "How long circuit breaker stays open before trial call")
+
+	grpcTLSCert       = flag.String("grpc_tls_cert", "", "Client certificate file (PEM) presented to user service for mTLS")
+	grpcTLSKey        = flag.String("grpc_tls_key", "", "Client private key file (PEM)")
+	grpcTLSCA         = flag.String("grpc_tls_ca", "", "CA file (PEM) used to verify user service certificate")
+	grpcTLSServerName = flag.String("grpc_tls_server_name", "", "Expected name in user service certificate, defaults to host of account_uri")
+	httpTLSCert       = flag.String("http_tls_cert", "", "Https certificate file (PEM), http is served if empty")
+	httpTLSKey        = flag.String("http_tls_key", "", "Https private key file (PEM)")
+	tlsReloadInterval = flag.Duration("tls_reload_interval", 30*time.Second, "How often certificate files are checked for rotation")
+
+	rateLimits     = flag.String("rate_limits", defaultRateLimits, "Rate limits per route: ROUTE=REQUESTS/PERIOD:KEY, comma separated, keys: ip, api_key, user (caller and user id from path), client (api key or ip)")
+	ipRateLimit    = flag.String("ip_rate_limit", "600/1m", "Limit of all requests per client ip, applied before api key is validated: REQUESTS/PERIOD, empty disables it")
+	requireAPIKey  = flag.Bool("require_api_key", false, "Require api key with accounts scopes on all users routes")
+	trustedProxies = flag.String("trusted_proxies", "", "Proxies (ip or cidr, comma separated) whose X-Forwarded-For header is trusted, none if empty")
+	logLevel       = flag.String("log_level", "info", "Log level: trace, debug, info, warn, error")
+	traceExporter  = flag.String("trace_exporter", tracing.ExporterNone, "Trace exporter: none, stdout, otlp")
+	otlpEndpoint   = flag.String("otlp_endpoint", "localhost:4317", "OTLP grpc collector address")
 )
 
 func main() {
 	flag.Parse()
 
-	router := web.NewRouter()
+	if err := logging.Init(*logLevel); err != nil {
+		logrus.Fatal(err)
+	}
+
+	traceConf := tracing.NewConfig(serviceName)
+	traceConf.Exporter = *traceExporter
+	traceConf.OtlpEndpoint = *otlpEndpoint
+
+	shutdownTracing, err := tracing.Init(context.Background(), traceConf)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	defer shutdownTracing(context.Background())
+
+	clientOpts := grpc.NewClientOptions()
+	clientOpts.Timeout = *grpcTimeout
+	clientOpts.Token = *grpcToken
+	clientOpts.ServiceConfig = gateway.ServiceConfig(*grpcTimeout, *grpcReadTimeout, *grpcReadAttempts)
+	clientOpts.Resolver = *accountResolver
+	clientOpts.LoadBalancing = *grpcLoadBalancing
+	clientOpts.KeepaliveTime = *grpcKeepaliveTime
+	clientOpts.KeepaliveTimeout = *grpcKeepaliveTimeout
+	if *grpcHealthCheck {
+		clientOpts.HealthCheckService = pbUser.AccountManagement_ServiceDesc.ServiceName
+	}
+
+	if *breakerFailures > 0 {
+		clientOpts.Breaker = grpc.NewCircuitBreaker()
+		clientOpts.Breaker.FailureThreshold = *breakerFailures
+		clientOpts.Breaker.OpenTimeout = *breakerOpenTimeout
+	}
+
+	grpcCerts := certs.NewConfig()
+	grpcCerts.CertFile = *grpcTLSCert
+	grpcCerts.KeyFile = *grpcTLSKey
+	grpcCerts.CAFile = *grpcTLSCA
+	grpcCerts.ReloadInterval = *tlsReloadInterval
+
+	if grpcCerts.Enabled() {
+		reloader, err := certs.NewReloader(context.Background(), grpcCerts)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		clientOpts.TLS = reloader.ClientTLS(*grpcTLSServerName)
+	}
+
+	api := gateway.NewApi(*accountAddr, clientOpts)
+
+	readiness := health.NewChecker()
+	readiness.Add("account_service", api.AccountServiceReady)
+
+	var proxies []string
+	if *trustedProxies != "" {
+		proxies = strings.Split(*trustedProxies, ",")
+	}
 
-	api := gateway.NewApi(*accountAddr)
+	router, err := web.NewRouter(serviceName, readiness, proxies)
+	if err != nil {
+		logrus.Fatal(err)
+	}
 
-	router.POST("users", api.CreateAccount())
-	router.PUT("users/:id", api.ModifyAccount())
-	router.PUT("users/:id/password", api.ChangePassword())
-	router.DELETE("users/:id", api.DeleteAccount())
-	router.GET("users", api.GetAccounts())
+	// ipLimiter runs before api key is validated, so requests with invalid keys don't reach user service unlimited
+	if *ipRateLimit != "" {
+		limit, err := web.ParseLimit(*ipRateLimit)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		ipLimiter := web.NewRateLimiter(web.NewMemoryStore())
+		if err = ipLimiter.Route(web.DefaultRoute, limit, web.KeyIP); err != nil {
+			logrus.Fatal(err)
+		}
+
+		router.Use(ipLimiter.Middleware())
+	}
+
+	client := web.FirstKey(gateway.APIKeyID, web.KeyByIP)
+
+	limiter := web.NewRateLimiter(web.NewMemoryStore())
+	limiter.Key("api_key", gateway.APIKeyID)
+	// user buckets are kept per caller, so one caller can't exhaust limit of user for others
+	limiter.Key("user", web.JoinKeys(client, web.KeyByParam("id")))
+	limiter.Key("client", client)
+	if err = limiter.Routes(*rateLimits); err != nil {
+		logrus.Fatal(err)
+	}
+
+	router.Use(api.APIKeyAuth())
+	router.Use(limiter.Middleware())
+
+	read, write := noAuth, noAuth
+	if *requireAPIKey {
+		read = api.RequireScope(user.ScopeAccountsRead)
+		write = api.RequireScope(user.ScopeAccountsWrite)
+	}
+	admin := api.RequireScope(user.ScopeAdmin)
+	// exports hold all account data, they are never served without api key
+	exportRead := api.RequireOwnerOrAdmin(user.ScopeAccountsRead)
+	exportWrite := api.RequireOwnerOrAdmin(user.ScopeAccountsWrite)
+
+	router.POST("users", write, api.CreateAccount())
+	router.PUT("users/:id", write, api.ModifyAccount())
+	router.PATCH("users/:id", write, api.PatchAccount())
+	router.PUT("users/:id/password", write, api.ChangePassword())
+	router.POST("users/:id/unlock", admin, api.UnlockAccount())
+	router.POST("users/:id/restore", admin, api.RestoreAccount())
+	router.POST("users/:id/purge", admin, api.PurgeAccount())
+	router.POST("users/:id/erase", admin, api.EraseAccount())
+	router.POST("users/:id/export", exportWrite, api.ExportAccountData())
+	router.GET("users/:id/export/:export_id", exportRead, api.GetAccountExport())
+	router.POST("users/:id/totp", write, api.EnrollTOTP())
+	router.POST("users/:id/totp/confirm", write, api.ConfirmTOTP())
+	router.POST("users/:id/totp/recovery-codes", write, api.RegenerateRecoveryCodes())
+	router.DELETE("users/:id/totp", write, api.DisableTOTP())
+	router.DELETE("users/:id", write, api.DeleteAccount())
+	router.GET("users", read, api.GetAccounts())
+
+	router.POST("api-keys", admin, api.CreateAPIKey())
+	router.GET("api-keys", admin, api.GetAPIKeys())
+	router.DELETE("api-keys/:id", admin, api.RevokeAPIKey())
+
+	router.GET("audit-log", admin, api.GetAuditLog())
+
+	var httpTLS *tls.Config
+
+	httpCerts := certs.NewConfig()
+	httpCerts.CertFile = *httpTLSCert
+	httpCerts.KeyFile = *httpTLSKey
+	httpCerts.ReloadInterval = *tlsReloadInterval
+
+	if httpCerts.Enabled() {
+		reloader, err := certs.NewReloader(context.Background(), httpCerts)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		httpTLS = reloader.ServerTLS()
+	}
+
+	web.ServeHttp(*httpAddr, serviceName, router, httpTLS)
+
+	if err = api.Close(); err != nil {
+		logrus.Error(err)
+	}
+}
 
-	web.ServeHttp(*httpAddr, "gateway", router)
+func noAuth(c *gin.Context) {
+	c.Next()
 }
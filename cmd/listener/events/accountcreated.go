@@ -2,21 +2,20 @@ package events
 
 import (
 	"context"
-	"github.com/gobackpack/rmq"
 	"github.com/semirm-dev/faceit/event"
+	"github.com/semirm-dev/faceit/internal/broker"
 )
 
 type accountCreated struct {
-	hub *rmq.Hub
+	broker *broker.Broker
 }
 
-func NewAccountCreatedListener(hub *rmq.Hub) *accountCreated {
+func NewAccountCreatedListener(b *broker.Broker) *accountCreated {
 	return &accountCreated{
-		hub: hub,
+		broker: b,
 	}
 }
 
 func (ev *accountCreated) Listen(ctx context.Context) {
-	consumer := startConsumer(ctx, ev.hub, event.AccountCreated)
-	go handleMessages(ctx, consumer, event.AccountCreated)
+	consume(ctx, ev.broker, event.AccountCreated)
 }
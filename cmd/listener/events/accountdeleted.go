@@ -2,21 +2,20 @@ package events
 
 import (
 	"context"
-	"github.com/gobackpack/rmq"
 	"github.com/semirm-dev/faceit/event"
+	"github.com/semirm-dev/faceit/internal/broker"
 )
 
 type accountDeleted struct {
-	hub *rmq.Hub
+	broker *broker.Broker
 }
 
-func NewAccountDeletedListener(hub *rmq.Hub) *accountDeleted {
+func NewAccountDeletedListener(b *broker.Broker) *accountDeleted {
 	return &accountDeleted{
-		hub: hub,
+		broker: b,
 	}
 }
 
 func (ev *accountDeleted) Listen(ctx context.Context) {
-	consumer := startConsumer(ctx, ev.hub, event.AccountDeleted)
-	go handleMessages(ctx, consumer, event.AccountDeleted)
+	consume(ctx, ev.broker, event.AccountDeleted)
 }
@@ -0,0 +1,21 @@
+package events
+
+import (
+	"context"
+	"github.com/semirm-dev/faceit/event"
+	"github.com/semirm-dev/faceit/internal/broker"
+)
+
+type accountErased struct {
+	broker *broker.Broker
+}
+
+func NewAccountErasedListener(b *broker.Broker) *accountErased {
+	return &accountErased{
+		broker: b,
+	}
+}
+
+func (ev *accountErased) Listen(ctx context.Context) {
+	consume(ctx, ev.broker, event.AccountErased)
+}
@@ -0,0 +1,21 @@
+package events
+
+import (
+	"context"
+	"github.com/semirm-dev/faceit/event"
+	"github.com/semirm-dev/faceit/internal/broker"
+)
+
+type accountExportReady struct {
+	broker *broker.Broker
+}
+
+func NewAccountExportReadyListener(b *broker.Broker) *accountExportReady {
+	return &accountExportReady{
+		broker: b,
+	}
+}
+
+func (ev *accountExportReady) Listen(ctx context.Context) {
+	consume(ctx, ev.broker, event.AccountExportReady)
+}
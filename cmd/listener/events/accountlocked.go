@@ -0,0 +1,21 @@
+package events
+
+import (
+	"context"
+	"github.com/semirm-dev/faceit/event"
+	"github.com/semirm-dev/faceit/internal/broker"
+)
+
+type accountLocked struct {
+	broker *broker.Broker
+}
+
+func NewAccountLockedListener(b *broker.Broker) *accountLocked {
+	return &accountLocked{
+		broker: b,
+	}
+}
+
+func (ev *accountLocked) Listen(ctx context.Context) {
+	consume(ctx, ev.broker, event.AccountLocked)
+}
@@ -2,21 +2,20 @@ package events
 
 import (
 	"context"
-	"github.com/gobackpack/rmq"
 	"github.com/semirm-dev/faceit/event"
+	"github.com/semirm-dev/faceit/internal/broker"
 )
 
 type accountModified struct {
-	hub *rmq.Hub
+	broker *broker.Broker
 }
 
-func NewAccountModifiedListener(hub *rmq.Hub) *accountModified {
+func NewAccountModifiedListener(b *broker.Broker) *accountModified {
 	return &accountModified{
-		hub: hub,
+		broker: b,
 	}
 }
 
 func (ev *accountModified) Listen(ctx context.Context) {
-	consumer := startConsumer(ctx, ev.hub, event.AccountModified)
-	go handleMessages(ctx, consumer, event.AccountModified)
+	consume(ctx, ev.broker, event.AccountModified)
 }
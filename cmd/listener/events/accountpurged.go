@@ -0,0 +1,21 @@
+package events
+
+import (
+	"context"
+	"github.com/semirm-dev/faceit/event"
+	"github.com/semirm-dev/faceit/internal/broker"
+)
+
+type accountPurged struct {
+	broker *broker.Broker
+}
+
+func NewAccountPurgedListener(b *broker.Broker) *accountPurged {
+	return &accountPurged{
+		broker: b,
+	}
+}
+
+func (ev *accountPurged) Listen(ctx context.Context) {
+	consume(ctx, ev.broker, event.AccountPurged)
+}
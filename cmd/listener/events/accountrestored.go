@@ -0,0 +1,21 @@
+package events
+
+import (
+	"context"
+	"github.com/semirm-dev/faceit/event"
+	"github.com/semirm-dev/faceit/internal/broker"
+)
+
+type accountRestored struct {
+	broker *broker.Broker
+}
+
+func NewAccountRestoredListener(b *broker.Broker) *accountRestored {
+	return &accountRestored{
+		broker: b,
+	}
+}
+
+func (ev *accountRestored) Listen(ctx context.Context) {
+	consume(ctx, ev.broker, event.AccountRestored)
+}
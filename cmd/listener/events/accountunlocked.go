@@ -0,0 +1,21 @@
+package events
+
+import (
+	"context"
+	"github.com/semirm-dev/faceit/event"
+	"github.com/semirm-dev/faceit/internal/broker"
+)
+
+type accountUnlocked struct {
+	broker *broker.Broker
+}
+
+func NewAccountUnlockedListener(b *broker.Broker) *accountUnlocked {
+	return &accountUnlocked{
+		broker: b,
+	}
+}
+
+func (ev *accountUnlocked) Listen(ctx context.Context) {
+	consume(ctx, ev.broker, event.AccountUnlocked)
+}
@@ -0,0 +1,21 @@
+package events
+
+import (
+	"context"
+	"github.com/semirm-dev/faceit/event"
+	"github.com/semirm-dev/faceit/internal/broker"
+)
+
+type clientLocked struct {
+	broker *broker.Broker
+}
+
+func NewClientLockedListener(b *broker.Broker) *clientLocked {
+	return &clientLocked{
+		broker: b,
+	}
+}
+
+func (ev *clientLocked) Listen(ctx context.Context) {
+	consume(ctx, ev.broker, event.ClientLocked)
+}
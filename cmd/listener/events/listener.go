@@ -2,50 +2,111 @@ package events
 
 import (
 	"context"
-	"github.com/gobackpack/rmq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/semirm-dev/faceit/internal/broker"
+	"github.com/semirm-dev/faceit/internal/logging"
+	"github.com/semirm-dev/faceit/internal/metrics"
+	"github.com/semirm-dev/faceit/internal/tracing"
 	"github.com/sirupsen/logrus"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+	"sync"
 	"time"
 )
 
-// Listener will listen for account events
+var (
+	messagesConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "listener",
+		Name:      "messages_consumed_total",
+		Help:      "Total number of account event messages consumed by event.",
+	}, []string{"event"})
+
+	messagesFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "listener",
+		Name:      "messages_failed_total",
+		Help:      "Total number of account event consumer errors by event.",
+	}, []string{"event"})
+)
+
+// Listener will listen for account events, until ctx is done and received messages are handled
 type Listener interface {
 	Listen(ctx context.Context)
 }
 
-func Listen(ctx context.Context, listeners ...Listener) {
+// Listen will start all listeners, returned WaitGroup is done once all of them are drained
+func Listen(ctx context.Context, listeners ...Listener) *sync.WaitGroup {
+	wg := &sync.WaitGroup{}
+
 	for _, listener := range listeners {
-		listener.Listen(ctx)
+		wg.Add(1)
+
+		go func(listener Listener) {
+			defer wg.Done()
+			listener.Listen(ctx)
+		}(listener)
 	}
+
+	return wg
 }
 
-// startConsumer will start rmq consumer
-func startConsumer(ctx context.Context, hub *rmq.Hub, event string) *rmq.Consumer {
-	conf := rmq.NewConfig()
-	conf.Exchange = "account"
-	conf.Queue = event
-	conf.RoutingKey = event
+const (
+	// exchange of account events, each event has its own queue with event name as routing key
+	exchange = "account"
+	// retryInterval is how long to wait before consumer is started again, e.g. until connection is restored
+	retryInterval = 5 * time.Second
+)
 
-	if err := hub.CreateQueue(conf); err != nil {
+// consume will declare queue of event and handle its messages until ctx is done
+func consume(ctx context.Context, b *broker.Broker, name string) {
+	if err := b.Declare(exchange, name, name); err != nil {
 		logrus.Fatal(err)
 	}
 
-	return hub.StartConsumer(ctx, conf)
-}
-
-// handleMessages is default message handler, it will only log received messages
-func handleMessages(ctx context.Context, cons *rmq.Consumer, name string) {
 	logrus.Infof("%s started", name)
 
 	defer logrus.Warnf("%s closed", name)
 
-	for {
-		select {
-		case msg := <-cons.OnMessage:
-			logrus.Infof("[%s] %s - %s", time.Now().UTC(), name, msg)
-		case err := <-cons.OnError:
-			logrus.Error(err)
-		case <-ctx.Done():
-			return
+	handle := func(ctx context.Context, msg *broker.Message) error {
+		handleMessage(ctx, msg, name)
+		messagesConsumed.WithLabelValues(name).Inc()
+
+		return nil
+	}
+
+	for ctx.Err() == nil {
+		if err := b.Consume(ctx, name, handle); err != nil {
+			logrus.Errorf("%s failed: %s", name, err)
+			messagesFailed.WithLabelValues(name).Inc()
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(retryInterval):
+			}
 		}
 	}
 }
+
+// handleMessage will continue trace from AMQP message headers and log the message
+func handleMessage(ctx context.Context, msg *broker.Message, name string) {
+	ctx = tracing.Extract(ctx, msg.Headers)
+	if id := msg.Headers[logging.RequestIDKey]; id != "" {
+		ctx = logging.WithRequestID(ctx, id)
+	}
+
+	ctx, span := tracing.Tracer().Start(ctx, "consume "+name,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("rabbitmq"),
+			semconv.MessagingDestinationKey.String(name),
+			semconv.MessagingOperationProcess,
+		))
+	defer span.End()
+
+	logging.FromContext(ctx).WithFields(logrus.Fields{
+		"event":   name,
+		"payload": string(msg.Body),
+	}).Info("message received")
+}
@@ -0,0 +1,21 @@
+package events
+
+import (
+	"context"
+	"github.com/semirm-dev/faceit/event"
+	"github.com/semirm-dev/faceit/internal/broker"
+)
+
+type totpDisabled struct {
+	broker *broker.Broker
+}
+
+func NewTOTPDisabledListener(b *broker.Broker) *totpDisabled {
+	return &totpDisabled{
+		broker: b,
+	}
+}
+
+func (ev *totpDisabled) Listen(ctx context.Context) {
+	consume(ctx, ev.broker, event.TOTPDisabled)
+}
@@ -0,0 +1,21 @@
+package events
+
+import (
+	"context"
+	"github.com/semirm-dev/faceit/event"
+	"github.com/semirm-dev/faceit/internal/broker"
+)
+
+type totpEnabled struct {
+	broker *broker.Broker
+}
+
+func NewTOTPEnabledListener(b *broker.Broker) *totpEnabled {
+	return &totpEnabled{
+		broker: b,
+	}
+}
+
+func (ev *totpEnabled) Listen(ctx context.Context) {
+	consume(ctx, ev.broker, event.TOTPEnabled)
+}
@@ -3,41 +3,117 @@ package main
 import (
 	"context"
 	"flag"
-	"github.com/gobackpack/rmq"
 	"github.com/semirm-dev/faceit/cmd/listener/events"
+	"github.com/semirm-dev/faceit/internal/broker"
+	"github.com/semirm-dev/faceit/internal/logging"
+	"github.com/semirm-dev/faceit/internal/metrics"
+	"github.com/semirm-dev/faceit/internal/tracing"
 	"github.com/sirupsen/logrus"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
-var rmqHost = flag.String("rmq_host", "localhost", "RabbitMQ host address")
+var (
+	rmqHost     = flag.String("rmq_host", "localhost", "RabbitMQ host address")
+	metricsAddr = flag.String("metrics_addr", ":8002", "Prometheus metrics http address")
+	logLevel    = flag.String("log_level", "info", "Log level: trace, debug, info, warn, error")
+
+	shutdownTimeout = flag.Duration("shutdown_timeout", 15*time.Second, "How long received messages are waited for on shutdown")
+
+	traceExporter = flag.String("trace_exporter", tracing.ExporterNone, "Trace exporter: none, stdout, otlp")
+	otlpEndpoint  = flag.String("otlp_endpoint", "localhost:4317", "OTLP grpc collector address")
+)
 
 func main() {
 	flag.Parse()
 
-	cred := rmq.NewCredentials()
-	cred.Host = *rmqHost
-	hub := rmq.NewHub(cred)
+	if err := logging.Init(*logLevel); err != nil {
+		logrus.Fatal(err)
+	}
+
+	rootCtx, rootCancel := context.WithCancel(context.Background())
+	defer rootCancel()
 
-	hubCtx, hubCancel := context.WithCancel(context.Background())
-	defer hubCancel()
+	traceConf := tracing.NewConfig("listener")
+	traceConf.Exporter = *traceExporter
+	traceConf.OtlpEndpoint = *otlpEndpoint
 
-	_, err := hub.Connect(hubCtx)
+	shutdownTracing, err := tracing.Init(rootCtx, traceConf)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	defer shutdownTracing(context.Background())
+
+	brokerConf := broker.NewConfig()
+	brokerConf.Host = *rmqHost
+
+	rmq, err := broker.Connect(rootCtx, brokerConf)
 	if err != nil {
 		logrus.Fatal(err)
 	}
 
 	// create listeners for different account actions/events
-	accountCreated := events.NewAccountCreatedListener(hub)
-	accountModified := events.NewAccountModifiedListener(hub)
-	accountDeleted := events.NewAccountDeletedListener(hub)
+	accountCreated := events.NewAccountCreatedListener(rmq)
+	accountModified := events.NewAccountModifiedListener(rmq)
+	accountDeleted := events.NewAccountDeletedListener(rmq)
+	accountRestored := events.NewAccountRestoredListener(rmq)
+	accountPurged := events.NewAccountPurgedListener(rmq)
+	accountErased := events.NewAccountErasedListener(rmq)
+	accountLocked := events.NewAccountLockedListener(rmq)
+	accountUnlocked := events.NewAccountUnlockedListener(rmq)
+	clientLocked := events.NewClientLockedListener(rmq)
+	totpEnabled := events.NewTOTPEnabledListener(rmq)
+	totpDisabled := events.NewTOTPDisabledListener(rmq)
+	accountExportReady := events.NewAccountExportReadyListener(rmq)
+
+	// consumers are cancelled at broker separately from connection, so received messages can be handled and acknowledged
+	// before connection is closed, unacknowledged ones are redelivered
+	consumeCtx, stopConsuming := context.WithCancel(rootCtx)
+	defer stopConsuming()
 
-	events.Listen(hubCtx, accountCreated, accountModified, accountDeleted)
+	listening := events.Listen(consumeCtx,
+		accountCreated, accountModified, accountDeleted,
+		accountRestored, accountPurged, accountErased,
+		accountLocked, accountUnlocked, clientLocked,
+		totpEnabled, totpDisabled,
+		accountExportReady)
 
 	logrus.Info("listening for messages...")
 
-	quit := make(chan os.Signal)
+	metricsSrv := metrics.Serve(*metricsAddr, "listener")
+
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
+
+	logrus.Warn("shutting down listener...")
+
+	stopConsuming()
+
+	drained := make(chan struct{})
+	go func() {
+		listening.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-time.After(*shutdownTimeout):
+		logrus.Warnf("listeners not drained after %s", *shutdownTimeout)
+	}
+
+	if err = rmq.Close(); err != nil {
+		logrus.Error(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+
+	if err = metricsSrv.Shutdown(ctx); err != nil {
+		logrus.Error(err)
+	}
+
+	logrus.Warn("listener exited")
 }
@@ -3,43 +3,275 @@ package main
 import (
 	"context"
 	"flag"
-	"github.com/gobackpack/crypto"
-	"github.com/gobackpack/rmq"
 	"github.com/semirm-dev/faceit/cmd/user/publisher"
+	"github.com/semirm-dev/faceit/internal/broker"
+	"github.com/semirm-dev/faceit/internal/certs"
 	"github.com/semirm-dev/faceit/internal/db"
+	"github.com/semirm-dev/faceit/internal/grpc"
+	"github.com/semirm-dev/faceit/internal/health"
+	"github.com/semirm-dev/faceit/internal/logging"
+	"github.com/semirm-dev/faceit/internal/metrics"
+	"github.com/semirm-dev/faceit/internal/tracing"
 	"github.com/semirm-dev/faceit/user"
 	"github.com/semirm-dev/faceit/user/repository"
 	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 const defaultConnStr = "host=localhost port=5432 dbname=faceit_db user=postgres password=postgres sslmode=disable"
 
 var (
-	addr       = flag.String("addr", ":8001", "User Account Service address")
-	rmqHost    = flag.String("rmq_host", "localhost", "RabbitMQ host address")
-	connString = flag.String("connStr", defaultConnStr, "User Accounts Service connection string")
+	addr            = flag.String("addr", ":8001", "User Account Service address")
+	rmqHost         = flag.String("rmq_host", "localhost", "RabbitMQ host address")
+	connString      = flag.String("connStr", defaultConnStr, "User Accounts Service connection string")
+	metricsAddr     = flag.String("metrics_addr", ":9001", "Prometheus metrics http address")
+	logLevel        = flag.String("log_level", "info", "Log level: trace, debug, info, warn, error")
+	migrateOnStart  = flag.Bool("migrate", true, "Apply pending database migrations on startup")
+	shutdownTimeout = flag.Duration("shutdown_timeout", 15*time.Second, "How long in-flight calls and pending events are waited for on shutdown")
+
+	dbMaxOpenConns     = flag.Int("db_max_open_conns", 25, "Max open database connections, 0 is unlimited")
+	dbMaxIdleConns     = flag.Int("db_max_idle_conns", 5, "Max idle database connections kept in pool")
+	dbConnMaxLifetime  = flag.Duration("db_conn_max_lifetime", 30*time.Minute, "Max time database connection may be reused, 0 is unlimited")
+	dbConnMaxIdleTime  = flag.Duration("db_conn_max_idle_time", 5*time.Minute, "Max time database connection may be idle, 0 is unlimited")
+	dbStatementTimeout = flag.Duration("db_statement_timeout", 5*time.Second, "Max duration of each database statement, 0 disables it")
+
+	grpcMaxTimeout = flag.Duration("grpc_max_timeout", 30*time.Second, "Max duration of each grpc call, 0 disables it")
+	grpcToken      = flag.String("grpc_token", "", "Bearer token required from grpc clients, auth is disabled if empty")
+	grpcKeepalive  = flag.Duration("grpc_keepalive_min_time", 10*time.Second, "Minimum interval of client keepalive pings")
+
+	tlsCert           = flag.String("tls_cert", "", "Server certificate file (PEM), grpc is served without tls if empty")
+	tlsKey            = flag.String("tls_key", "", "Server private key file (PEM)")
+	tlsCA             = flag.String("tls_ca", "", "CA file (PEM) used to verify client certificates, enables mTLS")
+	tlsReloadInterval = flag.Duration("tls_reload_interval", 30*time.Second, "How often certificate files are checked for rotation")
+
+	traceExporter = flag.String("trace_exporter", tracing.ExporterNone, "Trace exporter: none, stdout, otlp")
+	otlpEndpoint  = flag.String("otlp_endpoint", "localhost:4317", "OTLP grpc collector address")
+
+	maxAccountFailures = flag.Int("lockout_account_failures", 5, "Failed credential checks before account gets locked")
+	maxClientFailures  = flag.Int("lockout_client_failures", 20, "Failed credential checks before client ip gets locked")
+	lockoutBaseDelay   = flag.Duration("lockout_base_delay", time.Second, "Delay after first failed credential check, doubled on each next failure")
+	lockoutMaxDelay    = flag.Duration("lockout_max_delay", 30*time.Second, "Max delay between failed credential checks")
+	lockoutDuration    = flag.Duration("lockout_duration", 15*time.Minute, "How long account or client stays locked")
+
+	purgeRetention = flag.Duration("purge_retention", 30*24*time.Hour, "How long soft deleted accounts are kept before they are purged, 0 disables scheduled purge")
+	purgeInterval  = flag.Duration("purge_interval", time.Hour, "How often soft deleted accounts are checked for purge")
+
+	exportExpiry  = flag.Duration("export_expiry", 7*24*time.Hour, "How long generated account data exports can be downloaded")
+	exportTimeout = flag.Duration("export_timeout", 5*time.Minute, "Max time to generate single account data export")
+
+	pwdAlgorithm  = flag.String("pwd_algorithm", user.Argon2id, "Password hashing algorithm for new hashes: argon2id, bcrypt")
+	argon2Time    = flag.Uint("argon2_time", 3, "Argon2id number of passes")
+	argon2Memory  = flag.Uint("argon2_memory", 64*1024, "Argon2id memory in KiB")
+	argon2Threads = flag.Uint("argon2_threads", 2, "Argon2id degree of parallelism")
+	bcryptCost    = flag.Int("bcrypt_cost", 10, "BCrypt cost")
+
+	breachedPasswords = flag.String("breached_passwords", "", "Path to local HIBP Pwned Passwords extending bundled common passwords: directory of range files (PREFIX.txt with SUFFIX:COUNT lines) or file with HASH:COUNT lines")
+	breachedFpRate    = flag.Float64("breached_fp_rate", 0.001, "False positive rate of breached passwords bloom filter")
+
+	totpIssuer = flag.String("totp_issuer", "faceit", "Issuer shown in authenticator apps")
+	totpKey    = flag.String("totp_key", "", "Key used to encrypt stored totp secrets, 16, 24 or 32 bytes, 2fa is disabled if empty")
 )
 
 func main() {
 	flag.Parse()
 
-	cred := rmq.NewCredentials()
-	cred.Host = *rmqHost
-	hub := rmq.NewHub(cred)
+	if err := logging.Init(*logLevel); err != nil {
+		logrus.Fatal(err)
+	}
+
+	if flag.Arg(0) == "migrate" {
+		runMigrate(flag.Args()[1:])
+		return
+	}
+
+	if flag.Arg(0) == "seed" {
+		runSeed(flag.Args()[1:])
+		return
+	}
+
+	if err := user.ValidateTOTPKey(*totpKey); err != nil {
+		logrus.Fatal(err)
+	}
 
 	rootCtx, rootCancel := context.WithCancel(context.Background())
 	defer rootCancel()
 
-	_, err := hub.Connect(rootCtx)
+	traceConf := tracing.NewConfig("user")
+	traceConf.Exporter = *traceExporter
+	traceConf.OtlpEndpoint = *otlpEndpoint
+
+	shutdownTracing, err := tracing.Init(rootCtx, traceConf)
 	if err != nil {
 		logrus.Fatal(err)
 	}
+	defer shutdownTracing(context.Background())
+
+	brokerConf := broker.NewConfig()
+	brokerConf.Host = *rmqHost
+
+	rmq, err := broker.Connect(rootCtx, brokerConf)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	pgDb := postgresDb()
+	if pgDb != nil && *migrateOnStart {
+		migrator, err := newMigrator(pgDb)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		if err = migrator.Up(rootCtx); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
+	pub := publisher.NewAccountPublisher(rmq)
+
+	readiness := health.NewChecker()
+	readiness.Add("postgres", db.Ping(pgDb))
+	readiness.Add("rmq", pub.Ready)
+
+	conf := user.NewConfig()
+	conf.Addr = *addr
+	conf.Lockout.MaxAccountFailures = *maxAccountFailures
+	conf.Lockout.MaxClientFailures = *maxClientFailures
+	conf.Lockout.BaseDelay = *lockoutBaseDelay
+	conf.Lockout.MaxDelay = *lockoutMaxDelay
+	conf.Lockout.LockoutDuration = *lockoutDuration
+	conf.Purge.Retention = *purgeRetention
+	conf.Purge.Interval = *purgeInterval
+	if conf.Purge.Retention > 0 && conf.Purge.Interval <= 0 {
+		logrus.Fatal("purge_interval must be positive")
+	}
+	conf.Exports.Expiry = *exportExpiry
+	conf.Exports.Timeout = *exportTimeout
+	conf.TOTPIssuer = *totpIssuer
+	conf.TOTPKey = *totpKey
+	conf.Readiness = readiness
+	conf.Server.MaxTimeout = *grpcMaxTimeout
+	conf.Server.ShutdownTimeout = *shutdownTimeout
+	conf.Server.KeepaliveMinTime = *grpcKeepalive
+	if *grpcToken != "" {
+		conf.Server.Auth = grpc.TokenAuth(*grpcToken)
+	}
+	if *grpcToken == "" && *tlsCA == "" && *maxClientFailures > 0 {
+		logrus.Warn("client lockout is off: client ip is trusted only from authenticated gateway, set grpc_token or tls_ca")
+	}
+
+	certConf := certs.NewConfig()
+	certConf.CertFile = *tlsCert
+	certConf.KeyFile = *tlsKey
+	certConf.CAFile = *tlsCA
+	certConf.ReloadInterval = *tlsReloadInterval
+
+	if certConf.Enabled() {
+		if certConf.CertFile == "" {
+			logrus.Fatal("tls_cert and tls_key are required to serve tls")
+		}
+
+		reloader, err := certs.NewReloader(rootCtx, certConf)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		conf.Server.TLS = reloader.ServerTLS()
+	}
+
+	if *breachedPasswords != "" {
+		checker, err := user.LoadBreachedPasswords(*breachedPasswords, *breachedFpRate)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		// breached passwords extend bundled common passwords
+		conf.PasswordChecker = user.PasswordCheckers(conf.PasswordChecker, checker)
+
+		logrus.Infof("breached passwords loaded from %s", *breachedPasswords)
+	} else {
+		logrus.Warn("no breached passwords list loaded, only bundled common passwords are rejected, set breached_passwords")
+	}
 
 	svc := user.NewAccountService(
-		*addr,
-		repository.NewPgDb(db.PostgresDb(*connString)),
-		publisher.NewAccountPublisher(rootCtx, hub),
-		crypto.NewArgon2())
+		conf,
+		repository.NewPgDb(pgDb),
+		pub,
+		passwordHash())
+
+	metricsSrv := metrics.Serve(*metricsAddr, "user")
+
+	// rootCtx stays alive during shutdown, so events can still be published
+	serveCtx, stopServing := signal.NotifyContext(rootCtx, syscall.SIGINT, syscall.SIGTERM)
+	defer stopServing()
+
+	svc.PurgeDeleted(serveCtx)
+
+	svc.ListenForConnections(serveCtx)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+
+	// each publish waits for broker confirmation, so events are with broker once pending publishes are finished
+	if err = svc.Close(shutdownCtx); err != nil {
+		logrus.Error("failed to finish background work: ", err)
+	}
+
+	if err = rmq.Close(); err != nil {
+		logrus.Error(err)
+	}
+
+	if err = db.Close(pgDb); err != nil {
+		logrus.Error(err)
+	}
+
+	if err = metricsSrv.Shutdown(shutdownCtx); err != nil {
+		logrus.Error(err)
+	}
+
+	logrus.Warn("user service exited")
+}
+
+// postgresDb will connect database with configured pool and statement timeout, nil is returned if it fails
+func postgresDb() *gorm.DB {
+	pgDb := db.PostgresDb(*connString)
+	if pgDb == nil {
+		return nil
+	}
+
+	dbConf := db.NewConfig()
+	dbConf.MaxOpenConns = *dbMaxOpenConns
+	dbConf.MaxIdleConns = *dbMaxIdleConns
+	dbConf.ConnMaxLifetime = *dbConnMaxLifetime
+	dbConf.ConnMaxIdleTime = *dbConnMaxIdleTime
+	dbConf.StatementTimeout = *dbStatementTimeout
+
+	if err := db.Configure(pgDb, dbConf); err != nil {
+		logrus.Fatal(err)
+	}
+
+	return pgDb
+}
+
+// passwordHash will hash new passwords with configured algorithm, existing hashes of other algorithms
+// remain valid and get upgraded on successful validation
+func passwordHash() user.PasswordHash {
+	argon := user.NewArgon2idHash()
+	argon.Time = uint32(*argon2Time)
+	argon.Memory = uint32(*argon2Memory)
+	argon.Threads = uint8(*argon2Threads)
+
+	bc := user.NewBCryptHash()
+	bc.Cost = *bcryptCost
+
+	switch *pwdAlgorithm {
+	case user.Argon2id:
+		return user.NewPasswordHash(argon, bc, user.NewLegacyArgon2Hash())
+	case user.BCrypt:
+		return user.NewPasswordHash(bc, argon, user.NewLegacyArgon2Hash())
+	default:
+		logrus.Fatalf("unsupported password algorithm: %s", *pwdAlgorithm)
+	}
 
-	svc.ListenForConnections(rootCtx)
+	return nil
 }
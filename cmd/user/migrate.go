@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/semirm-dev/faceit/internal/db"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const migrateUsage = "usage: user [flags] migrate up|down|status|to VERSION"
+
+// runMigrate will run migrate subcommand: up, down, status or to VERSION
+func runMigrate(args []string) {
+	if len(args) == 0 {
+		logrus.Fatal(migrateUsage)
+	}
+
+	pgDb := postgresDb()
+	if pgDb == nil {
+		logrus.Fatal("failed to connect database")
+	}
+	defer db.Close(pgDb)
+
+	migrator, err := newMigrator(pgDb)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		err = migrator.Up(ctx)
+	case "down":
+		err = migrator.Down(ctx)
+	case "to":
+		if len(args) < 2 {
+			logrus.Fatal(migrateUsage)
+		}
+
+		version, parseErr := strconv.ParseInt(args[1], 10, 64)
+		if parseErr != nil {
+			logrus.Fatal("invalid version: ", parseErr)
+		}
+
+		err = migrator.To(ctx, version)
+	case "status":
+		err = printMigrationStatus(ctx, migrator)
+	default:
+		logrus.Fatal(migrateUsage)
+	}
+
+	if err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+func newMigrator(pgDb *gorm.DB) (*db.Migrator, error) {
+	sqlDb, err := pgDb.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	return db.NewMigrator(sqlDb, db.Migrations())
+}
+
+func printMigrationStatus(ctx context.Context, migrator *db.Migrator) error {
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+
+	for _, status := range statuses {
+		appliedAt := "pending"
+		if !status.AppliedAt.IsZero() {
+			appliedAt = status.AppliedAt.Format(time.RFC3339)
+		}
+
+		fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+	}
+
+	return w.Flush()
+}
@@ -4,55 +4,137 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"github.com/gobackpack/rmq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/semirm-dev/faceit/event"
+	"github.com/semirm-dev/faceit/internal/broker"
+	"github.com/semirm-dev/faceit/internal/logging"
+	"github.com/semirm-dev/faceit/internal/metrics"
+	"github.com/semirm-dev/faceit/internal/tracing"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var (
+	eventsPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "events",
+		Name:      "published_total",
+		Help:      "Total number of account events sent to RabbitMQ by event.",
+	}, []string{"event"})
+
+	eventsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "events",
+		Name:      "publish_failed_total",
+		Help:      "Total number of account events failed to publish by event.",
+	}, []string{"event"})
+)
+
+// exchange of account events, each event has its own queue with event name as routing key
+const exchange = "account"
+
 // accountPublisher is responsible to publish account events
 type accountPublisher struct {
-	hub  *rmq.Hub
-	conf map[string]*rmq.Publisher
+	broker *broker.Broker
+	events map[string]bool
+	// probe is queue declared again by readiness check
+	probe string
 }
 
-func NewAccountPublisher(ctx context.Context, hub *rmq.Hub) *accountPublisher {
+// NewAccountPublisher will declare queues of all account events
+func NewAccountPublisher(b *broker.Broker) *accountPublisher {
 	pub := &accountPublisher{
-		hub:  hub,
-		conf: make(map[string]*rmq.Publisher),
+		broker: b,
+		events: make(map[string]bool),
 	}
 
-	pub.setupEvents(ctx, []string{event.AccountCreated, event.AccountModified, event.AccountDeleted})
+	pub.setupEvents([]string{
+		event.AccountCreated,
+		event.AccountModified,
+		event.AccountDeleted,
+		event.AccountRestored,
+		event.AccountPurged,
+		event.AccountErased,
+		event.AccountLocked,
+		event.AccountUnlocked,
+		event.ClientLocked,
+		event.TOTPEnabled,
+		event.TOTPDisabled,
+		event.AccountExportReady,
+	})
 
 	return pub
 }
 
-func (pub *accountPublisher) Publish(event string, msg interface{}) error {
+// Publish will publish msg as JSON body, trace context and request id are sent in AMQP message headers
+func (pub *accountPublisher) Publish(ctx context.Context, ev string, msg interface{}) error {
+	ctx, span := tracing.Tracer().Start(ctx, "publish "+ev,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("rabbitmq"),
+			semconv.MessagingDestinationKey.String(ev),
+		))
+	defer span.End()
+
+	if !pub.events[ev] {
+		return pub.failed(span, ev, errors.New("invalid account event"))
+	}
+
 	b, err := json.Marshal(msg)
 	if err != nil {
-		return err
+		return pub.failed(span, ev, err)
 	}
 
-	rmqPub := pub.conf[event]
-	if rmqPub == nil {
-		return errors.New("invalid account event")
+	headers := make(map[string]string)
+	tracing.Inject(ctx, headers)
+	if id := logging.RequestID(ctx); id != "" {
+		headers[logging.RequestIDKey] = id
 	}
 
-	pub.hub.Publish(b, rmqPub)
+	if err = pub.broker.Publish(ctx, exchange, ev, &broker.Message{Headers: headers, Body: b}); err != nil {
+		return pub.failed(span, ev, err)
+	}
+	eventsPublished.WithLabelValues(ev).Inc()
 
 	return nil
 }
 
-func (pub *accountPublisher) setupEvents(ctx context.Context, events []string) {
-	for _, ev := range events {
-		conf := rmq.NewConfig()
-		conf.Exchange = "account"
-		conf.Queue = ev
-		conf.RoutingKey = ev
+func (pub *accountPublisher) failed(span trace.Span, ev string, err error) error {
+	eventsFailed.WithLabelValues(ev).Inc()
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
 
-		if err := pub.hub.CreateQueue(conf); err != nil {
+	return err
+}
+
+// Ready actively checks RabbitMQ connection by declaring one of account queues again,
+// it's no-op for broker but fails once connection is closed
+func (pub *accountPublisher) Ready(ctx context.Context) error {
+	declared := make(chan error, 1)
+	go func() {
+		declared <- pub.broker.Declare(exchange, pub.probe, pub.probe)
+	}()
+
+	select {
+	case err := <-declared:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (pub *accountPublisher) setupEvents(events []string) {
+	for _, ev := range events {
+		if err := pub.broker.Declare(exchange, ev, ev); err != nil {
 			logrus.Fatal(err)
 		}
 
-		pub.conf[ev] = pub.hub.CreatePublisher(ctx, conf)
+		pub.events[ev] = true
+		if pub.probe == "" {
+			pub.probe = ev
+		}
 	}
 }
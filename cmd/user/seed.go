@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/semirm-dev/faceit/internal/db"
+	"github.com/semirm-dev/faceit/internal/seed"
+	"github.com/semirm-dev/faceit/user/repository"
+	"github.com/sirupsen/logrus"
+)
+
+const seedUsage = "usage: user [flags] seed [-file accounts.yaml] [-fake N] [-fake_password PASSWORD]"
+
+// runSeed will run seed subcommand, accounts from fixtures file and/or n fake accounts are created if missing
+func runSeed(args []string) {
+	fs := flag.NewFlagSet("seed", flag.ExitOnError)
+	file := fs.String("file", "", "Fixtures file with accounts, .yaml, .yml or .json")
+	fake := fs.Int("fake", 0, "Number of fake accounts to generate across countries")
+	fakePassword := fs.String("fake_password", "Seed-password-1", "Password of generated fake accounts")
+	if err := fs.Parse(args); err != nil {
+		logrus.Fatal(err)
+	}
+
+	if *file == "" && *fake <= 0 {
+		logrus.Fatal(seedUsage)
+	}
+
+	fixtures := &seed.Fixtures{}
+	if *file != "" {
+		loaded, err := seed.LoadFile(*file)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		fixtures = loaded
+	}
+	fixtures.Accounts = append(fixtures.Accounts, seed.Fake(*fake, *fakePassword)...)
+
+	pgDb := postgresDb()
+	if pgDb == nil {
+		logrus.Fatal("failed to connect database")
+	}
+	defer db.Close(pgDb)
+
+	ctx := context.Background()
+
+	if *migrateOnStart {
+		migrator, err := newMigrator(pgDb)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		if err = migrator.Up(ctx); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
+	result, err := seed.NewSeeder(repository.NewPgDb(pgDb), passwordHash()).Apply(ctx, fixtures)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	logrus.Infof("seed finished, %d accounts created, %d already existed", result.Created, result.Skipped)
+}
@@ -4,4 +4,14 @@ const (
 	AccountCreated  = "account_created"
 	AccountModified = "account_modified"
 	AccountDeleted  = "account_deleted"
+	AccountRestored = "account_restored"
+	AccountPurged   = "account_purged"
+	AccountErased   = "account_erased"
+	AccountLocked   = "account_locked"
+	AccountUnlocked = "account_unlocked"
+	ClientLocked    = "client_locked"
+	TOTPEnabled     = "totp_enabled"
+	TOTPDisabled    = "totp_disabled"
+	// AccountExportReady carries export id, not account id
+	AccountExportReady = "account_export_ready"
 )
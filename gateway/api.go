@@ -1,19 +1,33 @@
 package gateway
 
 import (
+	"context"
 	"github.com/semirm-dev/faceit/internal/grpc"
 	pbUser "github.com/semirm-dev/faceit/user/proto"
+	grpcLib "google.golang.org/grpc"
 )
 
 type api struct {
+	conn      *grpcLib.ClientConn
 	rpcClient pbUser.AccountManagementClient
 }
 
-func NewApi(accAddr string) *api {
-	conn := grpc.CreateClientConnection(accAddr)
+func NewApi(accAddr string, opts *grpc.ClientOptions) *api {
+	conn := grpc.CreateClientConnection(accAddr, opts)
 	client := pbUser.NewAccountManagementClient(conn)
 
 	return &api{
+		conn:      conn,
 		rpcClient: client,
 	}
 }
+
+// AccountServiceReady reports grpc health status of account service
+func (api *api) AccountServiceReady(ctx context.Context) error {
+	return grpc.ServingStatus(ctx, api.conn, pbUser.AccountManagement_ServiceDesc.ServiceName)
+}
+
+// Close will close connection to account service
+func (api *api) Close() error {
+	return api.conn.Close()
+}
@@ -0,0 +1,108 @@
+package gateway
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/semirm-dev/faceit/internal/logging"
+	"github.com/semirm-dev/faceit/user"
+	pbUser "github.com/semirm-dev/faceit/user/proto"
+	"net/http"
+)
+
+const (
+	// APIKeyHeader carries api key of internal services, alternative to user tokens
+	APIKeyHeader = "X-API-Key"
+
+	apiKeyCtx = "api_key"
+)
+
+// APIKeyAuth will validate api key if request has one, requests without api key are passed through
+func (api *api) APIKeyAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		plain := c.GetHeader(APIKeyHeader)
+		if plain == "" {
+			c.Next()
+			return
+		}
+
+		key, err := api.rpcClient.ValidateAPIKey(rpcContext(c), &pbUser.ValidateAPIKeyRequest{
+			Key: plain,
+		})
+		if err != nil {
+			logging.FromContext(c.Request.Context()).Error(err)
+			if unavailable(c, err) {
+				return
+			}
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		c.Set(apiKeyCtx, key)
+		c.Next()
+	}
+}
+
+// RequireScope will allow only requests authenticated with api key that has given scope,
+// keys owned by account are allowed only on routes of that account
+func (api *api) RequireScope(scope string) gin.HandlerFunc {
+	return requireAPIKey(func(c *gin.Context, key *pbUser.APIKeyMessage) bool {
+		return hasScope(c, key, scope) && (key.AccountId == "" || ownsAccount(c, key))
+	})
+}
+
+// RequireOwnerOrAdmin will allow only requests authenticated with api key that has admin scope,
+// or is owned by account of the route and has given scope
+func (api *api) RequireOwnerOrAdmin(scope string) gin.HandlerFunc {
+	return requireAPIKey(func(c *gin.Context, key *pbUser.APIKeyMessage) bool {
+		if key.AccountId == "" {
+			return hasScope(c, key, user.ScopeAdmin)
+		}
+
+		return ownsAccount(c, key) && hasScope(c, key, scope)
+	})
+}
+
+// requireAPIKey will reject requests without api key with 401, and requests whose key is not allowed with 403
+func requireAPIKey(allowed func(c *gin.Context, key *pbUser.APIKeyMessage) bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, ok := c.Get(apiKeyCtx)
+		if !ok {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		if !allowed(c, value.(*pbUser.APIKeyMessage)) {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func hasScope(c *gin.Context, key *pbUser.APIKeyMessage, scope string) bool {
+	if !(&user.APIKey{Scopes: key.Scopes}).HasScope(scope) {
+		logging.FromContext(c.Request.Context()).Warnf("api key %s is missing scope %s", key.Id, scope)
+		return false
+	}
+
+	return true
+}
+
+func ownsAccount(c *gin.Context, key *pbUser.APIKeyMessage) bool {
+	if key.AccountId != c.Param("id") {
+		logging.FromContext(c.Request.Context()).Warnf("api key %s is not owned by account of route", key.Id)
+		return false
+	}
+
+	return true
+}
+
+// APIKeyID identifies client by id of validated api key, empty for requests without api key
+func APIKeyID(c *gin.Context) string {
+	value, ok := c.Get(apiKeyCtx)
+	if !ok {
+		return ""
+	}
+
+	return value.(*pbUser.APIKeyMessage).Id
+}
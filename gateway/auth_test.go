@@ -0,0 +1,117 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/semirm-dev/faceit/user"
+	pbUser "github.com/semirm-dev/faceit/user/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// mockKeysClient validates api keys by their plain value
+type mockKeysClient struct {
+	pbUser.AccountManagementClient
+	keys map[string]*pbUser.APIKeyMessage
+}
+
+func (client *mockKeysClient) ValidateAPIKey(ctx context.Context, in *pbUser.ValidateAPIKeyRequest, opts ...grpc.CallOption) (*pbUser.APIKeyMessage, error) {
+	key, ok := client.keys[in.Key]
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, user.ErrInvalidAPIKey.Error())
+	}
+
+	return key, nil
+}
+
+func testRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	api := &api{
+		rpcClient: &mockKeysClient{
+			keys: map[string]*pbUser.APIKeyMessage{
+				"admin":        {Id: "1", Scopes: []string{user.ScopeAdmin}},
+				"service":      {Id: "2", Scopes: []string{user.ScopeAccountsRead, user.ScopeAccountsWrite}},
+				"owner":        {Id: "3", Scopes: []string{user.ScopeAccountsRead, user.ScopeAccountsWrite}, AccountId: "123"},
+				"owner-reader": {Id: "4", Scopes: []string{user.ScopeAccountsRead}, AccountId: "123"},
+			},
+		},
+	}
+
+	ok := func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	}
+
+	router := gin.New()
+	router.Use(api.APIKeyAuth())
+	router.POST("users/:id/export", api.RequireOwnerOrAdmin(user.ScopeAccountsWrite), ok)
+	router.GET("users/:id", api.RequireScope(user.ScopeAccountsRead), ok)
+
+	return router
+}
+
+func TestApi_RequireOwnerOrAdmin(t *testing.T) {
+	testCases := []struct {
+		name     string
+		key      string
+		id       string
+		expected int
+	}{
+		{name: "without api key", id: "123", expected: http.StatusUnauthorized},
+		{name: "invalid api key", key: "invalid", id: "123", expected: http.StatusUnauthorized},
+		{name: "admin", key: "admin", id: "123", expected: http.StatusOK},
+		{name: "service without admin scope", key: "service", id: "123", expected: http.StatusForbidden},
+		{name: "owner", key: "owner", id: "123", expected: http.StatusOK},
+		{name: "owner of other account", key: "owner", id: "456", expected: http.StatusForbidden},
+		{name: "owner without scope", key: "owner-reader", id: "123", expected: http.StatusForbidden},
+	}
+
+	router := testRouter()
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/users/"+tc.id+"/export", nil)
+			if tc.key != "" {
+				req.Header.Set(APIKeyHeader, tc.key)
+			}
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.expected, rec.Code)
+		})
+	}
+}
+
+func TestApi_RequireScope_OwnerKey_OnlyOwnAccount(t *testing.T) {
+	testCases := []struct {
+		name     string
+		key      string
+		id       string
+		expected int
+	}{
+		{name: "service", key: "service", id: "456", expected: http.StatusOK},
+		{name: "owner", key: "owner", id: "123", expected: http.StatusOK},
+		{name: "owner of other account", key: "owner", id: "456", expected: http.StatusForbidden},
+	}
+
+	router := testRouter()
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/users/"+tc.id, nil)
+			req.Header.Set(APIKeyHeader, tc.key)
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.expected, rec.Code)
+		})
+	}
+}
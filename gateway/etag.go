@@ -0,0 +1,54 @@
+package gateway
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrInvalidIfMatch is returned for If-Match values which are not account version etags
+var ErrInvalidIfMatch = errors.New("If-Match must be account version etag, for example \"3\"")
+
+// etag is strong entity tag of account version
+func etag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// ifMatch returns account version expected by If-Match header, 0 if header is missing or "*".
+// Weak tags are accepted too, account version changes on every modification anyway.
+func ifMatch(c *gin.Context) (int64, error) {
+	value := strings.TrimSpace(c.GetHeader("If-Match"))
+	if value == "" || value == "*" {
+		return 0, nil
+	}
+
+	value = strings.TrimPrefix(value, "W/")
+
+	unquoted, err := strconv.Unquote(value)
+	if err != nil {
+		return 0, ErrInvalidIfMatch
+	}
+
+	version, err := strconv.ParseInt(unquoted, 10, 64)
+	if err != nil || version < 1 {
+		return 0, ErrInvalidIfMatch
+	}
+
+	return version, nil
+}
+
+// preconditionFailed will respond with 412 if account version did not match If-Match
+func preconditionFailed(c *gin.Context, err error) bool {
+	if status.Code(err) != codes.Aborted {
+		return false
+	}
+
+	c.JSON(http.StatusPreconditionFailed, status.Convert(err).Message())
+
+	return true
+}
@@ -0,0 +1,82 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/semirm-dev/faceit/internal/logging"
+	"github.com/semirm-dev/faceit/user"
+	pbUser "github.com/semirm-dev/faceit/user/proto"
+	grpcLib "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// exportMessageOverhead is room for export status fields next to archive
+const exportMessageOverhead = 1 << 20
+
+// ExportAccountData will start generating account data export, its status url is returned in Location header
+func (api *api) ExportAccountData() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idParam := c.Param("id")
+
+		export, err := api.rpcClient.ExportAccountData(rpcContext(c), &pbUser.ExportAccountDataRequest{
+			Id: idParam,
+		})
+		if err != nil {
+			logging.FromContext(c.Request.Context()).Error(err)
+			if unavailable(c, err) {
+				return
+			}
+			c.JSON(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		c.Header("Location", fmt.Sprintf("/users/%s/export/%s", export.AccountId, export.Id))
+		c.JSON(http.StatusAccepted, export)
+	}
+}
+
+// GetAccountExport will respond with zip archive once export is ready, and with export status until then
+func (api *api) GetAccountExport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idParam := c.Param("id")
+		exportIdParam := c.Param("export_id")
+
+		// archive is sent in single message, it's larger than default receive limit
+		export, err := api.rpcClient.GetAccountExport(rpcContext(c), &pbUser.GetAccountExportRequest{
+			Id:        exportIdParam,
+			AccountId: idParam,
+		}, grpcLib.MaxCallRecvMsgSize(user.MaxExportSize+exportMessageOverhead))
+		if err != nil {
+			logging.FromContext(c.Request.Context()).Error(err)
+			if unavailable(c, err) || notFound(c, err) {
+				return
+			}
+			c.JSON(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		switch export.Status {
+		case user.ExportReady:
+			c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"account-%s-export.zip\"", export.AccountId))
+			c.Data(http.StatusOK, "application/zip", export.Archive)
+		case user.ExportPending:
+			c.JSON(http.StatusAccepted, export)
+		default:
+			c.JSON(http.StatusOK, export)
+		}
+	}
+}
+
+// notFound will respond with 404 if account service reported missing resource
+func notFound(c *gin.Context, err error) bool {
+	if status.Code(err) != codes.NotFound {
+		return false
+	}
+
+	c.JSON(http.StatusNotFound, status.Convert(err).Message())
+
+	return true
+}
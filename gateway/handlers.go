@@ -1,9 +1,12 @@
 package gateway
 
 import (
+	"context"
 	"github.com/gin-gonic/gin"
+	"github.com/semirm-dev/faceit/internal/logging"
+	"github.com/semirm-dev/faceit/user"
 	pbUser "github.com/semirm-dev/faceit/user/proto"
-	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/metadata"
 	"net/http"
 	"strconv"
 )
@@ -28,18 +31,39 @@ type ModifyAccount struct {
 type ChangePassword struct {
 	OldPassword string `json:"old_password"`
 	NewPassword string `json:"new_password"`
+	TOTPCode    string `json:"totp_code"`
+}
+
+type CreateAPIKey struct {
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes"`
+	TTLSeconds int64    `json:"ttl_seconds"`
+	AccountId  string   `json:"account_id"`
+}
+
+type EnrollTOTP struct {
+	Password string `json:"password"`
+}
+
+type TOTPCode struct {
+	Code string `json:"code"`
+}
+
+type DisableTOTP struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
 }
 
 func (api *api) CreateAccount() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req *CreateAccount
 		if err := c.ShouldBindJSON(&req); err != nil {
-			logrus.Error(err)
+			logging.FromContext(c.Request.Context()).Error(err)
 			c.AbortWithStatus(http.StatusBadRequest)
 			return
 		}
 
-		account, err := api.rpcClient.AddAccount(c.Request.Context(), &pbUser.AccountRequest{
+		account, err := api.rpcClient.AddAccount(rpcContext(c), &pbUser.AccountRequest{
 			FirstName: req.Firstname,
 			LastName:  req.Lastname,
 			Nickname:  req.Nickname,
@@ -48,7 +72,10 @@ func (api *api) CreateAccount() gin.HandlerFunc {
 			Country:   req.Country,
 		})
 		if err != nil {
-			logrus.Error(err)
+			logging.FromContext(c.Request.Context()).Error(err)
+			if unavailable(c, err) {
+				return
+			}
 			c.JSON(http.StatusBadRequest, err.Error())
 			return
 		}
@@ -61,27 +88,38 @@ func (api *api) ModifyAccount() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		idParam := c.Param("id")
 
+		version, err := ifMatch(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, err.Error())
+			return
+		}
+
 		var req *ModifyAccount
-		if err := c.ShouldBindJSON(&req); err != nil {
-			logrus.Error(err)
+		if err = c.ShouldBindJSON(&req); err != nil {
+			logging.FromContext(c.Request.Context()).Error(err)
 			c.AbortWithStatus(http.StatusBadRequest)
 			return
 		}
 
-		account, err := api.rpcClient.ModifyAccount(c.Request.Context(), &pbUser.AccountMessage{
+		account, err := api.rpcClient.ModifyAccount(rpcContext(c), &pbUser.AccountMessage{
 			Id:        idParam,
 			FirstName: req.Firstname,
 			LastName:  req.Lastname,
 			Nickname:  req.Nickname,
 			Email:     req.Email,
 			Country:   req.Country,
+			Version:   version,
 		})
 		if err != nil {
-			logrus.Error(err)
+			logging.FromContext(c.Request.Context()).Error(err)
+			if unavailable(c, err) || preconditionFailed(c, err) {
+				return
+			}
 			c.JSON(http.StatusBadRequest, err.Error())
 			return
 		}
 
+		c.Header("ETag", etag(account.Version))
 		c.JSON(http.StatusOK, account)
 	}
 }
@@ -90,24 +128,36 @@ func (api *api) ChangePassword() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		idParam := c.Param("id")
 
+		version, err := ifMatch(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, err.Error())
+			return
+		}
+
 		var req *ChangePassword
-		if err := c.ShouldBindJSON(&req); err != nil {
-			logrus.Error(err)
+		if err = c.ShouldBindJSON(&req); err != nil {
+			logging.FromContext(c.Request.Context()).Error(err)
 			c.AbortWithStatus(http.StatusBadRequest)
 			return
 		}
 
-		resp, err := api.rpcClient.ChangePassword(c.Request.Context(), &pbUser.ChangePasswordRequest{
+		resp, err := api.rpcClient.ChangePassword(rpcContext(c), &pbUser.ChangePasswordRequest{
 			Id:          idParam,
 			OldPassword: req.OldPassword,
 			NewPassword: req.NewPassword,
+			TotpCode:    req.TOTPCode,
+			Version:     version,
 		})
 		if err != nil {
-			logrus.Error(err)
+			logging.FromContext(c.Request.Context()).Error(err)
+			if unavailable(c, err) || preconditionFailed(c, err) {
+				return
+			}
 			c.JSON(http.StatusBadRequest, err.Error())
 			return
 		}
 
+		c.Header("ETag", etag(resp.Version))
 		c.JSON(http.StatusOK, resp)
 	}
 }
@@ -116,11 +166,96 @@ func (api *api) DeleteAccount() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		idParam := c.Param("id")
 
-		resp, err := api.rpcClient.DeleteAccount(c.Request.Context(), &pbUser.DeleteAccountRequest{
+		resp, err := api.rpcClient.DeleteAccount(rpcContext(c), &pbUser.DeleteAccountRequest{
+			Id: idParam,
+		})
+		if err != nil {
+			logging.FromContext(c.Request.Context()).Error(err)
+			if unavailable(c, err) {
+				return
+			}
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+func (api *api) RestoreAccount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idParam := c.Param("id")
+
+		account, err := api.rpcClient.RestoreAccount(rpcContext(c), &pbUser.RestoreAccountRequest{
+			Id: idParam,
+		})
+		if err != nil {
+			logging.FromContext(c.Request.Context()).Error(err)
+			if unavailable(c, err) {
+				return
+			}
+			c.JSON(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		c.Header("ETag", etag(account.Version))
+		c.JSON(http.StatusOK, account)
+	}
+}
+
+func (api *api) PurgeAccount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idParam := c.Param("id")
+
+		resp, err := api.rpcClient.PurgeAccount(rpcContext(c), &pbUser.PurgeAccountRequest{
+			Id: idParam,
+		})
+		if err != nil {
+			logging.FromContext(c.Request.Context()).Error(err)
+			if unavailable(c, err) {
+				return
+			}
+			c.JSON(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+func (api *api) EraseAccount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idParam := c.Param("id")
+
+		resp, err := api.rpcClient.EraseAccount(rpcContext(c), &pbUser.EraseAccountRequest{
 			Id: idParam,
 		})
 		if err != nil {
-			logrus.Error(err)
+			logging.FromContext(c.Request.Context()).Error(err)
+			if unavailable(c, err) {
+				return
+			}
+			c.JSON(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+func (api *api) UnlockAccount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idParam := c.Param("id")
+
+		resp, err := api.rpcClient.UnlockAccount(rpcContext(c), &pbUser.UnlockAccountRequest{
+			Id:       idParam,
+			ClientIp: c.Query("client_ip"),
+		})
+		if err != nil {
+			logging.FromContext(c.Request.Context()).Error(err)
+			if unavailable(c, err) {
+				return
+			}
 			c.AbortWithStatus(http.StatusBadRequest)
 			return
 		}
@@ -129,6 +264,183 @@ func (api *api) DeleteAccount() gin.HandlerFunc {
 	}
 }
 
+func (api *api) EnrollTOTP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idParam := c.Param("id")
+
+		var req *EnrollTOTP
+		if err := c.ShouldBindJSON(&req); err != nil {
+			logging.FromContext(c.Request.Context()).Error(err)
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		resp, err := api.rpcClient.EnrollTOTP(rpcContext(c), &pbUser.EnrollTOTPRequest{
+			Id:       idParam,
+			Password: req.Password,
+		})
+		if err != nil {
+			logging.FromContext(c.Request.Context()).Error(err)
+			if unavailable(c, err) {
+				return
+			}
+			c.JSON(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+func (api *api) ConfirmTOTP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idParam := c.Param("id")
+
+		var req *TOTPCode
+		if err := c.ShouldBindJSON(&req); err != nil {
+			logging.FromContext(c.Request.Context()).Error(err)
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		resp, err := api.rpcClient.ConfirmTOTP(rpcContext(c), &pbUser.ConfirmTOTPRequest{
+			Id:   idParam,
+			Code: req.Code,
+		})
+		if err != nil {
+			logging.FromContext(c.Request.Context()).Error(err)
+			if unavailable(c, err) {
+				return
+			}
+			c.JSON(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+func (api *api) DisableTOTP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idParam := c.Param("id")
+
+		var req *DisableTOTP
+		if err := c.ShouldBindJSON(&req); err != nil {
+			logging.FromContext(c.Request.Context()).Error(err)
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		resp, err := api.rpcClient.DisableTOTP(rpcContext(c), &pbUser.DisableTOTPRequest{
+			Id:       idParam,
+			Password: req.Password,
+			Code:     req.Code,
+		})
+		if err != nil {
+			logging.FromContext(c.Request.Context()).Error(err)
+			if unavailable(c, err) {
+				return
+			}
+			c.JSON(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+func (api *api) RegenerateRecoveryCodes() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idParam := c.Param("id")
+
+		var req *TOTPCode
+		if err := c.ShouldBindJSON(&req); err != nil {
+			logging.FromContext(c.Request.Context()).Error(err)
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		resp, err := api.rpcClient.RegenerateRecoveryCodes(rpcContext(c), &pbUser.RegenerateRecoveryCodesRequest{
+			Id:   idParam,
+			Code: req.Code,
+		})
+		if err != nil {
+			logging.FromContext(c.Request.Context()).Error(err)
+			if unavailable(c, err) {
+				return
+			}
+			c.JSON(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+func (api *api) CreateAPIKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req *CreateAPIKey
+		if err := c.ShouldBindJSON(&req); err != nil {
+			logging.FromContext(c.Request.Context()).Error(err)
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		resp, err := api.rpcClient.CreateAPIKey(rpcContext(c), &pbUser.CreateAPIKeyRequest{
+			Name:       req.Name,
+			Scopes:     req.Scopes,
+			TtlSeconds: req.TTLSeconds,
+			AccountId:  req.AccountId,
+		})
+		if err != nil {
+			logging.FromContext(c.Request.Context()).Error(err)
+			if unavailable(c, err) {
+				return
+			}
+			c.JSON(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+func (api *api) RevokeAPIKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idParam := c.Param("id")
+
+		resp, err := api.rpcClient.RevokeAPIKey(rpcContext(c), &pbUser.RevokeAPIKeyRequest{
+			Id: idParam,
+		})
+		if err != nil {
+			logging.FromContext(c.Request.Context()).Error(err)
+			if unavailable(c, err) {
+				return
+			}
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+func (api *api) GetAPIKeys() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		resp, err := api.rpcClient.GetAPIKeys(rpcContext(c), &pbUser.GetAPIKeysRequest{})
+		if err != nil {
+			logging.FromContext(c.Request.Context()).Error(err)
+			if unavailable(c, err) {
+				return
+			}
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		c.JSON(http.StatusOK, resp.ApiKeys)
+	}
+}
+
 func (api *api) GetAccounts() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var page, limit int
@@ -150,14 +462,21 @@ func (api *api) GetAccounts() gin.HandlerFunc {
 		}
 
 		country, _ := c.GetQuery("country")
+		includeDeleted, _ := strconv.ParseBool(c.Query("include_deleted"))
+		onlyDeleted, _ := strconv.ParseBool(c.Query("only_deleted"))
 
-		resp, err := api.rpcClient.GetAccountsByFilter(c.Request.Context(), &pbUser.GetAccountsByFilterRequest{
-			Page:    int64(page),
-			Limit:   int64(limit),
-			Country: country,
+		resp, err := api.rpcClient.GetAccountsByFilter(rpcContext(c), &pbUser.GetAccountsByFilterRequest{
+			Page:           int64(page),
+			Limit:          int64(limit),
+			Country:        country,
+			IncludeDeleted: includeDeleted,
+			OnlyDeleted:    onlyDeleted,
 		})
 		if err != nil {
-			logrus.Error(err)
+			logging.FromContext(c.Request.Context()).Error(err)
+			if unavailable(c, err) {
+				return
+			}
 			c.AbortWithStatus(http.StatusBadRequest)
 			return
 		}
@@ -165,3 +484,43 @@ func (api *api) GetAccounts() gin.HandlerFunc {
 		c.JSON(http.StatusOK, resp.Accounts)
 	}
 }
+
+func (api *api) GetAuditLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, _ := strconv.Atoi(c.Query("page"))
+		limit, _ := strconv.Atoi(c.Query("limit"))
+
+		resp, err := api.rpcClient.GetAuditLog(rpcContext(c), &pbUser.GetAuditLogRequest{
+			Page:     int64(page),
+			Limit:    int64(limit),
+			TargetId: c.Query("target_id"),
+			Actor:    c.Query("actor"),
+			Action:   c.Query("action"),
+			Since:    c.Query("since"),
+			Until:    c.Query("until"),
+		})
+		if err != nil {
+			logging.FromContext(c.Request.Context()).Error(err)
+			if unavailable(c, err) {
+				return
+			}
+			c.JSON(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, resp.Entries)
+	}
+}
+
+// rpcContext will forward original client ip to account service, used for brute-force protection,
+// and api key of the client, recorded in audit log as actor
+func rpcContext(c *gin.Context) context.Context {
+	ctx := metadata.AppendToOutgoingContext(c.Request.Context(), user.ClientIPKey, c.ClientIP())
+
+	// only validated key is forwarded, account service records it as actor
+	if APIKeyID(c) != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, user.APIKeyKey, c.GetHeader(APIKeyHeader))
+	}
+
+	return ctx
+}
@@ -0,0 +1,96 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/semirm-dev/faceit/internal/logging"
+	pbUser "github.com/semirm-dev/faceit/user/proto"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+const mergePatchContentType = "application/merge-patch+json"
+
+var ErrInvalidMergePatch = errors.New("merge patch must be json object")
+
+// PatchAccount will update only account fields present in JSON merge patch (RFC 7396), null clears the field
+func (api *api) PatchAccount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idParam := c.Param("id")
+
+		contentType, _, _ := mime.ParseMediaType(c.ContentType())
+		if contentType != mergePatchContentType && contentType != gin.MIMEJSON {
+			c.Header("Accept-Patch", mergePatchContentType)
+			c.AbortWithStatus(http.StatusUnsupportedMediaType)
+			return
+		}
+
+		version, err := ifMatch(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		var patch map[string]json.RawMessage
+		if err = c.ShouldBindJSON(&patch); err != nil || patch == nil {
+			logging.FromContext(c.Request.Context()).Error(err)
+			c.JSON(http.StatusBadRequest, ErrInvalidMergePatch.Error())
+			return
+		}
+
+		req, err := mergePatchToProto(patch)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, err.Error())
+			return
+		}
+		req.Id = idParam
+		req.Version = version
+
+		account, err := api.rpcClient.ModifyAccount(rpcContext(c), req)
+		if err != nil {
+			logging.FromContext(c.Request.Context()).Error(err)
+			if unavailable(c, err) || preconditionFailed(c, err) {
+				return
+			}
+			c.JSON(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		c.Header("ETag", etag(account.Version))
+		c.JSON(http.StatusOK, account)
+	}
+}
+
+// mergePatchToProto will create modify request with update mask of patched fields, all of them are strings
+func mergePatchToProto(patch map[string]json.RawMessage) (*pbUser.AccountMessage, error) {
+	req := &pbUser.AccountMessage{
+		UpdateMask: &fieldmaskpb.FieldMask{},
+	}
+
+	for field, raw := range patch {
+		target := req.ModifiableField(field)
+		if target == nil {
+			return nil, fmt.Errorf("field %s can not be modified", field)
+		}
+
+		// null removes the value, which for account fields means empty string
+		var value *string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, fmt.Errorf("field %s must be string or null", field)
+		}
+		if value != nil {
+			*target = *value
+		}
+
+		req.UpdateMask.Paths = append(req.UpdateMask.Paths, field)
+	}
+
+	sort.Strings(req.UpdateMask.Paths)
+
+	return req, nil
+}
@@ -0,0 +1,79 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/semirm-dev/faceit/internal/grpc"
+	pbUser "github.com/semirm-dev/faceit/user/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"math"
+	"net/http"
+	"time"
+)
+
+// readMethods are idempotent account service methods, safe to retry
+var readMethods = []string{
+	"GetAccountsByFilter",
+	"GetAPIKeys",
+	"ValidateAPIKey",
+}
+
+// ServiceConfig returns grpc service config for account service: timeout of write methods, and timeout with
+// retry policy of read methods. Retries stop when too many calls fail, to not overload struggling service.
+func ServiceConfig(writeTimeout, readTimeout time.Duration, readAttempts int) *grpc.ServiceConfig {
+	service := pbUser.AccountManagement_ServiceDesc.ServiceName
+
+	reads := &grpc.MethodConfig{
+		Timeout: grpc.Duration(readTimeout),
+	}
+	for _, method := range readMethods {
+		reads.Name = append(reads.Name, grpc.MethodName{Service: service, Method: method})
+	}
+	if readAttempts > 1 {
+		reads.RetryPolicy = &grpc.RetryPolicy{
+			MaxAttempts:          readAttempts,
+			InitialBackoff:       "0.1s",
+			MaxBackoff:           "1s",
+			BackoffMultiplier:    2,
+			RetryableStatusCodes: []string{"UNAVAILABLE"},
+		}
+	}
+
+	return &grpc.ServiceConfig{
+		MethodConfig: []*grpc.MethodConfig{
+			{
+				Name:    []grpc.MethodName{{Service: service}},
+				Timeout: grpc.Duration(writeTimeout),
+			},
+			reads,
+		},
+		RetryThrottling: &grpc.RetryThrottling{
+			MaxTokens:  10,
+			TokenRatio: 0.1,
+		},
+	}
+}
+
+// unavailable will respond with 503 if account service can not be reached, with Retry-After while circuit
+// breaker is open, or 504 if it did not respond in time. Returns false for all other errors.
+func unavailable(c *gin.Context, err error) bool {
+	var circuitErr *grpc.CircuitOpenError
+	if errors.As(err, &circuitErr) {
+		c.Header("Retry-After", fmt.Sprint(int(math.Ceil(circuitErr.RetryAfter.Seconds()))))
+		c.AbortWithStatus(http.StatusServiceUnavailable)
+		return true
+	}
+
+	switch status.Code(err) {
+	case codes.Unavailable:
+		c.AbortWithStatus(http.StatusServiceUnavailable)
+		return true
+	case codes.DeadlineExceeded:
+		c.AbortWithStatus(http.StatusGatewayTimeout)
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,360 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+var (
+	ErrClosed   = errors.New("broker is closed")
+	ErrNotAcked = errors.New("message was not acknowledged by broker")
+)
+
+// Config of RabbitMQ connection
+type Config struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	// ReconnectInterval is how long to wait before each reconnection attempt
+	ReconnectInterval time.Duration
+}
+
+// NewConfig will initialize default config, credentials can be set with RMQ_PORT, RMQ_USERNAME and RMQ_PASSWORD
+func NewConfig() *Config {
+	return &Config{
+		Host:              env("RMQ_HOST", "localhost"),
+		Port:              env("RMQ_PORT", "5672"),
+		Username:          env("RMQ_USERNAME", "guest"),
+		Password:          env("RMQ_PASSWORD", "guest"),
+		ReconnectInterval: 5 * time.Second,
+	}
+}
+
+// Message is received or published message, headers are AMQP message headers
+type Message struct {
+	Headers map[string]string
+	Body    []byte
+}
+
+// Handler handles consumed message, message is acknowledged only if it returns nil
+type Handler func(ctx context.Context, msg *Message) error
+
+// Broker keeps connection to RabbitMQ, it's reconnected when lost
+type Broker struct {
+	conf *Config
+
+	mu     sync.RWMutex
+	conn   *amqp.Connection
+	closed bool
+
+	// pubMu serializes publishing on shared channel, so confirmations arrive in order of publishing
+	pubMu       sync.Mutex
+	pubCh       *amqp.Channel
+	pubClosed   chan *amqp.Error
+	pubConfirms chan amqp.Confirmation
+
+	consumers uint64
+}
+
+// Connect will connect to RabbitMQ, connection is reconnected in background until ctx is done or broker is closed
+func Connect(ctx context.Context, conf *Config) (*Broker, error) {
+	if conf == nil {
+		conf = NewConfig()
+	}
+
+	b := &Broker{
+		conf: conf,
+	}
+
+	conn, lost, err := b.dial()
+	if err != nil {
+		return nil, err
+	}
+	b.conn = conn
+
+	go b.reconnect(ctx, lost)
+
+	return b, nil
+}
+
+// Declare will declare durable direct exchange and durable queue bound to it with routing key
+func (b *Broker) Declare(exchange, queue, routingKey string) error {
+	ch, err := b.channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	if err = ch.ExchangeDeclare(exchange, amqp.ExchangeDirect, true, false, false, false, nil); err != nil {
+		return err
+	}
+
+	if _, err = ch.QueueDeclare(queue, true, false, false, false, nil); err != nil {
+		return err
+	}
+
+	return ch.QueueBind(queue, routingKey, exchange, false, nil)
+}
+
+// Publish will publish persistent message and wait until broker confirms it, its headers are sent as AMQP message headers
+func (b *Broker) Publish(ctx context.Context, exchange, routingKey string, msg *Message) error {
+	b.pubMu.Lock()
+	defer b.pubMu.Unlock()
+
+	ch, err := b.publishChannel()
+	if err != nil {
+		return err
+	}
+
+	err = ch.Publish(exchange, routingKey, false, false, amqp.Publishing{
+		Headers:      table(msg.Headers),
+		ContentType:  "text/plain",
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    time.Now().UTC(),
+		Body:         msg.Body,
+	})
+	if err != nil {
+		// channel is closed after error, new one is opened by next publish
+		b.pubCh = nil
+		return err
+	}
+
+	select {
+	case confirm, ok := <-b.pubConfirms:
+		if !ok {
+			b.pubCh = nil
+			return errors.New("channel closed before message was confirmed")
+		}
+		if !confirm.Ack {
+			return ErrNotAcked
+		}
+
+		return nil
+	case <-ctx.Done():
+		// confirmation would be received by next publish, so channel is not used anymore
+		_ = ch.Close()
+		b.pubCh = nil
+
+		return ctx.Err()
+	}
+}
+
+// publishChannel returns shared publishing channel, new one is opened once it's closed (e.g. connection was lost)
+func (b *Broker) publishChannel() (*amqp.Channel, error) {
+	if b.pubCh != nil {
+		select {
+		case <-b.pubClosed:
+			b.pubCh = nil
+		default:
+			return b.pubCh, nil
+		}
+	}
+
+	ch, err := b.channel()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = ch.Confirm(false); err != nil {
+		_ = ch.Close()
+		return nil, err
+	}
+
+	b.pubCh = ch
+	b.pubClosed = ch.NotifyClose(make(chan *amqp.Error, 1))
+	b.pubConfirms = ch.NotifyPublish(make(chan amqp.Confirmation, 1))
+
+	return ch, nil
+}
+
+// Consume will pass messages of queue to handle until ctx is done, error is returned if consumer can't be started
+// or is closed by broker (e.g. connection was lost), it should be started again.
+// Messages are acknowledged once handled, failed message is requeued once. When ctx is done consumer is cancelled
+// at broker, and messages it already received are handled before Consume returns.
+func (b *Broker) Consume(ctx context.Context, queue string, handle Handler) error {
+	ch, err := b.channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	if err = ch.Qos(1, 0, false); err != nil {
+		return err
+	}
+
+	tag := fmt.Sprintf("%s-%d-%d", queue, os.Getpid(), atomic.AddUint64(&b.consumers, 1))
+
+	deliveries, err := ch.Consume(queue, tag, false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	done := ctx.Done()
+	for {
+		select {
+		case delivery, ok := <-deliveries:
+			if !ok {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return errors.New("consumer closed by broker")
+			}
+
+			// handler must not be cancelled while draining
+			if err = deliver(delivery, handle); err != nil {
+				return err
+			}
+		case <-done:
+			// broker stops delivering, deliveries are closed once received ones are drained
+			if err = ch.Cancel(tag, false); err != nil {
+				return err
+			}
+			done = nil
+		}
+	}
+}
+
+// deliver will acknowledge handled message, or reject it (requeued unless it's already redelivered)
+func deliver(delivery amqp.Delivery, handle Handler) error {
+	err := handle(context.Background(), &Message{
+		Headers: headers(delivery.Headers),
+		Body:    delivery.Body,
+	})
+	if err != nil {
+		logrus.Errorf("failed to handle message of %s: %s", delivery.RoutingKey, err)
+
+		return delivery.Nack(false, !delivery.Redelivered)
+	}
+
+	return delivery.Ack(false)
+}
+
+// Close will close connection, it's not reconnected anymore
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+
+	if b.conn.IsClosed() {
+		return nil
+	}
+
+	return b.conn.Close()
+}
+
+func (b *Broker) channel() (*amqp.Channel, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return nil, ErrClosed
+	}
+
+	return b.conn.Channel()
+}
+
+// dial returns new connection and channel signalled once it's lost
+func (b *Broker) dial() (*amqp.Connection, chan *amqp.Error, error) {
+	conn, err := amqp.Dial(fmt.Sprintf("amqp://%s:%s@%s:%s/", b.conf.Username, b.conf.Password, b.conf.Host, b.conf.Port))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return conn, conn.NotifyClose(make(chan *amqp.Error, 1)), nil
+}
+
+// reconnect will dial again each time connection is lost
+func (b *Broker) reconnect(ctx context.Context, lost chan *amqp.Error) {
+	for {
+		select {
+		case err, ok := <-lost:
+			// channel is closed without error when connection is closed on purpose
+			if !ok {
+				return
+			}
+			logrus.Warn("rabbitmq connection lost: ", err)
+		case <-ctx.Done():
+			return
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(b.conf.ReconnectInterval):
+			}
+
+			b.mu.Lock()
+			if b.closed {
+				b.mu.Unlock()
+				return
+			}
+
+			conn, connLost, err := b.dial()
+			if err == nil {
+				b.conn = conn
+				lost = connLost
+			}
+			b.mu.Unlock()
+
+			if err != nil {
+				logrus.Warn("failed to reconnect to rabbitmq: ", err)
+				continue
+			}
+
+			logrus.Info("reconnected to rabbitmq")
+			break
+		}
+	}
+}
+
+// table converts headers to AMQP table
+func table(headers map[string]string) amqp.Table {
+	if len(headers) == 0 {
+		return nil
+	}
+
+	t := make(amqp.Table, len(headers))
+	for k, v := range headers {
+		t[k] = v
+	}
+
+	return t
+}
+
+// headers takes string values of AMQP table, other values are skipped
+func headers(t amqp.Table) map[string]string {
+	h := make(map[string]string, len(t))
+	for k, v := range t {
+		switch value := v.(type) {
+		case string:
+			h[k] = value
+		case []byte:
+			h[k] = string(value)
+		}
+	}
+
+	return h
+}
+
+func env(key, fallback string) string {
+	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+		return value
+	}
+
+	return fallback
+}
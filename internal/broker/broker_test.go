@@ -0,0 +1,28 @@
+package broker
+
+import (
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestHeaders_RoundTrip_AMQPTable(t *testing.T) {
+	sent := map[string]string{
+		"traceparent":  "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		"x-request-id": "req-1",
+	}
+
+	assert.Equal(t, sent, headers(table(sent)))
+	assert.Nil(t, table(nil))
+}
+
+func TestHeaders_Skip_NonStringValues(t *testing.T) {
+	received := amqp.Table{
+		"x-request-id": "req-1",
+		"x-bytes":      []byte("value"),
+		"x-death":      []interface{}{amqp.Table{"count": int64(1)}},
+		"x-retries":    int32(2),
+	}
+
+	assert.Equal(t, map[string]string{"x-request-id": "req-1", "x-bytes": "value"}, headers(received))
+}
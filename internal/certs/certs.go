@@ -0,0 +1,241 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Config of certificate files, all files are PEM encoded
+type Config struct {
+	// CertFile and KeyFile are own certificate and its private key
+	CertFile string
+	KeyFile  string
+	// CAFile verifies the other side: server uses it to require client certificates (mTLS),
+	// client uses it instead of system roots to verify server
+	CAFile string
+	// ReloadInterval is how often files are checked for changes, 0 disables reloading
+	ReloadInterval time.Duration
+}
+
+// NewConfig returns config with default values, tls is disabled until files are set
+func NewConfig() *Config {
+	return &Config{
+		ReloadInterval: 30 * time.Second,
+	}
+}
+
+// Enabled returns true if any certificate file is set
+func (conf *Config) Enabled() bool {
+	return conf.CertFile != "" || conf.KeyFile != "" || conf.CAFile != ""
+}
+
+// Reloader keeps certificate and CA pool loaded from disk, and reloads them when files change,
+// so rotated certificates are used for new connections without restart
+type Reloader struct {
+	conf *Config
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	pool     *x509.CertPool
+	modified map[string]time.Time
+}
+
+// NewReloader will load certificate files and start watching them until ctx is done
+func NewReloader(ctx context.Context, conf *Config) (*Reloader, error) {
+	if (conf.CertFile == "") != (conf.KeyFile == "") {
+		return nil, errors.New("both certificate and key file are required")
+	}
+
+	r := &Reloader{
+		conf:     conf,
+		modified: make(map[string]time.Time),
+	}
+
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	if conf.ReloadInterval > 0 {
+		go r.watch(ctx)
+	}
+
+	return r, nil
+}
+
+// ServerTLS returns tls config for servers, client certificates are required if CA file is set
+func (r *Reloader) ServerTLS() *tls.Config {
+	getCertificate := func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return r.certificate()
+	}
+
+	conf := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: getCertificate,
+	}
+
+	if r.conf.CAFile != "" {
+		// called for each handshake so reloaded CA pool is picked up
+		conf.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return &tls.Config{
+				MinVersion:     tls.VersionTLS12,
+				NextProtos:     []string{"h2", "http/1.1"},
+				GetCertificate: getCertificate,
+				ClientAuth:     tls.RequireAndVerifyClientCert,
+				ClientCAs:      r.caPool(),
+			}, nil
+		}
+	}
+
+	return conf
+}
+
+// ClientTLS returns tls config for clients, certificate (if set) is presented to servers requiring mTLS.
+// Server is verified against CA file, or system roots if CA file is not set.
+func (r *Reloader) ClientTLS(serverName string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := r.certificate()
+			if err != nil {
+				// no certificate is sent, server decides if that is allowed
+				return &tls.Certificate{}, nil
+			}
+
+			return cert, nil
+		},
+		// default verification is replaced so reloaded CA pool is used, verification itself is still done below
+		InsecureSkipVerify: true,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			return r.verifyServer(state)
+		},
+	}
+}
+
+func (r *Reloader) verifyServer(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("server did not present certificate")
+	}
+	// empty name would skip hostname verification
+	if state.ServerName == "" {
+		return errors.New("server name is required to verify server certificate")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName:       state.ServerName,
+		Roots:         r.caPool(),
+		Intermediates: intermediates,
+	})
+
+	return err
+}
+
+func (r *Reloader) certificate() (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.cert == nil {
+		return nil, errors.New("certificate not configured")
+	}
+
+	return r.cert, nil
+}
+
+func (r *Reloader) caPool() *x509.CertPool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.pool
+}
+
+// load will read all configured files, current certificate and pool are kept if any of them is invalid
+func (r *Reloader) load() error {
+	var cert *tls.Certificate
+	if r.conf.CertFile != "" {
+		pair, err := tls.LoadX509KeyPair(r.conf.CertFile, r.conf.KeyFile)
+		if err != nil {
+			return err
+		}
+		cert = &pair
+	}
+
+	var pool *x509.CertPool
+	if r.conf.CAFile != "" {
+		pem, err := os.ReadFile(r.conf.CAFile)
+		if err != nil {
+			return err
+		}
+
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.New("no valid certificates found in " + r.conf.CAFile)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = cert
+	r.pool = pool
+	r.mu.Unlock()
+
+	return nil
+}
+
+func (r *Reloader) watch(ctx context.Context) {
+	r.changed()
+
+	ticker := time.NewTicker(r.conf.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+
+			if err := r.load(); err != nil {
+				logrus.Errorf("failed to reload certificates, keeping current ones: %s", err)
+				continue
+			}
+
+			logrus.Info("certificates reloaded")
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// changed returns true if modification time of any file changed since last check
+func (r *Reloader) changed() bool {
+	changed := false
+
+	for _, path := range []string{r.conf.CertFile, r.conf.KeyFile, r.conf.CAFile} {
+		if path == "" {
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+
+		if !info.ModTime().Equal(r.modified[path]) {
+			r.modified[path] = info.ModTime()
+			changed = true
+		}
+	}
+
+	return changed
+}
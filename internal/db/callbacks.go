@@ -0,0 +1,39 @@
+package db
+
+import (
+	"gorm.io/gorm"
+)
+
+type callbackRegistrar interface {
+	Register(name string, fn func(*gorm.DB)) error
+}
+
+// registerCallbacks will register before and after callbacks around every gorm operation
+func registerCallbacks(db *gorm.DB, name string, before func(db *gorm.DB), after func(operation string) func(db *gorm.DB)) error {
+	callbacks := db.Callback()
+
+	operations := []struct {
+		name   string
+		before callbackRegistrar
+		after  callbackRegistrar
+	}{
+		{"create", callbacks.Create().Before("gorm:create"), callbacks.Create().After("gorm:create")},
+		{"query", callbacks.Query().Before("gorm:query"), callbacks.Query().After("gorm:query")},
+		{"update", callbacks.Update().Before("gorm:update"), callbacks.Update().After("gorm:update")},
+		{"delete", callbacks.Delete().Before("gorm:delete"), callbacks.Delete().After("gorm:delete")},
+		{"row", callbacks.Row().Before("gorm:row"), callbacks.Row().After("gorm:row")},
+		{"raw", callbacks.Raw().Before("gorm:raw"), callbacks.Raw().After("gorm:raw")},
+	}
+
+	for _, op := range operations {
+		if err := op.before.Register(name+":before_"+op.name, before); err != nil {
+			return err
+		}
+
+		if err := op.after.Register(name+":after_"+op.name, after(op.name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package db
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/semirm-dev/faceit/internal/metrics"
+	"gorm.io/gorm"
+	"time"
+)
+
+const startedAtKey = "metrics:started_at"
+
+var queryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: metrics.Namespace,
+	Subsystem: "db",
+	Name:      "query_duration_seconds",
+	Help:      "Database query latencies by operation and table.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"operation", "table", "status"})
+
+// registerMetrics will observe duration of every gorm query
+func registerMetrics(db *gorm.DB) error {
+	return registerCallbacks(db, "metrics", queryStarted, queryFinished)
+}
+
+func queryStarted(db *gorm.DB) {
+	db.InstanceSet(startedAtKey, time.Now())
+}
+
+func queryFinished(operation string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.InstanceGet(startedAtKey)
+		if !ok {
+			return
+		}
+
+		startedAt, ok := value.(time.Time)
+		if !ok {
+			return
+		}
+
+		status := "ok"
+		if db.Error != nil && db.Error != gorm.ErrRecordNotFound {
+			status = "error"
+		}
+
+		queryDuration.WithLabelValues(operation, db.Statement.Table, status).Observe(time.Since(startedAt).Seconds())
+	}
+}
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"testing/fstest"
+)
+
+func file(content string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(content)}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	testCases := []struct {
+		name     string
+		fsys     fstest.MapFS
+		expected []*Migration
+		err      string
+	}{
+		{
+			name: "sorted by version",
+			fsys: fstest.MapFS{
+				"0010_add_index.up.sql":         file("create index"),
+				"0010_add_index.down.sql":       file("drop index"),
+				"0002_add_column.up.sql":        file("alter table add"),
+				"0002_add_column.down.sql":      file("alter table drop"),
+				"0001_create_accounts.up.sql":   file("create table"),
+				"0001_create_accounts.down.sql": file("drop table"),
+			},
+			expected: []*Migration{
+				{Version: 1, Name: "create_accounts", Up: "create table", Down: "drop table"},
+				{Version: 2, Name: "add_column", Up: "alter table add", Down: "alter table drop"},
+				{Version: 10, Name: "add_index", Up: "create index", Down: "drop index"},
+			},
+		},
+		{
+			name: "irreversible without down",
+			fsys: fstest.MapFS{
+				"0001_create_accounts.up.sql": file("create table"),
+			},
+			expected: []*Migration{
+				{Version: 1, Name: "create_accounts", Up: "create table"},
+			},
+		},
+		{
+			name: "missing up",
+			fsys: fstest.MapFS{
+				"0001_create_accounts.up.sql":   file("create table"),
+				"0001_create_accounts.down.sql": file("drop table"),
+				"0002_add_column.down.sql":      file("alter table drop"),
+			},
+			err: "migration 2_add_column is missing up migration",
+		},
+		{
+			name: "empty up",
+			fsys: fstest.MapFS{
+				"0001_create_accounts.up.sql":   file(" \n"),
+				"0001_create_accounts.down.sql": file("drop table"),
+			},
+			err: "migration 1_create_accounts is missing up migration",
+		},
+		{
+			name: "version used by two migrations",
+			fsys: fstest.MapFS{
+				"0001_create_accounts.up.sql": file("create table"),
+				"0001_add_column.down.sql":    file("alter table drop"),
+			},
+			err: "migration version 1 is used by",
+		},
+		{
+			name: "unknown direction",
+			fsys: fstest.MapFS{
+				"0001_create_accounts.sql": file("create table"),
+			},
+			err: "invalid migration file 0001_create_accounts.sql, expected .up.sql or .down.sql",
+		},
+		{
+			name: "missing name",
+			fsys: fstest.MapFS{
+				"0001.up.sql": file("create table"),
+			},
+			err: "invalid migration file 0001.up.sql, expected NNNN_name",
+		},
+		{
+			name: "invalid version",
+			fsys: fstest.MapFS{
+				"first_create_accounts.up.sql": file("create table"),
+			},
+			err: "invalid migration version in first_create_accounts.up.sql",
+		},
+		{
+			name: "zero version",
+			fsys: fstest.MapFS{
+				"0000_create_accounts.up.sql": file("create table"),
+			},
+			err: "invalid migration version in 0000_create_accounts.up.sql",
+		},
+		{
+			name: "no migrations",
+			fsys: fstest.MapFS{
+				"README.md": file("migrations"),
+			},
+			err: errNoMigrations.Error(),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			migrations, err := loadMigrations(tc.fsys)
+
+			if tc.err != "" {
+				assert.NotNil(t, err)
+				assert.Contains(t, err.Error(), tc.err)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, migrations)
+		})
+	}
+}
+
+func TestMigrations_Embedded_AreReversible(t *testing.T) {
+	migrations, err := loadMigrations(Migrations())
+
+	assert.Nil(t, err)
+	assert.NotEmpty(t, migrations)
+	for i, migration := range migrations {
+		assert.Equal(t, int64(i+1), migration.Version)
+		assert.NotEmpty(t, migration.Down, migration.Name)
+	}
+}
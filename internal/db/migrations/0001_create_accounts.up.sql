@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS accounts (
+    id         text PRIMARY KEY,
+    firstname  text,
+    lastname   text,
+    nickname   text,
+    password   text,
+    email      text,
+    country    text,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_accounts_deleted_at ON accounts (deleted_at);
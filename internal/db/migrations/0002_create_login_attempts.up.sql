@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS login_attempts (
+    key            text PRIMARY KEY,
+    failures       bigint,
+    last_failed_at timestamptz,
+    locked_until   timestamptz,
+    updated_at     timestamptz
+);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS account_totps (
+    account_id     text PRIMARY KEY,
+    secret         text,
+    confirmed      boolean,
+    recovery_codes text,
+    last_used_step bigint,
+    created_at     timestamptz,
+    updated_at     timestamptz
+);
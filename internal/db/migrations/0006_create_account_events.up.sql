@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS account_events (
+    id         bigserial PRIMARY KEY,
+    account_id text,
+    event      text,
+    request_id text,
+    created_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_account_events_account_id ON account_events (account_id);
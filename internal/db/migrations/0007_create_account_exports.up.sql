@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS account_exports (
+    id           text PRIMARY KEY,
+    account_id   text,
+    status       text,
+    archive      bytea,
+    error        text,
+    created_at   timestamptz,
+    completed_at timestamptz,
+    expires_at   timestamptz,
+    updated_at   timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_account_exports_account_id ON account_exports (account_id);
+CREATE INDEX IF NOT EXISTS idx_account_exports_expires_at ON account_exports (expires_at);
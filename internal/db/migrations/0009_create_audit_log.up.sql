@@ -0,0 +1,40 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+    id          bigserial PRIMARY KEY,
+    actor       text,
+    action      text,
+    target_type text,
+    target_id   text,
+    changes     jsonb,
+    request_id  text,
+    client_ip   text,
+    created_at  timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_target_id ON audit_log (target_id, id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log (actor, id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_action ON audit_log (action, id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log (created_at);
+
+-- audit log is append-only, the only allowed change is redaction of changes column when account is erased or purged,
+-- which account service enables for its transaction with SET LOCAL audit.redact = 'on'
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND current_setting('audit.redact', true) = 'on' THEN
+        IF (NEW.id, NEW.actor, NEW.action, NEW.target_type, NEW.target_id, NEW.request_id, NEW.client_ip, NEW.created_at)
+            IS NOT DISTINCT FROM
+           (OLD.id, OLD.actor, OLD.action, OLD.target_type, OLD.target_id, OLD.request_id, OLD.client_ip, OLD.created_at) THEN
+            RETURN NEW;
+        END IF;
+    END IF;
+
+    RAISE EXCEPTION 'audit_log is append-only, % is not allowed', TG_OP;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
+DROP TRIGGER IF EXISTS audit_log_no_truncate ON audit_log;
+CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON audit_log
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
@@ -0,0 +1,31 @@
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND current_setting('audit.redact', true) = 'on' THEN
+        IF (NEW.id, NEW.actor, NEW.action, NEW.target_type, NEW.target_id, NEW.request_id, NEW.client_ip, NEW.created_at)
+            IS NOT DISTINCT FROM
+           (OLD.id, OLD.actor, OLD.action, OLD.target_type, OLD.target_id, OLD.request_id, OLD.client_ip, OLD.created_at) THEN
+            RETURN NEW;
+        END IF;
+    END IF;
+
+    RAISE EXCEPTION 'audit_log is append-only, % is not allowed', TG_OP;
+END;
+$$ LANGUAGE plpgsql;
+
+DO $$
+BEGIN
+    EXECUTE format('GRANT UPDATE, DELETE, TRUNCATE ON audit_log TO %I', current_user);
+END
+$$;
+
+DROP FUNCTION IF EXISTS redact_audit_log(text, text[]);
+
+DO $$
+BEGIN
+    IF EXISTS (SELECT FROM pg_roles WHERE rolname = 'audit_redactor') THEN
+        REVOKE ALL ON audit_log FROM audit_redactor;
+        EXECUTE format('REVOKE ALL ON SCHEMA %I FROM audit_redactor', current_schema());
+        DROP ROLE audit_redactor;
+    END IF;
+END
+$$;
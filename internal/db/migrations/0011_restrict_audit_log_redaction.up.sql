@@ -0,0 +1,61 @@
+-- redaction of audit log is restricted to redact_audit_log function, owned by dedicated audit_redactor role,
+-- which can only replace values of fields with '[redacted]', application role loses UPDATE on audit_log.
+-- Migration must be applied by role with CREATEROLE, which can grant on the current schema.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT FROM pg_roles WHERE rolname = current_user AND (rolcreaterole OR rolsuper)) THEN
+        RAISE EXCEPTION 'role % needs CREATEROLE to create audit_redactor role, grant it or apply migrations with role which has it', current_user;
+    END IF;
+
+    IF NOT EXISTS (SELECT FROM pg_roles WHERE rolname = 'audit_redactor') THEN
+        CREATE ROLE audit_redactor NOLOGIN;
+    END IF;
+END
+$$;
+
+GRANT SELECT, UPDATE (changes) ON audit_log TO audit_redactor;
+
+CREATE OR REPLACE FUNCTION redact_audit_log(target text, fields text[]) RETURNS void AS $$
+DECLARE
+    field text;
+BEGIN
+    FOREACH field IN ARRAY fields LOOP
+        UPDATE audit_log
+        SET changes = jsonb_set(changes, ARRAY[field], '{"before": "[redacted]", "after": "[redacted]"}'::jsonb)
+        WHERE target_id = target AND jsonb_exists(changes, field);
+    END LOOP;
+END;
+$$ LANGUAGE plpgsql SECURITY DEFINER;
+
+REVOKE ALL ON FUNCTION redact_audit_log(text, text[]) FROM PUBLIC;
+
+DO $$
+BEGIN
+    EXECUTE format('ALTER FUNCTION redact_audit_log(text, text[]) SET search_path = %I, pg_temp', current_schema());
+    EXECUTE format('GRANT EXECUTE ON FUNCTION redact_audit_log(text, text[]) TO %I', current_user);
+
+    -- new owner must be granted to current user and have CREATE on schema only while ownership is changed
+    EXECUTE format('GRANT audit_redactor TO %I', current_user);
+    EXECUTE format('GRANT USAGE, CREATE ON SCHEMA %I TO audit_redactor', current_schema());
+    ALTER FUNCTION redact_audit_log(text, text[]) OWNER TO audit_redactor;
+    EXECUTE format('REVOKE CREATE ON SCHEMA %I FROM audit_redactor', current_schema());
+    EXECUTE format('REVOKE audit_redactor FROM %I', current_user);
+
+    EXECUTE format('REVOKE UPDATE, DELETE, TRUNCATE ON audit_log FROM %I', current_user);
+END
+$$;
+
+-- audit log is append-only, the only allowed change is redaction of changes column made by redact_audit_log
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND current_user = 'audit_redactor' THEN
+        IF (NEW.id, NEW.actor, NEW.action, NEW.target_type, NEW.target_id, NEW.request_id, NEW.client_ip, NEW.created_at)
+            IS NOT DISTINCT FROM
+           (OLD.id, OLD.actor, OLD.action, OLD.target_type, OLD.target_id, OLD.request_id, OLD.client_ip, OLD.created_at) THEN
+            RETURN NEW;
+        END IF;
+    END IF;
+
+    RAISE EXCEPTION 'audit_log is append-only, % is not allowed', TG_OP;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,40 @@
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND current_user = 'audit_redactor' THEN
+        IF (NEW.id, NEW.actor, NEW.action, NEW.target_type, NEW.target_id, NEW.request_id, NEW.client_ip, NEW.created_at)
+            IS NOT DISTINCT FROM
+           (OLD.id, OLD.actor, OLD.action, OLD.target_type, OLD.target_id, OLD.request_id, OLD.client_ip, OLD.created_at) THEN
+            RETURN NEW;
+        END IF;
+    END IF;
+
+    RAISE EXCEPTION 'audit_log is append-only, % is not allowed', TG_OP;
+END;
+$$ LANGUAGE plpgsql;
+
+DO $$
+BEGIN
+    EXECUTE format('GRANT audit_redactor TO %I', current_user);
+END
+$$;
+
+CREATE OR REPLACE FUNCTION redact_audit_log(target text, fields text[]) RETURNS void AS $$
+DECLARE
+    field text;
+BEGIN
+    FOREACH field IN ARRAY fields LOOP
+        UPDATE audit_log
+        SET changes = jsonb_set(changes, ARRAY[field], '{"before": "[redacted]", "after": "[redacted]"}'::jsonb)
+        WHERE target_id = target AND jsonb_exists(changes, field);
+    END LOOP;
+END;
+$$ LANGUAGE plpgsql SECURITY DEFINER;
+
+DO $$
+BEGIN
+    EXECUTE format('ALTER FUNCTION redact_audit_log(text, text[]) SET search_path = %I, pg_temp', current_schema());
+    EXECUTE format('REVOKE audit_redactor FROM %I', current_user);
+END
+$$;
+
+REVOKE UPDATE (client_ip) ON audit_log FROM audit_redactor;
@@ -0,0 +1,51 @@
+-- client ip of audit entries is personal data too, redact_audit_log clears it together with changes of target.
+-- Like 0011, migration must be applied by role with CREATEROLE, function is replaced as member of audit_redactor.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT FROM pg_roles WHERE rolname = current_user AND (rolcreaterole OR rolsuper)) THEN
+        RAISE EXCEPTION 'role % needs CREATEROLE to replace redact_audit_log, grant it or apply migrations with role which has it', current_user;
+    END IF;
+
+    EXECUTE format('GRANT audit_redactor TO %I', current_user);
+END
+$$;
+
+GRANT UPDATE (client_ip) ON audit_log TO audit_redactor;
+
+CREATE OR REPLACE FUNCTION redact_audit_log(target text, fields text[]) RETURNS void AS $$
+DECLARE
+    field text;
+BEGIN
+    FOREACH field IN ARRAY fields LOOP
+        UPDATE audit_log
+        SET changes = jsonb_set(changes, ARRAY[field], '{"before": "[redacted]", "after": "[redacted]"}'::jsonb)
+        WHERE target_id = target AND jsonb_exists(changes, field);
+    END LOOP;
+
+    UPDATE audit_log SET client_ip = NULL WHERE target_id = target AND client_ip IS NOT NULL;
+END;
+$$ LANGUAGE plpgsql SECURITY DEFINER;
+
+DO $$
+BEGIN
+    -- search_path is reset when function is replaced
+    EXECUTE format('ALTER FUNCTION redact_audit_log(text, text[]) SET search_path = %I, pg_temp', current_schema());
+    EXECUTE format('REVOKE audit_redactor FROM %I', current_user);
+END
+$$;
+
+-- audit_redactor may redact changes and clear client ip, nothing else
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND current_user = 'audit_redactor' THEN
+        IF (NEW.id, NEW.actor, NEW.action, NEW.target_type, NEW.target_id, NEW.request_id, NEW.created_at)
+            IS NOT DISTINCT FROM
+           (OLD.id, OLD.actor, OLD.action, OLD.target_type, OLD.target_id, OLD.request_id, OLD.created_at)
+           AND (NEW.client_ip IS NULL OR NEW.client_ip = OLD.client_ip) THEN
+            RETURN NEW;
+        END IF;
+    END IF;
+
+    RAISE EXCEPTION 'audit_log is append-only, % is not allowed', TG_OP;
+END;
+$$ LANGUAGE plpgsql;
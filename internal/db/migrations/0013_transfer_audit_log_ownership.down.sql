@@ -0,0 +1,14 @@
+DO $$
+BEGIN
+    IF EXISTS (SELECT FROM pg_roles WHERE rolname = 'audit_owner') THEN
+        EXECUTE format('GRANT audit_owner TO %I', current_user);
+
+        EXECUTE format('ALTER TABLE audit_log OWNER TO %I', current_user);
+        EXECUTE format('ALTER FUNCTION audit_log_append_only() OWNER TO %I', current_user);
+
+        EXECUTE format('REVOKE audit_owner FROM %I', current_user);
+        EXECUTE format('REVOKE ALL ON SCHEMA %I FROM audit_owner', current_schema());
+        DROP ROLE audit_owner;
+    END IF;
+END
+$$;
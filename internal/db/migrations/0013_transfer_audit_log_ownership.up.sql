@@ -0,0 +1,28 @@
+-- owner of audit_log could grant itself UPDATE again or disable its triggers, so table and its trigger function are
+-- owned by audit_owner role nobody logs in as, application role can only read and insert entries.
+-- Migration must be applied by role with CREATEROLE.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT FROM pg_roles WHERE rolname = current_user AND (rolcreaterole OR rolsuper)) THEN
+        RAISE EXCEPTION 'role % needs CREATEROLE to transfer ownership of audit_log, grant it or apply migrations with role which has it', current_user;
+    END IF;
+
+    IF NOT EXISTS (SELECT FROM pg_roles WHERE rolname = 'audit_owner') THEN
+        CREATE ROLE audit_owner NOLOGIN;
+    END IF;
+
+    -- new owner must be granted to current user and have CREATE on schema only while ownership is changed
+    EXECUTE format('GRANT audit_owner TO %I', current_user);
+    EXECUTE format('GRANT USAGE, CREATE ON SCHEMA %I TO audit_owner', current_schema());
+
+    -- owned id sequence follows the table
+    ALTER TABLE audit_log OWNER TO audit_owner;
+    ALTER FUNCTION audit_log_append_only() OWNER TO audit_owner;
+
+    EXECUTE format('GRANT SELECT, INSERT ON audit_log TO %I', current_user);
+    EXECUTE format('GRANT USAGE ON SEQUENCE audit_log_id_seq TO %I', current_user);
+
+    EXECUTE format('REVOKE CREATE ON SCHEMA %I FROM audit_owner', current_schema());
+    EXECUTE format('REVOKE audit_owner FROM %I', current_user);
+END
+$$;
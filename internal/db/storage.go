@@ -1,13 +1,36 @@
 package db
 
 import (
+	"context"
+	"errors"
 	"github.com/sirupsen/logrus"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 	"strings"
+	"time"
 )
 
+// Config of connection pool and statements, applied on top of PostgresDb
+type Config struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	// StatementTimeout cancels statements running longer, 0 disables it
+	StatementTimeout time.Duration
+}
+
+// NewConfig will initialize default pool config
+func NewConfig() *Config {
+	return &Config{
+		MaxOpenConns:     25,
+		MaxIdleConns:     5,
+		ConnMaxLifetime:  30 * time.Minute,
+		ConnMaxIdleTime:  5 * time.Minute,
+		StatementTimeout: 5 * time.Second,
+	}
+}
+
 // PostgresDb will initialize pg gorm database
 func PostgresDb(connString string) *gorm.DB {
 	if strings.TrimSpace(connString) == "" {
@@ -16,12 +39,69 @@ func PostgresDb(connString string) *gorm.DB {
 	}
 
 	db, err := gorm.Open(postgres.Open(connString), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
+		Logger: newGormLogger(),
 	})
 	if err != nil {
 		logrus.Error("failed to connect database: ", err)
 		return nil
 	}
 
+	if err = registerMetrics(db); err != nil {
+		logrus.Error("failed to register database metrics: ", err)
+	}
+
+	if err = registerTracing(db); err != nil {
+		logrus.Error("failed to register database tracing: ", err)
+	}
+
 	return db
 }
+
+// Configure will apply connection pool settings and statement timeout
+func Configure(db *gorm.DB, conf *Config) error {
+	if db == nil {
+		return errors.New("database not initialized")
+	}
+
+	sqlDb, err := db.DB()
+	if err != nil {
+		return err
+	}
+
+	sqlDb.SetMaxOpenConns(conf.MaxOpenConns)
+	sqlDb.SetMaxIdleConns(conf.MaxIdleConns)
+	sqlDb.SetConnMaxLifetime(conf.ConnMaxLifetime)
+	sqlDb.SetConnMaxIdleTime(conf.ConnMaxIdleTime)
+
+	return registerStatementTimeout(db, conf.StatementTimeout)
+}
+
+// Ping will create readiness check for database connection
+func Ping(db *gorm.DB) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		if db == nil {
+			return errors.New("database not initialized")
+		}
+
+		sqlDb, err := db.DB()
+		if err != nil {
+			return err
+		}
+
+		return sqlDb.PingContext(ctx)
+	}
+}
+
+// Close will close database connection pool
+func Close(db *gorm.DB) error {
+	if db == nil {
+		return nil
+	}
+
+	sqlDb, err := db.DB()
+	if err != nil {
+		return err
+	}
+
+	return sqlDb.Close()
+}
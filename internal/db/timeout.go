@@ -0,0 +1,69 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const cancelKey = "timeout:cancel"
+
+// registerStatementTimeout will cancel every gorm statement running longer than timeout, pgx then cancels it on server too.
+// Row and Rows are not covered, their results are read after callbacks are finished.
+func registerStatementTimeout(db *gorm.DB, timeout time.Duration) error {
+	if timeout <= 0 {
+		return nil
+	}
+
+	callbacks := db.Callback()
+
+	operations := []struct {
+		name   string
+		before callbackRegistrar
+		after  callbackRegistrar
+	}{
+		{"create", callbacks.Create().Before("gorm:create"), callbacks.Create().After("gorm:create")},
+		{"query", callbacks.Query().Before("gorm:query"), callbacks.Query().After("gorm:query")},
+		{"update", callbacks.Update().Before("gorm:update"), callbacks.Update().After("gorm:update")},
+		{"delete", callbacks.Delete().Before("gorm:delete"), callbacks.Delete().After("gorm:delete")},
+		{"raw", callbacks.Raw().Before("gorm:raw"), callbacks.Raw().After("gorm:raw")},
+	}
+
+	for _, op := range operations {
+		if err := op.before.Register("timeout:before_"+op.name, statementStarted(timeout)); err != nil {
+			return err
+		}
+
+		if err := op.after.Register("timeout:after_"+op.name, statementFinished); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func statementStarted(timeout time.Duration) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		ctx := db.Statement.Context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+
+		db.Statement.Context = ctx
+		db.InstanceSet(cancelKey, cancel)
+	}
+}
+
+func statementFinished(db *gorm.DB) {
+	value, ok := db.InstanceGet(cancelKey)
+	if !ok {
+		return
+	}
+
+	if cancel, ok := value.(context.CancelFunc); ok {
+		cancel()
+	}
+}
@@ -0,0 +1,54 @@
+package db
+
+import (
+	"github.com/semirm-dev/faceit/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const spanKey = "tracing:span"
+
+// registerTracing will create span for every gorm query, child of span in statement context (WithContext)
+func registerTracing(db *gorm.DB) error {
+	return registerCallbacks(db, "tracing", spanStarted, spanFinished)
+}
+
+func spanStarted(db *gorm.DB) {
+	ctx, span := tracing.Tracer().Start(db.Statement.Context, "gorm",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL))
+
+	db.Statement.Context = ctx
+	db.InstanceSet(spanKey, span)
+}
+
+func spanFinished(operation string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.InstanceGet(spanKey)
+		if !ok {
+			return
+		}
+
+		span, ok := value.(trace.Span)
+		if !ok {
+			return
+		}
+		defer span.End()
+
+		span.SetName("gorm." + operation + " " + db.Statement.Table)
+		span.SetAttributes(
+			semconv.DBOperationKey.String(operation),
+			semconv.DBSQLTableKey.String(db.Statement.Table),
+			semconv.DBStatementKey.String(db.Statement.SQL.String()),
+			attribute.Int64("db.rows_affected", db.Statement.RowsAffected),
+		)
+
+		if db.Error != nil && db.Error != gorm.ErrRecordNotFound {
+			span.RecordError(db.Error)
+			span.SetStatus(codes.Error, db.Error.Error())
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/semirm-dev/faceit/internal/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sync"
+	"time"
+)
+
+const (
+	circuitClosed = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+var circuitState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: metrics.Namespace,
+	Subsystem: "grpc_client",
+	Name:      "circuit_state",
+	Help:      "State of client circuit breaker: 0 closed, 1 open, 2 half-open.",
+}, []string{"target"})
+
+// CircuitOpenError is returned without calling remote server while circuit breaker is open
+type CircuitOpenError struct {
+	RetryAfter time.Duration
+}
+
+func (err *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker is open, retry after %s", err.RetryAfter)
+}
+
+// CircuitBreaker stops calls to remote server after consecutive failures, and lets single trial call through
+// once OpenTimeout passes, only successful trial closes the circuit again
+type CircuitBreaker struct {
+	// FailureThreshold is number of consecutive failures that opens the circuit
+	FailureThreshold int
+	// OpenTimeout is how long circuit stays open before trial call is allowed
+	OpenTimeout time.Duration
+
+	mu       sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+	// generation changes with each state change, results of calls allowed in previous generation are ignored
+	generation uint64
+	now        func() time.Time
+}
+
+// NewCircuitBreaker returns circuit breaker with default values
+func NewCircuitBreaker() *CircuitBreaker {
+	return &CircuitBreaker{
+		FailureThreshold: 5,
+		OpenTimeout:      10 * time.Second,
+		now:              time.Now,
+	}
+}
+
+// interceptor will reject calls while circuit is open and record results of others
+func (cb *CircuitBreaker) interceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		generation, retryAfter, ok := cb.allow()
+		if !ok {
+			return &CircuitOpenError{RetryAfter: retryAfter}
+		}
+
+		err := invoker(ctx, method, req, reply, cc, opts...)
+
+		cb.record(generation, err, cc.Target())
+
+		return err
+	}
+}
+
+// allow returns generation call is allowed in, or false and time left until trial call if circuit is open
+func (cb *CircuitBreaker) allow() (uint64, time.Duration, bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case circuitOpen:
+		elapsed := cb.now().Sub(cb.openedAt)
+		if elapsed < cb.OpenTimeout {
+			return 0, cb.OpenTimeout - elapsed, false
+		}
+		// this call is the trial, others are rejected until its result is known
+		cb.state = circuitHalfOpen
+		cb.generation++
+		return cb.generation, 0, true
+	case circuitHalfOpen:
+		return 0, cb.OpenTimeout, false
+	default:
+		return cb.generation, 0, true
+	}
+}
+
+// record will update circuit state with result of call allowed in generation, only errors caused by unhealthy
+// server are counted. Results of calls allowed before the last state change (e.g. slow calls still in flight
+// when circuit opened) are ignored, so only trial call can close open circuit.
+func (cb *CircuitBreaker) record(generation uint64, err error, target string) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if generation != cb.generation {
+		return
+	}
+
+	if !serverFailure(err) {
+		cb.failures = 0
+		cb.setState(circuitClosed, target)
+		return
+	}
+
+	cb.failures++
+
+	if cb.state == circuitHalfOpen || cb.failures >= cb.FailureThreshold {
+		cb.openedAt = cb.now()
+		cb.setState(circuitOpen, target)
+	}
+}
+
+func (cb *CircuitBreaker) setState(state int, target string) {
+	if cb.state != state {
+		cb.generation++
+	}
+
+	cb.state = state
+	circuitState.WithLabelValues(target).Set(float64(state))
+}
+
+func serverFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,134 @@
+package grpc
+
+import (
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+const testTarget = "account:8001"
+
+var unavailable = status.Error(codes.Unavailable, "unavailable")
+
+// testBreaker returns circuit breaker with clock moved by returned func
+func testBreaker() (*CircuitBreaker, func(time.Duration)) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	cb := NewCircuitBreaker()
+	cb.FailureThreshold = 3
+	cb.OpenTimeout = 10 * time.Second
+	cb.now = func() time.Time {
+		return now
+	}
+
+	return cb, func(d time.Duration) {
+		now = now.Add(d)
+	}
+}
+
+// call is allowed and finished with err
+func call(cb *CircuitBreaker, err error) bool {
+	generation, _, ok := cb.allow()
+	if ok {
+		cb.record(generation, err, testTarget)
+	}
+
+	return ok
+}
+
+func TestCircuitBreaker_Closed_Open_HalfOpen_Closed(t *testing.T) {
+	cb, advance := testBreaker()
+
+	for i := 0; i < 2; i++ {
+		assert.True(t, call(cb, unavailable))
+	}
+	assert.Equal(t, circuitClosed, cb.state)
+
+	assert.True(t, call(cb, unavailable))
+	assert.Equal(t, circuitOpen, cb.state)
+
+	advance(4 * time.Second)
+	_, retryAfter, ok := cb.allow()
+	assert.False(t, ok)
+	assert.Equal(t, 6*time.Second, retryAfter)
+
+	advance(6 * time.Second)
+	trial, _, ok := cb.allow()
+	assert.True(t, ok)
+	assert.Equal(t, circuitHalfOpen, cb.state)
+
+	// only single trial call is let through
+	_, retryAfter, ok = cb.allow()
+	assert.False(t, ok)
+	assert.Equal(t, 10*time.Second, retryAfter)
+
+	cb.record(trial, nil, testTarget)
+	assert.Equal(t, circuitClosed, cb.state)
+	assert.Equal(t, 0, cb.failures)
+
+	assert.True(t, call(cb, nil))
+}
+
+func TestCircuitBreaker_FailedTrial_OpensAgain(t *testing.T) {
+	cb, advance := testBreaker()
+
+	for i := 0; i < 3; i++ {
+		call(cb, unavailable)
+	}
+	assert.Equal(t, circuitOpen, cb.state)
+
+	advance(10 * time.Second)
+	assert.True(t, call(cb, status.Error(codes.DeadlineExceeded, "deadline exceeded")))
+	assert.Equal(t, circuitOpen, cb.state)
+
+	_, retryAfter, ok := cb.allow()
+	assert.False(t, ok)
+	assert.Equal(t, 10*time.Second, retryAfter)
+}
+
+func TestCircuitBreaker_InFlightSuccess_DoesntClose_OpenCircuit(t *testing.T) {
+	cb, advance := testBreaker()
+
+	// slow call is allowed before circuit opens
+	slow, _, ok := cb.allow()
+	assert.True(t, ok)
+
+	for i := 0; i < 3; i++ {
+		call(cb, unavailable)
+	}
+	assert.Equal(t, circuitOpen, cb.state)
+
+	cb.record(slow, nil, testTarget)
+	assert.Equal(t, circuitOpen, cb.state)
+
+	_, _, ok = cb.allow()
+	assert.False(t, ok)
+
+	// slow call finishing after trial started doesn't close circuit either
+	advance(10 * time.Second)
+	trial, _, ok := cb.allow()
+	assert.True(t, ok)
+
+	cb.record(slow, nil, testTarget)
+	assert.Equal(t, circuitHalfOpen, cb.state)
+
+	cb.record(trial, unavailable, testTarget)
+	assert.Equal(t, circuitOpen, cb.state)
+}
+
+func TestCircuitBreaker_ClientErrors_AreNotCounted(t *testing.T) {
+	cb, _ := testBreaker()
+
+	call(cb, unavailable)
+	call(cb, unavailable)
+	call(cb, status.Error(codes.NotFound, "not found"))
+	call(cb, unavailable)
+	call(cb, status.Error(codes.InvalidArgument, "invalid"))
+
+	assert.Equal(t, circuitClosed, cb.state)
+	assert.Equal(t, 0, cb.failures)
+
+	assert.True(t, call(cb, nil))
+}
@@ -4,8 +4,15 @@ import (
 	"context"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"net"
+	"time"
+)
+
+const (
+	healthCheckInterval = 5 * time.Second
+	healthCheckTimeout  = 2 * time.Second
 )
 
 // ServiceRegistrar will make sure our services can be registered as grpc servers
@@ -13,34 +20,75 @@ type ServiceRegistrar interface {
 	RegisterGrpcServer(server *grpc.Server)
 }
 
-// ListenForConnections will start grpc server and start listening for connections
-func ListenForConnections(ctx context.Context, registrar ServiceRegistrar, addr, serviceName string) {
+// HealthChecker can be implemented by ServiceRegistrar to report readiness of its dependencies,
+// services without it are reported as serving as soon as they are registered
+type HealthChecker interface {
+	Ready(ctx context.Context) error
+}
+
+// ListenForConnections will start grpc server and start listening for connections, default options are used if opts is nil.
+// It returns once ctx is done and server is stopped.
+func ListenForConnections(ctx context.Context, registrar ServiceRegistrar, addr, serviceName string, opts *ServerOptions) {
 	lis, err := net.Listen("tcp", addr)
 	if err != nil {
 		logrus.Fatal(err)
 	}
 
-	var opts []grpc.ServerOption
-	srv := grpc.NewServer(opts...)
+	if opts == nil {
+		opts = NewServerOptions()
+	}
+
+	srv := NewServer(opts)
 
 	registrar.RegisterGrpcServer(srv)
 
+	healthSrv := health.NewServer()
+	healthpb.RegisterHealthServer(srv, healthSrv)
+
 	logrus.Infof("%s listening...", serviceName)
 
-	go listenForStopped(ctx, srv, serviceName)
+	go reportHealth(ctx, srv, healthSrv, registrar, serviceName)
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		listenForStopped(ctx, srv, serviceName, opts.ShutdownTimeout)
+	}()
 
 	if err = srv.Serve(lis); err != nil {
 		logrus.Fatal(err)
 	}
+
+	<-stopped
+}
+
+// NewServer will create grpc server with interceptor chain and transport configured by opts,
+// default options are used if opts is nil
+func NewServer(opts *ServerOptions) *grpc.Server {
+	if opts == nil {
+		opts = NewServerOptions()
+	}
+
+	return grpc.NewServer(opts.serverOptions()...)
 }
 
-// CreateClientConnection will create grpc client
-func CreateClientConnection(addr string) *grpc.ClientConn {
-	opts := []grpc.DialOption{
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+// CreateClientConnection will create grpc client, default options are used if opts is nil
+func CreateClientConnection(addr string, opts *ClientOptions) *grpc.ClientConn {
+	if opts == nil {
+		opts = NewClientOptions()
 	}
 
-	conn, err := grpc.Dial(addr, opts...)
+	dialTarget, resolverOpts, err := target(opts.Resolver, addr)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	dialOpts, err := opts.dialOptions()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	conn, err := grpc.Dial(dialTarget, append(dialOpts, resolverOpts...)...)
 	if err != nil {
 		logrus.Fatal(err)
 	}
@@ -48,16 +96,89 @@ func CreateClientConnection(addr string) *grpc.ClientConn {
 	return conn
 }
 
-func listenForStopped(ctx context.Context, grpcServer *grpc.Server, serviceName string) {
-	defer func() {
-		logrus.Infof("%s stopped", serviceName)
-	}()
+// ServingStatus will check health of remote grpc server, empty service means overall server health
+func ServingStatus(ctx context.Context, conn *grpc.ClientConn, service string) error {
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{
+		Service: service,
+	})
+	if err != nil {
+		return err
+	}
+
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		return &NotServingError{Status: resp.Status.String()}
+	}
+
+	return nil
+}
+
+// NotServingError is returned when remote grpc server reports it's not serving
+type NotServingError struct {
+	Status string
+}
+
+func (err *NotServingError) Error() string {
+	return "grpc server not serving: " + err.Status
+}
+
+// reportHealth will periodically update serving status of all registered services, based on registrar readiness
+func reportHealth(ctx context.Context, srv *grpc.Server, healthSrv *health.Server, registrar ServiceRegistrar, serviceName string) {
+	checker, ok := registrar.(HealthChecker)
+
+	update := func() {
+		status := healthpb.HealthCheckResponse_SERVING
+
+		if ok {
+			checkCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			if err := checker.Ready(checkCtx); err != nil {
+				logrus.Warnf("%s not ready: %s", serviceName, err)
+				status = healthpb.HealthCheckResponse_NOT_SERVING
+			}
+			cancel()
+		}
+
+		healthSrv.SetServingStatus("", status)
+		for name := range srv.GetServiceInfo() {
+			if name != healthpb.Health_ServiceDesc.ServiceName {
+				healthSrv.SetServingStatus(name, status)
+			}
+		}
+	}
+
+	update()
+
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
 
 	for {
 		select {
+		case <-ticker.C:
+			update()
 		case <-ctx.Done():
-			grpcServer.Stop()
+			healthSrv.Shutdown()
 			return
 		}
 	}
 }
+
+// listenForStopped will stop server once ctx is done: new connections are refused and in-flight calls are
+// given timeout to finish, before they are cancelled
+func listenForStopped(ctx context.Context, grpcServer *grpc.Server, serviceName string, timeout time.Duration) {
+	<-ctx.Done()
+
+	logrus.Warnf("%s shutting down...", serviceName)
+
+	done := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		logrus.Infof("%s stopped", serviceName)
+	case <-time.After(timeout):
+		grpcServer.Stop()
+		logrus.Warnf("%s stopped, in-flight calls cancelled after %s", serviceName, timeout)
+	}
+}
@@ -0,0 +1,228 @@
+package grpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/x509"
+	"github.com/semirm-dev/faceit/internal/logging"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+const (
+	authorizationKey = "authorization"
+	bearerPrefix     = "Bearer "
+)
+
+// AuthFunc will authenticate RPC, returned ctx is passed to handler
+type AuthFunc func(ctx context.Context, fullMethod string) (context.Context, error)
+
+type authenticatedCtx struct{}
+
+// validator is implemented by request messages that can validate themselves
+type validator interface {
+	Validate() error
+}
+
+// TokenAuth will accept only RPCs with given bearer token in authorization metadata
+func TokenAuth(token string) AuthFunc {
+	return func(ctx context.Context, fullMethod string) (context.Context, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+
+		values := md.Get(authorizationKey)
+		if len(values) == 0 || !strings.HasPrefix(values[0], bearerPrefix) {
+			return nil, status.Error(codes.Unauthenticated, "missing bearer token")
+		}
+
+		if subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(values[0], bearerPrefix)), []byte(token)) != 1 {
+			return nil, status.Error(codes.Unauthenticated, "invalid bearer token")
+		}
+
+		return ctx, nil
+	}
+}
+
+// Authenticated tells if RPC was accepted by server auth, or its client presented verified certificate (mTLS),
+// only metadata of authenticated clients can be trusted
+func Authenticated(ctx context.Context) bool {
+	if authenticated, ok := ctx.Value(authenticatedCtx{}).(bool); ok && authenticated {
+		return true
+	}
+
+	return peerCertificate(ctx) != nil
+}
+
+// PeerName returns common name of verified client certificate (mTLS), empty if client presented none
+func PeerName(ctx context.Context) string {
+	cert := peerCertificate(ctx)
+	if cert == nil {
+		return ""
+	}
+
+	return cert.Subject.CommonName
+}
+
+// peerCertificate returns verified client certificate, nil without mTLS
+func peerCertificate(ctx context.Context) *x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	return tlsInfo.State.VerifiedChains[0][0]
+}
+
+// recoveryServerInterceptor will turn panic in handler into internal error, instead of crashing the server
+func recoveryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				logging.FromContext(ctx).WithFields(logrus.Fields{
+					"method": info.FullMethod,
+					"stack":  string(debug.Stack()),
+				}).Errorf("panic recovered: %v", r)
+
+				err = status.Error(codes.Internal, "internal error")
+			}
+		}()
+
+		return handler(ctx, req)
+	}
+}
+
+// loggingServerInterceptor will log each handled RPC with its status code and duration
+func loggingServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+
+		resp, err := handler(ctx, req)
+
+		entry := logging.FromContext(ctx).WithFields(logrus.Fields{
+			"method":      info.FullMethod,
+			"code":        status.Code(err).String(),
+			"duration_ms": float64(time.Since(start).Microseconds()) / 1000,
+		})
+
+		if err != nil {
+			entry.WithError(err).Warn("rpc failed")
+		} else {
+			entry.Info("rpc handled")
+		}
+
+		return resp, err
+	}
+}
+
+// loggingClientInterceptor will log each completed RPC, successful calls are already logged by server
+func loggingClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+
+		err := invoker(ctx, method, req, reply, cc, opts...)
+
+		entry := logging.FromContext(ctx).WithFields(logrus.Fields{
+			"method":      method,
+			"code":        status.Code(err).String(),
+			"duration_ms": float64(time.Since(start).Microseconds()) / 1000,
+		})
+
+		if err != nil {
+			entry.WithError(err).Warn("rpc call failed")
+		} else {
+			entry.Debug("rpc call completed")
+		}
+
+		return err
+	}
+}
+
+// authServerInterceptor will reject RPCs not accepted by auth, health checks are always allowed
+func authServerInterceptor(auth AuthFunc) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if strings.HasPrefix(info.FullMethod, "/"+healthpb.Health_ServiceDesc.ServiceName+"/") {
+			return handler(ctx, req)
+		}
+
+		ctx, err := auth(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(context.WithValue(ctx, authenticatedCtx{}, true), req)
+	}
+}
+
+// authClientInterceptor will send bearer token with each RPC
+func authClientInterceptor(token string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx = metadata.AppendToOutgoingContext(ctx, authorizationKey, bearerPrefix+token)
+
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// deadlineServerInterceptor will cap RPC deadline to max, RPCs without deadline get max as well
+func deadlineServerInterceptor(max time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) > max {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, max)
+			defer cancel()
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// deadlineClientInterceptor will apply timeout to RPCs without deadline
+func deadlineClientInterceptor(timeout time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if _, ok := ctx.Deadline(); !ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// validationServerInterceptor will reject invalid requests before they reach handler
+func validationServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if v, ok := req.(validator); ok {
+			if err := v.Validate(); err != nil {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// validationClientInterceptor will reject invalid requests before they are sent
+func validationClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if v, ok := req.(validator); ok {
+			if err := v.Validate(); err != nil {
+				return status.Error(codes.InvalidArgument, err.Error())
+			}
+		}
+
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
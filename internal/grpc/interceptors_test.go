@@ -0,0 +1,205 @@
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"github.com/semirm-dev/faceit/internal/logging"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+var info = &grpc.UnaryServerInfo{FullMethod: "/product.AccountManagement/GetAccountsByFilter"}
+
+func TestRecoveryServerInterceptor_Panic_Returns_Internal(t *testing.T) {
+	hook := test.NewGlobal()
+	defer hook.Reset()
+
+	resp, err := recoveryServerInterceptor()(context.Background(), "req", info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		panic("boom")
+	})
+
+	assert.Nil(t, resp)
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Equal(t, logrus.ErrorLevel, hook.LastEntry().Level)
+	assert.Equal(t, "panic recovered: boom", hook.LastEntry().Message)
+	assert.Equal(t, info.FullMethod, hook.LastEntry().Data["method"])
+	assert.NotEmpty(t, hook.LastEntry().Data["stack"])
+}
+
+func TestRecoveryServerInterceptor_NoPanic_Returns_HandlerResult(t *testing.T) {
+	handlerErr := status.Error(codes.NotFound, "not found")
+
+	resp, err := recoveryServerInterceptor()(context.Background(), "req", info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "resp", handlerErr
+	})
+
+	assert.Equal(t, "resp", resp)
+	assert.Equal(t, handlerErr, err)
+}
+
+func TestLoggingServerInterceptor_Logs_Code(t *testing.T) {
+	testCases := []struct {
+		name    string
+		err     error
+		level   logrus.Level
+		message string
+		code    string
+	}{
+		{
+			name:    "handled",
+			level:   logrus.InfoLevel,
+			message: "rpc handled",
+			code:    codes.OK.String(),
+		},
+		{
+			name:    "failed",
+			err:     status.Error(codes.NotFound, "not found"),
+			level:   logrus.WarnLevel,
+			message: "rpc failed",
+			code:    codes.NotFound.String(),
+		},
+		{
+			name:    "failed without status",
+			err:     errors.New("failed"),
+			level:   logrus.WarnLevel,
+			message: "rpc failed",
+			code:    codes.Unknown.String(),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			hook := test.NewGlobal()
+			defer hook.Reset()
+
+			ctx := logging.WithRequestID(context.Background(), "request-1")
+
+			_, err := loggingServerInterceptor()(ctx, "req", info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return nil, tc.err
+			})
+
+			assert.Equal(t, tc.err, err)
+			assert.Equal(t, tc.level, hook.LastEntry().Level)
+			assert.Equal(t, tc.message, hook.LastEntry().Message)
+			assert.Equal(t, tc.code, hook.LastEntry().Data["code"])
+			assert.Equal(t, info.FullMethod, hook.LastEntry().Data["method"])
+			assert.Equal(t, "request-1", hook.LastEntry().Data["request_id"])
+		})
+	}
+}
+
+func TestRequestIDServerInterceptor(t *testing.T) {
+	testCases := []struct {
+		name     string
+		md       metadata.MD
+		expected string
+	}{
+		{
+			name:     "from metadata",
+			md:       metadata.Pairs(logging.RequestIDKey, "request-1"),
+			expected: "request-1",
+		},
+		{
+			name: "without metadata",
+		},
+		{
+			name: "empty in metadata",
+			md:   metadata.Pairs(logging.RequestIDKey, ""),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.md != nil {
+				ctx = metadata.NewIncomingContext(ctx, tc.md)
+			}
+
+			var id string
+			_, err := requestIDServerInterceptor()(ctx, "req", info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				id = logging.RequestID(ctx)
+				return nil, nil
+			})
+
+			assert.Nil(t, err)
+			if tc.expected != "" {
+				assert.Equal(t, tc.expected, id)
+			} else {
+				assert.NotEmpty(t, id)
+			}
+		})
+	}
+}
+
+func TestRequestIDClientInterceptor(t *testing.T) {
+	testCases := []struct {
+		name     string
+		id       string
+		expected []string
+	}{
+		{
+			name:     "forwarded",
+			id:       "request-1",
+			expected: []string{"request-1"},
+		},
+		{
+			name: "none",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.id != "" {
+				ctx = logging.WithRequestID(ctx, tc.id)
+			}
+
+			var forwarded []string
+			err := requestIDClientInterceptor()(ctx, info.FullMethod, "req", nil, nil,
+				func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+					md, _ := metadata.FromOutgoingContext(ctx)
+					forwarded = md.Get(logging.RequestIDKey)
+					return nil
+				})
+
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, forwarded)
+		})
+	}
+}
+
+func TestPeerName(t *testing.T) {
+	verified := credentials.TLSInfo{State: tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "gateway"}}}},
+	}}
+
+	testCases := []struct {
+		name          string
+		ctx           context.Context
+		expected      string
+		authenticated bool
+	}{
+		{name: "without peer", ctx: context.Background()},
+		{name: "without tls", ctx: peer.NewContext(context.Background(), &peer.Peer{})},
+		{name: "without client certificate", ctx: peer.NewContext(context.Background(), &peer.Peer{AuthInfo: credentials.TLSInfo{}})},
+		{name: "verified client certificate", ctx: peer.NewContext(context.Background(), &peer.Peer{AuthInfo: verified}), expected: "gateway", authenticated: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, PeerName(tc.ctx))
+			assert.Equal(t, tc.authenticated, Authenticated(tc.ctx))
+		})
+	}
+}
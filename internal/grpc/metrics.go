@@ -0,0 +1,71 @@
+package grpc
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/semirm-dev/faceit/internal/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+var (
+	serverHandled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "grpc_server",
+		Name:      "handled_total",
+		Help:      "Total number of RPCs completed on the server by method and status code.",
+	}, []string{"method", "code"})
+
+	serverDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "grpc_server",
+		Name:      "handling_seconds",
+		Help:      "RPC latencies on the server by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	clientHandled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "grpc_client",
+		Name:      "handled_total",
+		Help:      "Total number of RPCs completed by the client by method and status code.",
+	}, []string{"method", "code"})
+
+	clientDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "grpc_client",
+		Name:      "handling_seconds",
+		Help:      "RPC latencies on the client by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+)
+
+// metricsServerInterceptor will count handled RPCs and observe their latencies
+func metricsServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+
+		resp, err := handler(ctx, req)
+
+		serverHandled.WithLabelValues(info.FullMethod, status.Code(err).String()).Inc()
+		serverDuration.WithLabelValues(info.FullMethod).Observe(time.Since(start).Seconds())
+
+		return resp, err
+	}
+}
+
+// metricsClientInterceptor will count completed RPCs and observe their latencies
+func metricsClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+
+		err := invoker(ctx, method, req, reply, cc, opts...)
+
+		clientHandled.WithLabelValues(method, status.Code(err).String()).Inc()
+		clientDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+
+		return err
+	}
+}
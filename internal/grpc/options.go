@@ -0,0 +1,190 @@
+package grpc
+
+import (
+	"crypto/tls"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"time"
+)
+
+// ServerOptions configures interceptor chain and transport of grpc server
+type ServerOptions struct {
+	// MaxTimeout caps deadline of each RPC, also applied when client sent no deadline, 0 disables it
+	MaxTimeout time.Duration
+	// Auth authenticates each RPC (except health checks), nil disables it
+	Auth AuthFunc
+	// TLS enables transport security, client certificates are verified if it requires them, nil means plaintext
+	TLS *tls.Config
+	// ShutdownTimeout is how long in-flight calls can run after shutdown started, before they are cancelled
+	ShutdownTimeout time.Duration
+	// KeepaliveMinTime is minimum interval of client keepalive pings, clients pinging more often are disconnected
+	KeepaliveMinTime time.Duration
+	// Interceptors are chained after built-in interceptors
+	Interceptors []grpc.UnaryServerInterceptor
+}
+
+// NewServerOptions returns server options with default values
+func NewServerOptions() *ServerOptions {
+	return &ServerOptions{
+		MaxTimeout:       30 * time.Second,
+		ShutdownTimeout:  15 * time.Second,
+		KeepaliveMinTime: 10 * time.Second,
+	}
+}
+
+// ClientOptions configures interceptor chain, transport and load balancing of grpc client
+type ClientOptions struct {
+	// Resolver resolves target address: passthrough (single address), dns (all addresses of host)
+	// or static (comma separated list of addresses)
+	Resolver string
+	// LoadBalancing policy used when target resolves to multiple addresses: round_robin or pick_first
+	LoadBalancing string
+	// HealthCheckService enables client side health checking, backends not serving it are skipped, empty disables it
+	HealthCheckService string
+	// KeepaliveTime is idle time after which server is pinged, dead connections are closed after KeepaliveTimeout
+	KeepaliveTime    time.Duration
+	KeepaliveTimeout time.Duration
+	// Timeout is applied to RPCs without deadline, 0 disables it
+	Timeout time.Duration
+	// Token is sent as bearer token with each RPC, empty disables it
+	Token string
+	// TLS enables transport security, nil means plaintext
+	TLS *tls.Config
+	// ServiceConfig is default grpc service config (retry policies, per method timeouts), optional
+	ServiceConfig *ServiceConfig
+	// Breaker stops calls to unhealthy server, nil disables it
+	Breaker *CircuitBreaker
+	// Interceptors are chained after built-in interceptors
+	Interceptors []grpc.UnaryClientInterceptor
+}
+
+// NewClientOptions returns client options with default values
+func NewClientOptions() *ClientOptions {
+	return &ClientOptions{
+		Resolver:         ResolverPassthrough,
+		LoadBalancing:    PickFirst,
+		Timeout:          10 * time.Second,
+		KeepaliveTime:    30 * time.Second,
+		KeepaliveTimeout: 10 * time.Second,
+	}
+}
+
+// chain returns server interceptors in order of execution, recovery is outermost so it catches panics of all others
+func (opts *ServerOptions) chain() []grpc.UnaryServerInterceptor {
+	chain := []grpc.UnaryServerInterceptor{
+		recoveryServerInterceptor(),
+		requestIDServerInterceptor(),
+		otelgrpc.UnaryServerInterceptor(),
+		loggingServerInterceptor(),
+		metricsServerInterceptor(),
+	}
+
+	if opts.Auth != nil {
+		chain = append(chain, authServerInterceptor(opts.Auth))
+	}
+
+	if opts.MaxTimeout > 0 {
+		chain = append(chain, deadlineServerInterceptor(opts.MaxTimeout))
+	}
+
+	chain = append(chain, validationServerInterceptor())
+
+	return append(chain, opts.Interceptors...)
+}
+
+// chain returns client interceptors in order of execution
+func (opts *ClientOptions) chain() []grpc.UnaryClientInterceptor {
+	chain := []grpc.UnaryClientInterceptor{
+		requestIDClientInterceptor(),
+		otelgrpc.UnaryClientInterceptor(),
+		loggingClientInterceptor(),
+		metricsClientInterceptor(),
+	}
+
+	if opts.Breaker != nil {
+		chain = append(chain, opts.Breaker.interceptor())
+	}
+
+	if opts.Token != "" {
+		chain = append(chain, authClientInterceptor(opts.Token))
+	}
+
+	if opts.Timeout > 0 {
+		chain = append(chain, deadlineClientInterceptor(opts.Timeout))
+	}
+
+	chain = append(chain, validationClientInterceptor())
+
+	return append(chain, opts.Interceptors...)
+}
+
+// serverOptions returns grpc server options
+func (opts *ServerOptions) serverOptions() []grpc.ServerOption {
+	serverOpts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(opts.chain()...),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             opts.KeepaliveMinTime,
+			PermitWithoutStream: true,
+		}),
+	}
+
+	if opts.TLS != nil {
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(opts.TLS)))
+	}
+
+	return serverOpts
+}
+
+// dialOptions returns grpc dial options
+func (opts *ClientOptions) dialOptions() ([]grpc.DialOption, error) {
+	creds := insecure.NewCredentials()
+	if opts.TLS != nil {
+		creds = credentials.NewTLS(opts.TLS)
+	}
+
+	dialOpts := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithChainUnaryInterceptor(opts.chain()...),
+	}
+
+	if opts.KeepaliveTime > 0 {
+		dialOpts = append(dialOpts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                opts.KeepaliveTime,
+			Timeout:             opts.KeepaliveTimeout,
+			PermitWithoutStream: true,
+		}))
+	}
+
+	serviceConf, err := opts.serviceConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(dialOpts, grpc.WithDefaultServiceConfig(serviceConf)), nil
+}
+
+// serviceConfig adds load balancing and health check config to ServiceConfig
+func (opts *ClientOptions) serviceConfig() (string, error) {
+	conf := &ServiceConfig{}
+	if opts.ServiceConfig != nil {
+		copied := *opts.ServiceConfig
+		conf = &copied
+	}
+
+	if opts.LoadBalancing != "" {
+		conf.LoadBalancingConfig = []map[string]map[string]string{
+			{opts.LoadBalancing: {}},
+		}
+	}
+
+	if opts.HealthCheckService != "" {
+		conf.HealthCheckConfig = &HealthCheckConfig{
+			ServiceName: opts.HealthCheckService,
+		}
+	}
+
+	return conf.json()
+}
@@ -0,0 +1,37 @@
+package grpc
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/semirm-dev/faceit/internal/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// requestIDServerInterceptor will read request id from incoming metadata, or create new one, and store it in ctx
+func requestIDServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		var id string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get(logging.RequestIDKey); len(values) > 0 {
+				id = values[0]
+			}
+		}
+		if id == "" {
+			id = uuid.New().String()
+		}
+
+		return handler(logging.WithRequestID(ctx, id), req)
+	}
+}
+
+// requestIDClientInterceptor will forward request id from ctx in outgoing metadata
+func requestIDClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if id := logging.RequestID(ctx); id != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, logging.RequestIDKey, id)
+		}
+
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
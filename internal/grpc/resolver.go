@@ -0,0 +1,42 @@
+package grpc
+
+import (
+	"errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+	"strings"
+)
+
+const (
+	ResolverPassthrough = "passthrough"
+	ResolverDNS         = "dns"
+	ResolverStatic      = "static"
+)
+
+// target will build dial target for addr using given resolver, static resolver requires its own dial option
+func target(resolverName, addr string) (string, []grpc.DialOption, error) {
+	switch resolverName {
+	case "", ResolverPassthrough:
+		return addr, nil, nil
+	case ResolverDNS:
+		return ResolverDNS + ":///" + addr, nil, nil
+	case ResolverStatic:
+		var addresses []resolver.Address
+		for _, a := range strings.Split(addr, ",") {
+			if a = strings.TrimSpace(a); a != "" {
+				addresses = append(addresses, resolver.Address{Addr: a})
+			}
+		}
+		if len(addresses) == 0 {
+			return "", nil, errors.New("static resolver requires at least one address")
+		}
+
+		r := manual.NewBuilderWithScheme(ResolverStatic)
+		r.InitialState(resolver.State{Addresses: addresses})
+
+		return ResolverStatic + ":///" + addresses[0].Addr, []grpc.DialOption{grpc.WithResolvers(r)}, nil
+	default:
+		return "", nil, errors.New("unsupported resolver: " + resolverName)
+	}
+}
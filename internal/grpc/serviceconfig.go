@@ -0,0 +1,69 @@
+package grpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	RoundRobin = "round_robin"
+	PickFirst  = "pick_first"
+)
+
+// ServiceConfig is grpc service config, see https://github.com/grpc/grpc/blob/master/doc/service_config.md
+type ServiceConfig struct {
+	MethodConfig        []*MethodConfig                `json:"methodConfig,omitempty"`
+	RetryThrottling     *RetryThrottling               `json:"retryThrottling,omitempty"`
+	LoadBalancingConfig []map[string]map[string]string `json:"loadBalancingConfig,omitempty"`
+	HealthCheckConfig   *HealthCheckConfig             `json:"healthCheckConfig,omitempty"`
+}
+
+// MethodConfig applies timeout and retry policy to listed methods, name without method matches whole service
+type MethodConfig struct {
+	Name        []MethodName `json:"name"`
+	Timeout     string       `json:"timeout,omitempty"`
+	RetryPolicy *RetryPolicy `json:"retryPolicy,omitempty"`
+}
+
+type MethodName struct {
+	Service string `json:"service"`
+	Method  string `json:"method,omitempty"`
+}
+
+type RetryPolicy struct {
+	MaxAttempts          int      `json:"maxAttempts"`
+	InitialBackoff       string   `json:"initialBackoff"`
+	MaxBackoff           string   `json:"maxBackoff"`
+	BackoffMultiplier    float64  `json:"backoffMultiplier"`
+	RetryableStatusCodes []string `json:"retryableStatusCodes"`
+}
+
+// RetryThrottling stops retries when too many calls fail
+type RetryThrottling struct {
+	MaxTokens  int     `json:"maxTokens"`
+	TokenRatio float64 `json:"tokenRatio"`
+}
+
+// HealthCheckConfig enables client side health checking, backends not serving given service are not picked
+type HealthCheckConfig struct {
+	ServiceName string `json:"serviceName"`
+}
+
+// Duration formats duration as service config expects it, empty means no timeout
+func Duration(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("%gs", d.Seconds())
+}
+
+func (conf *ServiceConfig) json() (string, error) {
+	b, err := json.Marshal(conf)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
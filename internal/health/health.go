@@ -0,0 +1,80 @@
+package health
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// Check reports if single dependency is ready, nil error means ready
+type Check func(ctx context.Context) error
+
+// Checker runs named readiness checks
+type Checker struct {
+	mu     sync.RWMutex
+	checks map[string]Check
+}
+
+func NewChecker() *Checker {
+	return &Checker{
+		checks: make(map[string]Check),
+	}
+}
+
+// Add named readiness check
+func (checker *Checker) Add(name string, check Check) {
+	checker.mu.Lock()
+	defer checker.mu.Unlock()
+
+	checker.checks[name] = check
+}
+
+// Run will run all checks and return errors of failed ones, empty result means ready
+func (checker *Checker) Run(ctx context.Context) map[string]error {
+	failed := make(map[string]error)
+
+	if checker == nil {
+		return failed
+	}
+
+	checker.mu.RLock()
+	defer checker.mu.RUnlock()
+
+	for name, check := range checker.checks {
+		if err := check(ctx); err != nil {
+			failed[name] = err
+		}
+	}
+
+	return failed
+}
+
+// Ready will run all checks and return first error, by check name order
+func (checker *Checker) Ready(ctx context.Context) error {
+	failed := checker.Run(ctx)
+	if len(failed) == 0 {
+		return nil
+	}
+
+	var names []string
+	for name := range failed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return &Error{Check: names[0], Err: failed[names[0]]}
+}
+
+// Error tells which check failed
+type Error struct {
+	Check string
+	Err   error
+}
+
+func (err *Error) Error() string {
+	return err.Check + ": " + err.Err.Error()
+}
+
+func (err *Error) Unwrap() error {
+	return err.Err
+}
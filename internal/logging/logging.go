@@ -0,0 +1,67 @@
+package logging
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// RequestIDHeader is http header carrying request id
+	RequestIDHeader = "X-Request-ID"
+	// RequestIDKey is grpc metadata and event header key carrying request id
+	RequestIDKey = "x-request-id"
+)
+
+type requestIDCtx struct{}
+
+// Init will setup global logrus json formatter and log level
+func Init(level string) error {
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	logrus.SetFormatter(&logrus.JSONFormatter{})
+	logrus.SetLevel(lvl)
+
+	return nil
+}
+
+// WithRequestID will store request id in ctx
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDCtx{}, id)
+}
+
+// RequestID will get request id from ctx, empty if there is none
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+
+	id, _ := ctx.Value(requestIDCtx{}).(string)
+
+	return id
+}
+
+// FromContext returns logger with request id and trace fields from ctx
+func FromContext(ctx context.Context) *logrus.Entry {
+	entry := logrus.NewEntry(logrus.StandardLogger())
+	if ctx == nil {
+		return entry
+	}
+
+	fields := logrus.Fields{}
+
+	if id := RequestID(ctx); id != "" {
+		fields["request_id"] = id
+	}
+
+	if spanCtx := trace.SpanContextFromContext(ctx); spanCtx.IsValid() {
+		fields["trace_id"] = spanCtx.TraceID().String()
+		fields["span_id"] = spanCtx.SpanID().String()
+	}
+
+	return entry.WithContext(ctx).WithFields(fields)
+}
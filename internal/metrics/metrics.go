@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// Namespace of all faceit metrics
+const Namespace = "faceit"
+
+// Handler exposes registered metrics in Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Serve will start http server exposing /metrics, used by services without http api.
+// Returned server should be shut down on exit.
+func Serve(addr, serviceName string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+
+	go func() {
+		logrus.Infof("[%s] metrics listen: %v", serviceName, srv.Addr)
+
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logrus.Error("metrics server listen err: ", err)
+		}
+	}()
+
+	return srv
+}
@@ -0,0 +1,38 @@
+package seed
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FakeEmailDomain is domain of generated accounts, reserved for documentation so emails never reach anyone
+const FakeEmailDomain = "example.com"
+
+var (
+	Countries = []string{"uk", "de", "fr", "es", "it", "nl", "se", "pl", "ba", "us", "br", "jp"}
+
+	firstNames = []string{"Alex", "Sam", "Robin", "Kim", "Charlie", "Jamie", "Taylor", "Morgan", "Jordan", "Casey", "Riley", "Avery"}
+	lastNames  = []string{"Smith", "Muller", "Martin", "Garcia", "Rossi", "Jansen", "Nilsson", "Nowak", "Hodzic", "Johnson", "Silva", "Sato"}
+)
+
+// Fake will generate n accounts spread evenly across countries, all with the same password.
+// Generated accounts are deterministic, so seeding the same n again doesn't create duplicates.
+func Fake(n int, password string) []*Account {
+	accounts := make([]*Account, 0, n)
+
+	for i := 0; i < n; i++ {
+		first := firstNames[i%len(firstNames)]
+		last := lastNames[(i/len(firstNames))%len(lastNames)]
+
+		accounts = append(accounts, &Account{
+			FirstName: first,
+			LastName:  last,
+			Nickname:  fmt.Sprintf("%s%d", strings.ToLower(first), i+1),
+			Email:     fmt.Sprintf("seed-%d@%s", i+1, FakeEmailDomain),
+			Password:  password,
+			Country:   Countries[i%len(Countries)],
+		})
+	}
+
+	return accounts
+}
@@ -1,35 +1,188 @@
 package seed
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
-	"gorm.io/gorm"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/semirm-dev/faceit/internal/logging"
+	"github.com/semirm-dev/faceit/user"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported fixtures format, use .yaml, .yml or .json")
+	ErrMissingEmail      = errors.New("account fixture is missing email")
+	ErrMissingPassword   = errors.New("account fixture is missing password")
 )
 
-func Apply(db *gorm.DB, seeds map[interface{}]func(db *gorm.DB)) {
-	for table, run := range seeds {
-		if db.Migrator().HasTable(&table) {
-			if err := db.First(&table).Error; errors.Is(err, gorm.ErrRecordNotFound) {
-				run(db)
-			}
+// Fixtures is content of fixtures file
+type Fixtures struct {
+	Accounts []*Account `json:"accounts" yaml:"accounts"`
+}
+
+// Account is account fixture, password is plain and gets hashed before it's stored
+type Account struct {
+	FirstName string `json:"first_name" yaml:"first_name"`
+	LastName  string `json:"last_name" yaml:"last_name"`
+	Nickname  string `json:"nickname" yaml:"nickname"`
+	Email     string `json:"email" yaml:"email"`
+	Password  string `json:"password" yaml:"password"`
+	Country   string `json:"country" yaml:"country"`
+}
+
+// Repository is part of user.AccountRepository used for seeding
+type Repository interface {
+	AddAccount(ctx context.Context, account *user.Account) (*user.Account, error)
+	GetByEmail(ctx context.Context, email string) (*user.Account, error)
+	WithinTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// Result is summary of applied seed
+type Result struct {
+	Created int
+	Skipped int
+}
+
+// Seeder will store fixtures, accounts are identified by email so running it again creates only missing ones
+type Seeder struct {
+	repo    Repository
+	pwdHash user.PasswordHash
+}
+
+func NewSeeder(repo Repository, pwdHash user.PasswordHash) *Seeder {
+	return &Seeder{
+		repo:    repo,
+		pwdHash: pwdHash,
+	}
+}
+
+// LoadFile will load fixtures from yaml or json file, format is detected from file extension
+func LoadFile(path string) (*Fixtures, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fixtures := &Fixtures{}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, fixtures)
+	case ".json":
+		err = json.Unmarshal(content, fixtures)
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for i, account := range fixtures.Accounts {
+		if err = account.validate(); err != nil {
+			return nil, fmt.Errorf("%s: accounts[%d]: %w", path, i, err)
 		}
 	}
+
+	return fixtures, nil
+}
+
+// Apply will store all fixtures
+func (seeder *Seeder) Apply(ctx context.Context, fixtures *Fixtures) (*Result, error) {
+	return seeder.Accounts(ctx, fixtures.Accounts)
 }
 
-func RunTrans(db *gorm.DB, trans ...func(tx *gorm.DB)) {
-	tx := db.Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
+// Accounts will store accounts which don't exist yet, existing accounts are left untouched
+func (seeder *Seeder) Accounts(ctx context.Context, accounts []*Account) (*Result, error) {
+	result := &Result{}
+
+	for _, account := range accounts {
+		if err := account.validate(); err != nil {
+			return result, err
+		}
+
+		created, err := seeder.account(ctx, account)
+		if err != nil {
+			return result, err
 		}
-	}()
 
-	if err := tx.Error; err != nil {
-		return
+		if created {
+			result.Created++
+		} else {
+			result.Skipped++
+		}
 	}
 
-	for _, tr := range trans {
-		tr(tx)
+	return result, nil
+}
+
+// account will store account if its email is not taken, check and insert are done in one transaction.
+// Each password is hashed separately, so accounts with the same password don't share salt.
+func (seeder *Seeder) account(ctx context.Context, account *Account) (bool, error) {
+	email := strings.TrimSpace(account.Email)
+
+	// slow hashing is skipped for existing accounts, and is not done while transaction is open
+	existing, err := seeder.repo.GetByEmail(ctx, email)
+	if err != nil {
+		return false, err
+	}
+	if exists(existing) {
+		return false, nil
+	}
+
+	hashed, err := seeder.pwdHash.Hash(account.Password)
+	if err != nil {
+		return false, err
+	}
+
+	created := false
+
+	err = seeder.repo.WithinTx(ctx, func(ctx context.Context) error {
+		existing, err := seeder.repo.GetByEmail(ctx, email)
+		if err != nil {
+			return err
+		}
+		if exists(existing) {
+			return nil
+		}
+
+		stored, err := seeder.repo.AddAccount(ctx, &user.Account{
+			FirstName: account.FirstName,
+			LastName:  account.LastName,
+			Nickname:  account.Nickname,
+			Email:     email,
+			Password:  hashed,
+			Country:   account.Country,
+		})
+		if err != nil {
+			return err
+		}
+
+		logging.FromContext(ctx).Debugf("seeded account %s (%s)", stored.Id, stored.Email)
+		created = true
+
+		return nil
+	})
+
+	return created, err
+}
+
+func exists(account *user.Account) bool {
+	return account != nil && account.Email != ""
+}
+
+func (account *Account) validate() error {
+	if strings.TrimSpace(account.Email) == "" {
+		return ErrMissingEmail
+	}
+	if account.Password == "" {
+		return ErrMissingPassword
 	}
 
-	tx.Commit()
+	return nil
 }
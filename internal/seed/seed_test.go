@@ -0,0 +1,89 @@
+package seed_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/semirm-dev/faceit/internal/seed"
+	"github.com/semirm-dev/faceit/user/repository"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// mockPwdHash salts every hash with counter, as real algorithms salt with random bytes
+type mockPwdHash struct {
+	hashed int
+}
+
+func (pwdHash *mockPwdHash) Hash(plain string) (string, error) {
+	pwdHash.hashed++
+
+	return fmt.Sprintf("%s-hashed-%d", plain, pwdHash.hashed), nil
+}
+func (pwdHash *mockPwdHash) Validate(hashed, plain string) bool {
+	return false
+}
+func (pwdHash *mockPwdHash) NeedsRehash(hashed string) bool {
+	return false
+}
+
+func TestSeeder_Accounts_Reseed_IsIdempotent(t *testing.T) {
+	repo := repository.NewAccountInmemory()
+	pwdHash := &mockPwdHash{}
+	seeder := seed.NewSeeder(repo, pwdHash)
+
+	result, err := seeder.Accounts(context.Background(), seed.Fake(10, "Seed-password-1"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, &seed.Result{Created: 10}, result)
+	assert.Equal(t, 10, len(repo.Accounts))
+
+	stored := make(map[string]string)
+	for _, account := range repo.Accounts {
+		stored[account.Email] = account.Id
+	}
+
+	result, err = seeder.Accounts(context.Background(), seed.Fake(12, "Seed-password-1"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, &seed.Result{Created: 2, Skipped: 10}, result)
+	assert.Equal(t, 12, len(repo.Accounts))
+	assert.Equal(t, 12, pwdHash.hashed) // existing accounts are not hashed again
+
+	for _, account := range repo.Accounts {
+		if id, ok := stored[account.Email]; ok {
+			assert.Equal(t, id, account.Id)
+		}
+	}
+}
+
+func TestSeeder_Accounts_SamePassword_HashedSeparately(t *testing.T) {
+	repo := repository.NewAccountInmemory()
+	seeder := seed.NewSeeder(repo, &mockPwdHash{})
+
+	_, err := seeder.Accounts(context.Background(), seed.Fake(3, "Seed-password-1"))
+	assert.Nil(t, err)
+
+	hashes := make(map[string]bool)
+	for _, account := range repo.Accounts {
+		hashes[account.Password] = true
+	}
+
+	assert.Equal(t, 3, len(hashes))
+}
+
+func TestSeeder_Apply_Fixtures_Reseed_IsIdempotent(t *testing.T) {
+	repo := repository.NewAccountInmemory()
+	seeder := seed.NewSeeder(repo, &mockPwdHash{})
+
+	fixtures, err := seed.LoadFile("fixtures/accounts.yaml")
+	assert.Nil(t, err)
+
+	result, err := seeder.Apply(context.Background(), fixtures)
+	assert.Nil(t, err)
+	assert.Equal(t, len(fixtures.Accounts), result.Created)
+
+	result, err = seeder.Apply(context.Background(), fixtures)
+	assert.Nil(t, err)
+	assert.Equal(t, &seed.Result{Skipped: len(fixtures.Accounts)}, result)
+	assert.Equal(t, len(fixtures.Accounts), len(repo.Accounts))
+}
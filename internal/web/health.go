@@ -0,0 +1,39 @@
+package web
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/semirm-dev/faceit/internal/health"
+	"net/http"
+	"time"
+)
+
+const readinessTimeout = 2 * time.Second
+
+// liveness reports process is up and serving http
+func liveness() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	}
+}
+
+// ready reports if all service dependencies are ready
+func ready(readiness *health.Checker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+		defer cancel()
+
+		failed := readiness.Run(ctx)
+		if len(failed) > 0 {
+			checks := make(map[string]string)
+			for name, err := range failed {
+				checks[name] = err.Error()
+			}
+
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "checks": checks})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	}
+}
@@ -2,6 +2,7 @@ package web
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"github.com/sirupsen/logrus"
 	"net/http"
@@ -11,22 +12,32 @@ import (
 	"time"
 )
 
-// ServeHttp will start http server with graceful shutdown
-func ServeHttp(addr, serviceName string, router http.Handler) {
+// ServeHttp will start http server with graceful shutdown, https is served if tlsConf is set
+func ServeHttp(addr, serviceName string, router http.Handler, tlsConf *tls.Config) {
 	srv := &http.Server{
-		Addr:    addr,
-		Handler: router,
+		Addr:      addr,
+		Handler:   router,
+		TLSConfig: tlsConf,
 	}
 
 	go func() {
-		logrus.Infof("[%s] http listen: %v", serviceName, srv.Addr)
+		var err error
+		if tlsConf != nil {
+			logrus.Infof("[%s] https listen: %v", serviceName, srv.Addr)
+			// certificate is provided by tlsConf
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			logrus.Infof("[%s] http listen: %v", serviceName, srv.Addr)
+			err = srv.ListenAndServe()
+		}
 
-		if err := srv.ListenAndServe(); err != nil && errors.Is(err, http.ErrServerClosed) {
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			logrus.Error("server listen err: ", err)
 		}
 	}()
 
-	quit := make(chan os.Signal)
+	// buffered so signal sent before receive is not lost
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
@@ -0,0 +1,42 @@
+package web
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/semirm-dev/faceit/internal/logging"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// accessLog will log every request as structured entry, replaces gin.Logger text format
+func accessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		status := c.Writer.Status()
+
+		entry := logging.FromContext(c.Request.Context()).WithFields(logrus.Fields{
+			"method":     c.Request.Method,
+			"path":       c.Request.URL.Path,
+			"route":      c.FullPath(),
+			"status":     status,
+			"latency_ms": time.Since(start).Milliseconds(),
+			"client_ip":  c.ClientIP(),
+			"user_agent": c.Request.UserAgent(),
+		})
+
+		if len(c.Errors) > 0 {
+			entry = entry.WithField("errors", c.Errors.String())
+		}
+
+		switch {
+		case status >= 500:
+			entry.Error("request handled")
+		case status >= 400:
+			entry.Warn("request handled")
+		default:
+			entry.Info("request handled")
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/semirm-dev/faceit/internal/logging"
+	"github.com/semirm-dev/faceit/internal/metrics"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultRoute limits routes without their own limit
+	DefaultRoute = "*"
+	// KeyIP identifies clients by ip address
+	KeyIP = "ip"
+)
+
+var rateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Subsystem: "http",
+	Name:      "rate_limited_total",
+	Help:      "Total number of http requests rejected by rate limiter by method and route.",
+}, []string{"method", "route"})
+
+// Limit allows Requests per Period, as token bucket: full bucket allows bursts of Requests,
+// and tokens are refilled evenly over Period
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// RateLimitResult is state of client bucket after taking token from it
+type RateLimitResult struct {
+	Allowed   bool
+	Remaining int
+	// Reset is time until bucket is full again
+	Reset time.Duration
+	// RetryAfter is time until next token, set only when request is not allowed
+	RetryAfter time.Duration
+}
+
+// RateLimitStore keeps token buckets, in-memory store limits each gateway instance separately,
+// shared store (e.g. Redis) can be used to limit all instances together
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, limit *Limit) (*RateLimitResult, error)
+}
+
+// KeyFunc identifies client of request, empty key falls back to client ip
+type KeyFunc func(c *gin.Context) string
+
+// routeLimit is limit of single route, with client key used to select bucket
+type routeLimit struct {
+	limit *Limit
+	key   string
+}
+
+// RateLimiter will limit requests per client, with limits configured per route
+type RateLimiter struct {
+	store  RateLimitStore
+	keys   map[string]KeyFunc
+	routes map[string]*routeLimit
+}
+
+// NewRateLimiter will create rate limiter with ip key, routes without limits are not limited
+func NewRateLimiter(store RateLimitStore) *RateLimiter {
+	return &RateLimiter{
+		store: store,
+		keys: map[string]KeyFunc{
+			KeyIP: KeyByIP,
+		},
+		routes: make(map[string]*routeLimit),
+	}
+}
+
+// Key will register client key available to route limits
+func (rl *RateLimiter) Key(name string, fn KeyFunc) {
+	rl.keys[name] = fn
+}
+
+// Route will set limit of route in "METHOD /path/:param" format, or DefaultRoute, clients are identified by named key
+func (rl *RateLimiter) Route(route string, limit *Limit, key string) error {
+	if _, ok := rl.keys[key]; !ok {
+		return fmt.Errorf("unknown rate limit key %s", key)
+	}
+	if limit.Requests <= 0 || limit.Period <= 0 {
+		return fmt.Errorf("invalid rate limit of %s", route)
+	}
+
+	rl.routes[route] = &routeLimit{
+		limit: limit,
+		key:   key,
+	}
+
+	return nil
+}
+
+// Routes will set route limits from comma separated spec: ROUTE=REQUESTS/PERIOD:KEY,
+// e.g. "POST /users=10/1m:ip,PUT /users/:id/password=5/1m:user,*=300/1m:ip"
+func (rl *RateLimiter) Routes(spec string) error {
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		eq := strings.LastIndex(entry, "=")
+		if eq < 0 {
+			return fmt.Errorf("invalid rate limit %s", entry)
+		}
+		route, rest := strings.TrimSpace(entry[:eq]), entry[eq+1:]
+
+		colon := strings.LastIndex(rest, ":")
+		if colon < 0 {
+			return fmt.Errorf("missing key in rate limit %s", entry)
+		}
+		value, key := rest[:colon], rest[colon+1:]
+
+		limit, err := ParseLimit(value)
+		if err != nil {
+			return fmt.Errorf("%w in %s", err, entry)
+		}
+
+		if err = rl.Route(route, limit, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ParseLimit will parse limit in REQUESTS/PERIOD format, e.g. "10/1m"
+func ParseLimit(value string) (*Limit, error) {
+	parts := strings.Split(value, "/")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid rate limit %s", value)
+	}
+
+	requests, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid requests in rate limit %s: %w", value, err)
+	}
+
+	period, err := time.ParseDuration(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid period in rate limit %s: %w", value, err)
+	}
+
+	return &Limit{Requests: requests, Period: period}, nil
+}
+
+// Middleware will reject requests over route limit with 429, RateLimit-* headers are set on all limited routes.
+// Store errors are logged and requests are let through.
+func (rl *RateLimiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.Request.Method + " " + c.FullPath()
+
+		routeLimit, ok := rl.routes[route]
+		if !ok {
+			if routeLimit, ok = rl.routes[DefaultRoute]; !ok || c.FullPath() == "" {
+				c.Next()
+				return
+			}
+			route = DefaultRoute
+		}
+
+		client := rl.keys[routeLimit.key](c)
+		if client == "" {
+			client = KeyByIP(c)
+		}
+
+		result, err := rl.store.Take(c.Request.Context(), route+"|"+routeLimit.key+":"+client, routeLimit.limit)
+		if err != nil {
+			logging.FromContext(c.Request.Context()).Error("rate limit store failed: ", err)
+			c.Next()
+			return
+		}
+
+		c.Header("RateLimit-Limit", strconv.Itoa(routeLimit.limit.Requests))
+		c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("RateLimit-Reset", seconds(result.Reset))
+
+		if !result.Allowed {
+			rateLimited.WithLabelValues(c.Request.Method, c.FullPath()).Inc()
+
+			c.Header("Retry-After", seconds(result.RetryAfter))
+			c.AbortWithStatus(http.StatusTooManyRequests)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// KeyByIP identifies client by its ip address
+func KeyByIP(c *gin.Context) string {
+	return c.ClientIP()
+}
+
+// KeyByParam identifies client by path param, e.g. user id
+func KeyByParam(name string) KeyFunc {
+	return func(c *gin.Context) string {
+		return c.Param(name)
+	}
+}
+
+// FirstKey uses first non empty key of given keys
+func FirstKey(keys ...KeyFunc) KeyFunc {
+	return func(c *gin.Context) string {
+		for _, key := range keys {
+			if value := key(c); value != "" {
+				return value
+			}
+		}
+
+		return ""
+	}
+}
+
+// JoinKeys identifies client by all given keys together, empty keys are skipped
+func JoinKeys(keys ...KeyFunc) KeyFunc {
+	return func(c *gin.Context) string {
+		var values []string
+		for _, key := range keys {
+			if value := key(c); value != "" {
+				values = append(values, value)
+			}
+		}
+
+		return strings.Join(values, "/")
+	}
+}
+
+// seconds formats duration as whole seconds, rounded up
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,103 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often full (idle) buckets are removed from memory
+const sweepInterval = time.Minute
+
+var errInvalidLimit = errors.New("invalid rate limit")
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  *Limit
+}
+
+// memoryStore keeps token buckets in memory of single gateway instance
+type memoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewMemoryStore will create in-memory rate limit store
+func NewMemoryStore() *memoryStore {
+	return &memoryStore{
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+func (store *memoryStore) Take(ctx context.Context, key string, limit *Limit) (*RateLimitResult, error) {
+	if limit.Requests <= 0 || limit.Period <= 0 {
+		return nil, errInvalidLimit
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	now := store.now()
+	store.sweep(now)
+
+	b, ok := store.buckets[key]
+	if !ok {
+		b = &bucket{
+			tokens: float64(limit.Requests),
+			last:   now,
+		}
+		store.buckets[key] = b
+	}
+	b.limit = limit
+	b.refill(now)
+
+	rate := b.rate()
+	result := &RateLimitResult{}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+
+	result.Remaining = int(math.Floor(b.tokens))
+	result.Reset = time.Duration((float64(limit.Requests) - b.tokens) / rate * float64(time.Second))
+
+	return result, nil
+}
+
+// sweep removes buckets that are full again, they are same as new ones
+func (store *memoryStore) sweep(now time.Time) {
+	if now.Sub(store.lastSweep) < sweepInterval {
+		return
+	}
+	store.lastSweep = now
+
+	for key, b := range store.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.Requests) {
+			delete(store.buckets, key)
+		}
+	}
+}
+
+// rate is number of tokens refilled per second
+func (b *bucket) rate() float64 {
+	return float64(b.limit.Requests) / b.limit.Period.Seconds()
+}
+
+func (b *bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit.Requests), b.tokens+elapsed*b.rate())
+		b.last = now
+	}
+}
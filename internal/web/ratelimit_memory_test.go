@@ -0,0 +1,117 @@
+package web
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// testStore returns memory store with clock moved by returned func
+func testStore() (*memoryStore, func(time.Duration)) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	store := NewMemoryStore()
+	store.lastSweep = now
+	store.now = func() time.Time {
+		return now
+	}
+
+	return store, func(d time.Duration) {
+		now = now.Add(d)
+	}
+}
+
+func TestMemoryStore_Take_Burst_Then_RetryAfter(t *testing.T) {
+	store, advance := testStore()
+	limit := &Limit{Requests: 2, Period: 4 * time.Second}
+
+	for remaining := 1; remaining >= 0; remaining-- {
+		result, err := store.Take(context.Background(), "client", limit)
+		assert.Nil(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, remaining, result.Remaining)
+		assert.Equal(t, time.Duration(0), result.RetryAfter)
+	}
+
+	// one token is refilled every 2s
+	result, err := store.Take(context.Background(), "client", limit)
+	assert.Nil(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	assert.Equal(t, 2*time.Second, result.RetryAfter)
+	assert.Equal(t, 4*time.Second, result.Reset)
+
+	advance(time.Second)
+	result, err = store.Take(context.Background(), "client", limit)
+	assert.Nil(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, time.Second, result.RetryAfter)
+	assert.Equal(t, 3*time.Second, result.Reset)
+
+	advance(time.Second)
+	result, err = store.Take(context.Background(), "client", limit)
+	assert.Nil(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	assert.Equal(t, 4*time.Second, result.Reset)
+}
+
+func TestMemoryStore_Take_Refill_CappedAtRequests(t *testing.T) {
+	store, advance := testStore()
+	limit := &Limit{Requests: 2, Period: time.Minute}
+
+	for i := 0; i < 2; i++ {
+		_, err := store.Take(context.Background(), "client", limit)
+		assert.Nil(t, err)
+	}
+
+	advance(time.Hour)
+	result, err := store.Take(context.Background(), "client", limit)
+	assert.Nil(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 1, result.Remaining)
+	assert.Equal(t, 30*time.Second, result.Reset)
+}
+
+func TestMemoryStore_Take_Keys_AreSeparate(t *testing.T) {
+	store, _ := testStore()
+	limit := &Limit{Requests: 1, Period: time.Minute}
+
+	result, err := store.Take(context.Background(), "client-1", limit)
+	assert.Nil(t, err)
+	assert.True(t, result.Allowed)
+
+	result, err = store.Take(context.Background(), "client-1", limit)
+	assert.Nil(t, err)
+	assert.False(t, result.Allowed)
+
+	result, err = store.Take(context.Background(), "client-2", limit)
+	assert.Nil(t, err)
+	assert.True(t, result.Allowed)
+}
+
+func TestMemoryStore_Sweep_Removes_FullBuckets(t *testing.T) {
+	store, advance := testStore()
+
+	_, err := store.Take(context.Background(), "idle", &Limit{Requests: 10, Period: 10 * time.Second})
+	assert.Nil(t, err)
+	_, err = store.Take(context.Background(), "busy", &Limit{Requests: 10, Period: time.Hour})
+	assert.Nil(t, err)
+
+	advance(sweepInterval)
+	_, err = store.Take(context.Background(), "other", &Limit{Requests: 10, Period: time.Minute})
+	assert.Nil(t, err)
+
+	assert.NotContains(t, store.buckets, "idle")
+	assert.Contains(t, store.buckets, "busy")
+	assert.Contains(t, store.buckets, "other")
+}
+
+func TestMemoryStore_Take_InvalidLimit_Returns_Error(t *testing.T) {
+	store, _ := testStore()
+
+	_, err := store.Take(context.Background(), "client", &Limit{Requests: 0, Period: time.Minute})
+
+	assert.Equal(t, errInvalidLimit, err)
+}
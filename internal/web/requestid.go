@@ -0,0 +1,25 @@
+package web
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/semirm-dev/faceit/internal/logging"
+)
+
+// maxRequestIDLen protects logs from oversized client provided ids
+const maxRequestIDLen = 128
+
+// requestID will accept client provided X-Request-ID or create new one, and store it in request context
+func requestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(logging.RequestIDHeader)
+		if id == "" || len(id) > maxRequestIDLen {
+			id = uuid.New().String()
+		}
+
+		c.Request = c.Request.WithContext(logging.WithRequestID(c.Request.Context(), id))
+		c.Header(logging.RequestIDHeader, id)
+
+		c.Next()
+	}
+}
@@ -3,14 +3,31 @@ package web
 import (
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/semirm-dev/faceit/internal/health"
+	"github.com/semirm-dev/faceit/internal/metrics"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
-func NewRouter() *gin.Engine {
+// NewRouter will create gin router with default middlewares, liveness (/healthz), readiness (/readyz) and /metrics routes.
+// Readiness checker is optional, service without it is ready as soon as it's alive. Client ip is taken from
+// X-Forwarded-For header only when request comes from one of trusted proxies (ip or cidr), otherwise it's remote address.
+func NewRouter(serviceName string, readiness *health.Checker, trustedProxies []string) (*gin.Engine, error) {
 	router := gin.New()
 
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		return nil, err
+	}
+
+	router.Use(requestID())
+	router.Use(otelgin.Middleware(serviceName))
 	router.Use(cors.Default())
-	router.Use(gin.Logger())
+	router.Use(accessLog())
 	router.Use(gin.Recovery())
+	router.Use(requestMetrics())
+
+	router.GET("healthz", liveness())
+	router.GET("readyz", ready(readiness))
+	router.GET("metrics", gin.WrapH(metrics.Handler()))
 
-	return router
+	return router, nil
 }
@@ -1,6 +1,29 @@
 package user
 
-import "time"
+import (
+	"time"
+
+	pbUser "github.com/semirm-dev/faceit/user/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Account fields which can be modified, named as in proto update mask
+const (
+	FieldFirstName = pbUser.FieldFirstName
+	FieldLastName  = pbUser.FieldLastName
+	FieldNickname  = pbUser.FieldNickname
+	FieldCountry   = pbUser.FieldCountry
+)
+
+// ModifiableFields are updated by ModifyAccount when no update mask is given
+var ModifiableFields = pbUser.ModifiableAccountFields
+
+// ErasedValue replaces personal data of erased accounts
+const ErasedValue = "erased"
+
+// ErrVersionConflict is returned when account was changed since expected version was read
+var ErrVersionConflict = status.Error(codes.Aborted, "account was modified in the meantime")
 
 type Account struct {
 	Id        string
@@ -13,4 +36,21 @@ type Account struct {
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	DeletedAt time.Time
+	// Version is incremented on every change, used for optimistic concurrency
+	Version int64
+	// ErasedAt is set once personal data is replaced with placeholders, erased account can't be restored
+	ErasedAt time.Time
+}
+
+// erasedAccount returns placeholders for personal data of account, email stays unique but can't be traced back
+func erasedAccount(id string, erasedAt time.Time) *Account {
+	return &Account{
+		Id:        id,
+		FirstName: ErasedValue,
+		LastName:  ErasedValue,
+		Nickname:  ErasedValue,
+		Email:     ErasedValue + "-" + id + "@erased.invalid",
+		Country:   "",
+		ErasedAt:  erasedAt,
+	}
 }
@@ -0,0 +1,104 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+const (
+	ScopeAccountsRead  = "accounts:read"
+	ScopeAccountsWrite = "accounts:write"
+	// ScopeAdmin grants all scopes
+	ScopeAdmin = "admin"
+
+	apiKeyPrefix = "fk_"
+	apiKeyBytes  = 32
+	// apiKeyTouchInterval limits how often last used time is written
+	apiKeyTouchInterval = time.Minute
+)
+
+var (
+	ErrInvalidAPIKey = errors.New("invalid api key")
+	ErrAPIKeyScope   = errors.New("api key is missing required scope")
+	ErrUnknownScope  = errors.New("unknown api key scope")
+)
+
+// Scopes are all scopes api key can be granted
+var Scopes = []string{ScopeAccountsRead, ScopeAccountsWrite, ScopeAdmin}
+
+// APIKey gives internal services access to gateway, only hash of the key is stored
+type APIKey struct {
+	Id     string
+	Name   string
+	Hash   string
+	Scopes []string
+	// AccountId of account owning the key, empty for keys of internal services
+	AccountId  string
+	ExpiresAt  time.Time
+	LastUsedAt time.Time
+	RevokedAt  time.Time
+	CreatedAt  time.Time
+}
+
+// APIKeyRepository persists api keys
+type APIKeyRepository interface {
+	AddAPIKey(ctx context.Context, key *APIKey) (*APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error)
+	GetAPIKeys(ctx context.Context) ([]*APIKey, error)
+	RevokeAPIKey(ctx context.Context, id string, revokedAt time.Time) error
+	TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error
+}
+
+// Active returns true if key is neither revoked nor expired
+func (key *APIKey) Active(now time.Time) bool {
+	if !key.RevokedAt.IsZero() {
+		return false
+	}
+
+	return key.ExpiresAt.IsZero() || now.Before(key.ExpiresAt)
+}
+
+// HasScope returns true if key was granted given scope, admin scope grants all
+func (key *APIKey) HasScope(scope string) bool {
+	for _, s := range key.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validScope returns true if scope is one of known Scopes
+func validScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// generateAPIKey returns new plain api key and its hash
+func generateAPIKey() (string, string, error) {
+	raw := make([]byte, apiKeyBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+
+	plain := apiKeyPrefix + hex.EncodeToString(raw)
+
+	return plain, hashAPIKey(plain), nil
+}
+
+// hashAPIKey uses plain SHA-256, api keys are random and long enough so slow hashing is not needed
+func hashAPIKey(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,195 @@
+package user
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/semirm-dev/faceit/internal/logging"
+)
+
+// Audited actions, one per mutating rpc
+const (
+	ActionAddAccount              = "add_account"
+	ActionModifyAccount           = "modify_account"
+	ActionChangePassword          = "change_password"
+	ActionDeleteAccount           = "delete_account"
+	ActionRestoreAccount          = "restore_account"
+	ActionPurgeAccount            = "purge_account"
+	ActionEraseAccount            = "erase_account"
+	ActionUnlockAccount           = "unlock_account"
+	ActionExportAccountData       = "export_account_data"
+	ActionEnrollTOTP              = "enroll_totp"
+	ActionConfirmTOTP             = "confirm_totp"
+	ActionDisableTOTP             = "disable_totp"
+	ActionRegenerateRecoveryCodes = "regenerate_recovery_codes"
+	ActionCreateAPIKey            = "create_api_key"
+	ActionRevokeAPIKey            = "revoke_api_key"
+)
+
+// Audited target types
+const (
+	TargetAccount = "account"
+	TargetAPIKey  = "api_key"
+)
+
+const (
+	// ActorSystem is actor of changes made by service itself, such as scheduled purge
+	ActorSystem = "system"
+	// ActorAnonymous is actor of changes requested without api key or client certificate
+	ActorAnonymous = "anonymous"
+	// Redacted replaces audited values which are secret or erased personal data
+	Redacted = "[redacted]"
+
+	maxAuditLimit = 100
+)
+
+// personalFields are account fields whose audited values are redacted once account is erased or purged
+var personalFields = []string{FieldFirstName, FieldLastName, FieldNickname, "email", FieldCountry}
+
+// AuditEntry records who changed what and from where, audit log is append-only
+type AuditEntry struct {
+	Id         int64
+	Actor      string
+	Action     string
+	TargetType string
+	TargetId   string
+	// Changes are values of changed fields before and after action, keyed by field name
+	Changes   map[string]Change
+	RequestId string
+	ClientIP  string
+	CreatedAt time.Time
+}
+
+// Change of single field, values are formatted as strings
+type Change struct {
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// AuditFilter to apply when querying audit log, entries are ordered from newest to oldest
+type AuditFilter struct {
+	Page     int
+	Limit    int
+	TargetId string
+	Actor    string
+	Action   string
+	// Since is inclusive and Until exclusive, zero time is not applied
+	Since time.Time
+	Until time.Time
+}
+
+// AuditRepository persists audit log
+type AuditRepository interface {
+	AddAuditEntry(ctx context.Context, entry *AuditEntry) error
+	GetAuditLog(ctx context.Context, filter *AuditFilter) ([]*AuditEntry, error)
+	// RedactAuditLog will replace values of given fields in entries of target with Redacted and clear their client ip,
+	// it's the only change allowed on audit log and must run in transaction
+	RedactAuditLog(ctx context.Context, targetId string, fields []string) error
+}
+
+// APIKeyActor is actor of changes requested with api key
+func APIKeyActor(id string) string {
+	return TargetAPIKey + ":" + id
+}
+
+// PeerActor is actor of changes requested by grpc client with certificate (mTLS)
+func PeerActor(name string) string {
+	return "peer:" + name
+}
+
+// audit will record action of actor from ctx, it should be called in the same transaction as audited change
+func (svc *accountService) audit(ctx context.Context, action, targetType, targetId string, changes map[string]Change) error {
+	actor, err := svc.actor(ctx)
+	if err != nil {
+		return err
+	}
+
+	return svc.repo.AddAuditEntry(ctx, &AuditEntry{
+		Actor:      actor,
+		Action:     action,
+		TargetType: targetType,
+		TargetId:   targetId,
+		Changes:    changes,
+		RequestId:  logging.RequestID(ctx),
+		ClientIP:   clientIP(ctx),
+		CreatedAt:  time.Now().UTC(),
+	})
+}
+
+// accountFields are audited fields of account, password is never audited
+func accountFields(account *Account) map[string]string {
+	if account == nil {
+		return nil
+	}
+
+	return map[string]string{
+		FieldFirstName: account.FirstName,
+		FieldLastName:  account.LastName,
+		FieldNickname:  account.Nickname,
+		"email":        account.Email,
+		FieldCountry:   account.Country,
+		"deleted_at":   timestamp(account.DeletedAt),
+		"erased_at":    timestamp(account.ErasedAt),
+		"version":      strconv.FormatInt(account.Version, 10),
+	}
+}
+
+// apiKeyFields are audited fields of api key, its hash is never audited
+func apiKeyFields(key *APIKey) map[string]string {
+	return map[string]string{
+		"name":       key.Name,
+		"scopes":     strings.Join(key.Scopes, ","),
+		"account_id": key.AccountId,
+		"expires_at": timestamp(key.ExpiresAt),
+		"revoked_at": timestamp(key.RevokedAt),
+	}
+}
+
+// diff returns changes between fields before and after action, nil fields are treated as empty
+func diff(before, after map[string]string) map[string]Change {
+	changes := make(map[string]Change)
+
+	for field, value := range before {
+		if after[field] != value {
+			changes[field] = Change{Before: value, After: after[field]}
+		}
+	}
+
+	for field, value := range after {
+		if _, ok := before[field]; !ok && value != "" {
+			changes[field] = Change{After: value}
+		}
+	}
+
+	return changes
+}
+
+// purgeChanges are audited changes of purged account, its personal values are redacted
+func purgeChanges(account *Account) map[string]Change {
+	return redactPersonal(diff(accountFields(account), nil))
+}
+
+// redactPersonal will redact personal values of changes, used for actions which erase or remove account
+func redactPersonal(changes map[string]Change) map[string]Change {
+	for _, field := range personalFields {
+		if _, ok := changes[field]; ok {
+			changes[field] = Change{Before: Redacted, After: Redacted}
+		}
+	}
+
+	return changes
+}
+
+// sortedFields returns field names of changes in alphabetical order
+func sortedFields(changes map[string]Change) []string {
+	fields := make([]string, 0, len(changes))
+	for field := range changes {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	return fields
+}
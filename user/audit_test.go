@@ -0,0 +1,267 @@
+package user_test
+
+import (
+	"context"
+	"errors"
+	"github.com/semirm-dev/faceit/user"
+	pbUser "github.com/semirm-dev/faceit/user/proto"
+	"github.com/semirm-dev/faceit/user/repository"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"testing"
+	"time"
+)
+
+var errAuditFailed = errors.New("audit failed")
+
+// failingAuditRepo writes audit entry and fails afterwards, as database would when entry is rejected
+type failingAuditRepo struct {
+	user.AccountRepository
+}
+
+func (repo *failingAuditRepo) AddAuditEntry(ctx context.Context, entry *user.AuditEntry) error {
+	if err := repo.AccountRepository.AddAuditEntry(ctx, entry); err != nil {
+		return err
+	}
+
+	return errAuditFailed
+}
+
+// auditChanges returns changes of entry formatted as before -> after, keyed by field
+func auditChanges(entry *pbUser.AuditEntryMessage) map[string]string {
+	changes := make(map[string]string)
+	for _, change := range entry.Changes {
+		changes[change.Field] = change.Before + " -> " + change.After
+	}
+
+	return changes
+}
+
+// auditActions returns actions of entries of target, oldest first
+func auditActions(t *testing.T, rpcClient pbUser.AccountManagementClient, targetId string) []string {
+	log, err := rpcClient.GetAuditLog(context.Background(), &pbUser.GetAuditLogRequest{TargetId: targetId})
+	assert.Nil(t, err)
+
+	var actions []string
+	for i := len(log.Entries) - 1; i >= 0; i-- {
+		actions = append(actions, log.Entries[i].Action)
+	}
+
+	return actions
+}
+
+func TestAccountService_AuditLog_ForgedActor_IsIgnored(t *testing.T) {
+	repo.Accounts = []*user.Account{
+		{Id: "123", FirstName: "user 1", Email: "user1@mail.com", Version: 1},
+	}
+	repo.AuditLog = nil
+	publisher.reset()
+
+	rpcClient := gatewayClient()
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-actor", user.APIKeyActor("admin"))
+
+	_, err := rpcClient.ModifyAccount(ctx, &pbUser.AccountMessage{
+		Id:         "123",
+		FirstName:  "modified",
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"first_name"}},
+	})
+	assert.Nil(t, err)
+
+	assert.Equal(t, 1, len(repo.AuditLog))
+	assert.Equal(t, user.ActorAnonymous, repo.AuditLog[0].Actor)
+}
+
+func TestAccountService_AuditLog_InvalidForwardedAPIKey_Returns_Fail(t *testing.T) {
+	repo.Accounts = []*user.Account{
+		{Id: "123", FirstName: "user 1", Email: "user1@mail.com", Version: 1},
+	}
+	repo.AuditLog = nil
+	repo.APIKeys = nil
+	publisher.reset()
+
+	rpcClient := gatewayClient()
+
+	revoked, err := rpcClient.CreateAPIKey(context.Background(), &pbUser.CreateAPIKeyRequest{Name: "revoked", Scopes: []string{user.ScopeAdmin}})
+	assert.Nil(t, err)
+	_, err = rpcClient.RevokeAPIKey(context.Background(), &pbUser.RevokeAPIKeyRequest{Id: revoked.Id})
+	assert.Nil(t, err)
+	repo.AuditLog = nil
+
+	for _, key := range []string{"fk_invalid", revoked.Key} {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), user.APIKeyKey, key)
+
+		_, err = rpcClient.ModifyAccount(ctx, &pbUser.AccountMessage{
+			Id:         "123",
+			FirstName:  "modified",
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"first_name"}},
+		})
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), user.ErrInvalidAPIKey.Error())
+	}
+
+	assert.Equal(t, "user 1", repo.Accounts[0].FirstName)
+	assert.Empty(t, repo.AuditLog)
+}
+
+func TestAccountService_AuditLog_Failed_RollsBack_Change(t *testing.T) {
+	auditRepo := repository.NewAccountInmemory()
+	auditRepo.Accounts = []*user.Account{
+		{Id: "123", FirstName: "user 1", Email: "user1@mail.com", Version: 1},
+	}
+
+	svc := user.NewAccountService(user.NewConfig(), &failingAuditRepo{AccountRepository: auditRepo}, newMockPublisher(), &mockPwdHash{})
+
+	_, err := svc.ModifyAccount(context.Background(), &pbUser.AccountMessage{
+		Id:         "123",
+		FirstName:  "modified",
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"first_name"}},
+	})
+
+	assert.Equal(t, errAuditFailed, err)
+	assert.Equal(t, "user 1", auditRepo.Accounts[0].FirstName)
+	assert.Equal(t, int64(1), auditRepo.Accounts[0].Version)
+	assert.Empty(t, auditRepo.AuditLog)
+}
+
+func TestAccountService_AuditLog_Records_TOTP_APIKey_Export(t *testing.T) {
+	repo.Accounts = []*user.Account{
+		{Id: "123", FirstName: "user 1", Email: "user1@mail.com", Password: "pwd123-hashed", Version: 1},
+	}
+	repo.AuditLog = nil
+	repo.TOTPs = nil
+	repo.APIKeys = nil
+	publisher.reset()
+
+	rpcClient := grpcClient()
+	rootCtx, rootCancel := context.WithCancel(context.Background())
+	defer rootCancel()
+
+	enrolled, err := rpcClient.EnrollTOTP(rootCtx, &pbUser.EnrollTOTPRequest{Id: "123", Password: "pwd123"})
+	assert.Nil(t, err)
+
+	code, err := user.GenerateTOTPCode(enrolled.Secret, time.Now())
+	assert.Nil(t, err)
+
+	confirmed, err := rpcClient.ConfirmTOTP(rootCtx, &pbUser.ConfirmTOTPRequest{Id: "123", Code: code})
+	assert.Nil(t, err)
+
+	_, err = rpcClient.DisableTOTP(rootCtx, &pbUser.DisableTOTPRequest{Id: "123", Password: "pwd123", Code: confirmed.RecoveryCodes[0]})
+	assert.Nil(t, err)
+
+	_, err = rpcClient.ExportAccountData(rootCtx, &pbUser.ExportAccountDataRequest{Id: "123"})
+	assert.Nil(t, err)
+	publisher.eventually(t, "account_export_ready")
+
+	assert.Equal(t, []string{user.ActionEnrollTOTP, user.ActionConfirmTOTP, user.ActionDisableTOTP, user.ActionExportAccountData},
+		auditActions(t, rpcClient, "123"))
+
+	key, err := rpcClient.CreateAPIKey(rootCtx, &pbUser.CreateAPIKeyRequest{Name: "matchmaking", Scopes: []string{user.ScopeAccountsRead}})
+	assert.Nil(t, err)
+
+	_, err = rpcClient.RevokeAPIKey(rootCtx, &pbUser.RevokeAPIKeyRequest{Id: key.Id})
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{user.ActionCreateAPIKey, user.ActionRevokeAPIKey}, auditActions(t, rpcClient, key.Id))
+
+	// secrets, recovery codes and keys are never audited
+	log, err := rpcClient.GetAuditLog(rootCtx, &pbUser.GetAuditLogRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, 6, len(log.Entries))
+	for _, entry := range log.Entries {
+		assert.Equal(t, user.ActorAnonymous, entry.Actor)
+		for _, change := range entry.Changes {
+			for _, secret := range append([]string{enrolled.Secret, key.Key, repo.APIKeys[0].Hash}, confirmed.RecoveryCodes...) {
+				assert.NotContains(t, change.Before, secret)
+				assert.NotContains(t, change.After, secret)
+			}
+		}
+	}
+}
+
+func TestAccountService_PurgeAccount_Redacts_AuditLog(t *testing.T) {
+	repo.Accounts = []*user.Account{
+		{Id: "123", FirstName: "user 1", Email: "user1@mail.com", Country: "country1", Version: 1},
+	}
+	repo.AuditLog = nil
+	publisher.reset()
+
+	rpcClient := grpcClient()
+	rootCtx, rootCancel := context.WithCancel(context.Background())
+	defer rootCancel()
+
+	_, err := rpcClient.ModifyAccount(rootCtx, &pbUser.AccountMessage{
+		Id:         "123",
+		Country:    "country2",
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"country"}},
+	})
+	assert.Nil(t, err)
+
+	_, err = rpcClient.DeleteAccount(rootCtx, &pbUser.DeleteAccountRequest{Id: "123"})
+	assert.Nil(t, err)
+
+	_, err = rpcClient.PurgeAccount(rootCtx, &pbUser.PurgeAccountRequest{Id: "123"})
+	assert.Nil(t, err)
+
+	log, err := rpcClient.GetAuditLog(rootCtx, &pbUser.GetAuditLogRequest{TargetId: "123"})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(log.Entries))
+	assert.Equal(t, user.ActionPurgeAccount, log.Entries[0].Action)
+
+	modified := auditChanges(log.Entries[2])
+	assert.Equal(t, user.Redacted+" -> "+user.Redacted, modified["country"])
+	assert.Equal(t, "1 -> 2", modified["version"])
+
+	purged := auditChanges(log.Entries[0])
+	assert.Equal(t, user.Redacted+" -> "+user.Redacted, purged["email"])
+	assert.Equal(t, user.Redacted+" -> "+user.Redacted, purged[user.FieldFirstName])
+}
+
+func TestAccountService_PurgeDeleted_Audits_AsSystem(t *testing.T) {
+	purgeRepo := repository.NewAccountInmemory()
+	deletedAt := time.Now().UTC().Add(-48 * time.Hour)
+	purgeRepo.Accounts = []*user.Account{
+		{Id: "1", FirstName: "user 1", Email: "user1@mail.com", Country: "country1", Version: 2, DeletedAt: deletedAt},
+		{Id: "2", FirstName: "user 2", Email: "user2@mail.com", Country: "country1", Version: 2, DeletedAt: deletedAt},
+	}
+	purgeRepo.AuditLog = []*user.AuditEntry{
+		{
+			Id:       1,
+			Actor:    user.ActorAnonymous,
+			Action:   user.ActionModifyAccount,
+			TargetId: "1",
+			Changes:  map[string]user.Change{user.FieldCountry: {Before: "country0", After: "country1"}},
+		},
+	}
+
+	conf := user.NewConfig()
+	conf.Purge.Retention = 24 * time.Hour
+
+	svc := user.NewAccountService(conf, purgeRepo, newMockPublisher(), &mockPwdHash{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	svc.PurgeDeleted(ctx)
+	assert.Nil(t, svc.Close(context.Background()))
+
+	assert.Empty(t, purgeRepo.Accounts)
+	assert.Equal(t, user.Change{Before: user.Redacted, After: user.Redacted}, purgeRepo.AuditLog[0].Changes[user.FieldCountry])
+
+	// scheduled purge is audited as PurgeAccount is, by system
+	var purged []*user.AuditEntry
+	for _, entry := range purgeRepo.AuditLog {
+		if entry.Action == user.ActionPurgeAccount {
+			purged = append(purged, entry)
+		}
+	}
+	assert.Equal(t, 2, len(purged))
+
+	for _, entry := range purged {
+		assert.Equal(t, user.ActorSystem, entry.Actor)
+		assert.Equal(t, user.TargetAccount, entry.TargetType)
+		assert.Equal(t, user.Change{Before: user.Redacted, After: user.Redacted}, entry.Changes["email"])
+		assert.Equal(t, user.Change{Before: user.Redacted, After: user.Redacted}, entry.Changes[user.FieldFirstName])
+		assert.Equal(t, user.Change{Before: "2", After: ""}, entry.Changes["version"])
+	}
+}
@@ -0,0 +1,256 @@
+package user
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var ErrBreachedPassword = status.Error(codes.InvalidArgument, "password is too common or has appeared in a data breach")
+
+// PasswordChecker will check if password is known to be weak or breached
+type PasswordChecker interface {
+	Breached(plain string) bool
+}
+
+//go:embed common_passwords.txt
+var commonPasswordsList string
+
+// commonPasswords are bundled with service, so the most common passwords are rejected without any configuration
+type commonPasswords map[string]struct{}
+
+// CommonPasswords returns checker of bundled list of the most common passwords, compared case-insensitively
+func CommonPasswords() PasswordChecker {
+	checker := make(commonPasswords)
+	for _, line := range strings.Split(commonPasswordsList, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		checker[strings.ToLower(line)] = struct{}{}
+	}
+
+	return checker
+}
+
+// Breached returns true if password is in the list
+func (checker commonPasswords) Breached(plain string) bool {
+	_, ok := checker[strings.ToLower(plain)]
+
+	return ok
+}
+
+// passwordCheckers reject password rejected by any of them
+type passwordCheckers []PasswordChecker
+
+// PasswordCheckers combines checkers, e.g. bundled common passwords extended with breached passwords list
+func PasswordCheckers(checkers ...PasswordChecker) PasswordChecker {
+	return passwordCheckers(checkers)
+}
+
+// Breached returns true if any of checkers rejects password
+func (checkers passwordCheckers) Breached(plain string) bool {
+	for _, checker := range checkers {
+		if checker.Breached(plain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// breachedPasswords keeps SHA-1 hashes of breached passwords in bloom filter,
+// false positives are possible (rejecting good password), false negatives are not
+type breachedPasswords struct {
+	bits []uint64
+	m    uint64
+	k    uint64
+}
+
+// LoadBreachedPasswords will load SHA-1 hashes of breached passwords from local copy of HIBP Pwned Passwords.
+// Path is either directory of range files, named by hash prefix (5 hex chars, e.g. 21BD1.txt) with SUFFIX:COUNT
+// lines as served by range api, or single file with full HASH:COUNT lines.
+// fpRate is desired false positive rate of the bloom filter, 0.001 is good default.
+func LoadBreachedPasswords(path string, fpRate float64) (*breachedPasswords, error) {
+	files, err := rangeFiles(path)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := 0
+	for _, file := range files {
+		n, err := countLines(file.path)
+		if err != nil {
+			return nil, err
+		}
+		lines += n
+	}
+
+	checker := newBreachedPasswords(lines, fpRate)
+
+	for _, file := range files {
+		if err = checker.load(file); err != nil {
+			return nil, err
+		}
+	}
+
+	return checker, nil
+}
+
+// rangeFile has hashes starting with prefix, prefix is empty for file with full hashes
+type rangeFile struct {
+	path   string
+	prefix string
+}
+
+func rangeFiles(path string) ([]rangeFile, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []rangeFile{{path: path}}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []rangeFile
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		prefix := strings.ToUpper(strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name())))
+		if len(prefix) != 5 || strings.Trim(prefix, "0123456789ABCDEF") != "" {
+			return nil, fmt.Errorf("%s: range file must be named by 5 hex chars hash prefix", filepath.Join(path, entry.Name()))
+		}
+
+		files = append(files, rangeFile{path: filepath.Join(path, entry.Name()), prefix: prefix})
+	}
+
+	return files, nil
+}
+
+func (checker *breachedPasswords) load(file rangeFile) error {
+	f, err := os.Open(file.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	n := 0
+	for scanner.Scan() {
+		n++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		sum, err := parseBreachedLine(file.prefix, line)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", file.path, n, err)
+		}
+
+		checker.add(sum)
+	}
+
+	return scanner.Err()
+}
+
+func newBreachedPasswords(n int, fpRate float64) *breachedPasswords {
+	if n < 1 {
+		n = 1
+	}
+	if fpRate <= 0 || fpRate >= 1 {
+		fpRate = 0.001
+	}
+
+	m := uint64(math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	k := uint64(math.Max(1, math.Round(float64(m)/float64(n)*math.Ln2)))
+
+	return &breachedPasswords{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+// Breached returns true if SHA-1 of plain password is (probably) in the list
+func (checker *breachedPasswords) Breached(plain string) bool {
+	sum := sha1.Sum([]byte(plain))
+
+	return checker.contains(sum[:])
+}
+
+func (checker *breachedPasswords) add(sum []byte) {
+	h1, h2 := bloomHashes(sum)
+
+	for i := uint64(0); i < checker.k; i++ {
+		idx := (h1 + i*h2) % checker.m
+		checker.bits[idx/64] |= 1 << (idx % 64)
+	}
+}
+
+func (checker *breachedPasswords) contains(sum []byte) bool {
+	h1, h2 := bloomHashes(sum)
+
+	for i := uint64(0); i < checker.k; i++ {
+		idx := (h1 + i*h2) % checker.m
+		if checker.bits[idx/64]&(1<<(idx%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// bloomHashes derives two hashes for double hashing, SHA-1 output is already uniformly distributed
+func bloomHashes(sum []byte) (uint64, uint64) {
+	h1 := binary.BigEndian.Uint64(sum[0:8])
+	h2 := binary.BigEndian.Uint64(sum[8:16]) | 1
+
+	return h1, h2
+}
+
+// parseBreachedLine will parse HASH:COUNT line, or SUFFIX:COUNT line of range file with given prefix
+func parseBreachedLine(prefix, line string) ([]byte, error) {
+	hexSum := prefix + strings.Split(line, ":")[0]
+	if len(hexSum) != 40 {
+		return nil, errors.New("invalid SHA-1 entry")
+	}
+
+	return hex.DecodeString(hexSum)
+}
+
+func countLines(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	n := 0
+	for scanner.Scan() {
+		n++
+	}
+
+	return n, scanner.Err()
+}
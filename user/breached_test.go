@@ -0,0 +1,98 @@
+package user_test
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"github.com/semirm-dev/faceit/user"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadBreachedPasswords_RangeFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	for i, pwd := range []string{"password", "123456", "qwerty"} {
+		sum := fmt.Sprintf("%X", sha1.Sum([]byte(pwd)))
+		line := fmt.Sprintf("%s:%d\r\n", sum[5:], i+1)
+
+		f, err := os.OpenFile(filepath.Join(dir, sum[:5]+".txt"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		assert.Nil(t, err)
+		_, err = f.WriteString(line)
+		assert.Nil(t, err)
+		assert.Nil(t, f.Close())
+	}
+
+	checker, err := user.LoadBreachedPasswords(dir, 0.001)
+
+	assert.Nil(t, err)
+	assert.True(t, checker.Breached("password"))
+	assert.True(t, checker.Breached("123456"))
+	assert.True(t, checker.Breached("qwerty"))
+	assert.False(t, checker.Breached("correct horse battery staple"))
+}
+
+func TestLoadBreachedPasswords_FullHashes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+
+	var lines []string
+	for i, pwd := range []string{"password", "123456"} {
+		lines = append(lines, fmt.Sprintf("%X:%d", sha1.Sum([]byte(pwd)), i+1))
+	}
+	assert.Nil(t, os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0600))
+
+	checker, err := user.LoadBreachedPasswords(path, 0.001)
+
+	assert.Nil(t, err)
+	assert.True(t, checker.Breached("password"))
+	assert.True(t, checker.Breached("123456"))
+	assert.False(t, checker.Breached("qwerty"))
+}
+
+func TestLoadBreachedPasswords_InvalidRangeFile_Returns_Fail(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "passwords.txt"), []byte("0018A45C4D1DEF81644B54AB7F969B88D65:1\n"), 0600))
+
+	checker, err := user.LoadBreachedPasswords(dir, 0.001)
+
+	assert.NotNil(t, err)
+	assert.Nil(t, checker)
+}
+
+func TestLoadBreachedPasswords_InvalidLine_Returns_Fail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	assert.Nil(t, os.WriteFile(path, []byte("not-a-hash:1\n"), 0600))
+
+	checker, err := user.LoadBreachedPasswords(path, 0.001)
+
+	assert.NotNil(t, err)
+	assert.Nil(t, checker)
+}
+
+func TestCommonPasswords_Bundled(t *testing.T) {
+	checker := user.CommonPasswords()
+
+	assert.True(t, checker.Breached("password"))
+	assert.True(t, checker.Breached("Qwerty123"))
+	assert.False(t, checker.Breached("correct horse battery staple"))
+}
+
+func TestPasswordCheckers_Extend_CommonPasswords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	assert.Nil(t, os.WriteFile(path, []byte(fmt.Sprintf("%X:1", sha1.Sum([]byte("correct horse battery staple")))), 0600))
+
+	breached, err := user.LoadBreachedPasswords(path, 0.001)
+	assert.Nil(t, err)
+
+	checker := user.PasswordCheckers(user.CommonPasswords(), breached)
+
+	assert.True(t, checker.Breached("password"))
+	assert.True(t, checker.Breached("correct horse battery staple"))
+	assert.False(t, checker.Breached("Tr0ub4dor&3-unique"))
+}
+
+func TestNewConfig_Rejects_CommonPasswords(t *testing.T) {
+	assert.True(t, user.NewConfig().PasswordChecker.Breached("123456"))
+}
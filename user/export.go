@@ -0,0 +1,147 @@
+package user
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/semirm-dev/faceit/internal/logging"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Account export statuses
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+// MaxExportSize limits size of export archive, archive is sent in single grpc message so clients have to accept it
+const MaxExportSize = 64 << 20
+
+// ErrExportNotFound is returned for unknown and expired exports
+var ErrExportNotFound = status.Error(codes.NotFound, "account export not found")
+
+// ErrExportTooLarge is returned when archive exceeds MaxExportSize
+var ErrExportTooLarge = errors.New("account export exceeds max size")
+
+// AccountExport is machine-readable archive with all data service keeps about an account
+type AccountExport struct {
+	Id        string
+	AccountId string
+	Status    string
+	// Archive is zip with json files, set once export is ready
+	Archive     []byte
+	Error       string
+	CreatedAt   time.Time
+	CompletedAt time.Time
+	ExpiresAt   time.Time
+}
+
+// ExportRepository persists account exports
+type ExportRepository interface {
+	AddExport(ctx context.Context, export *AccountExport) (*AccountExport, error)
+	// SaveExport will complete pending export, ErrExportNotFound is returned if it's not pending anymore
+	// (e.g. it was removed when account was erased)
+	SaveExport(ctx context.Context, export *AccountExport) error
+	// GetExport returns nil if export doesn't exist
+	GetExport(ctx context.Context, id string) (*AccountExport, error)
+	DeleteExports(ctx context.Context, accountId string) error
+	DeleteExpiredExports(ctx context.Context, expiredBefore time.Time) error
+}
+
+// ExportPolicy defines how account data exports are generated and kept
+type ExportPolicy struct {
+	// Expiry is how long generated archive can be downloaded
+	Expiry time.Duration
+	// Timeout limits how long generating single archive can take
+	Timeout time.Duration
+}
+
+// NewExportPolicy will initialize default export policy
+func NewExportPolicy() *ExportPolicy {
+	return &ExportPolicy{
+		Expiry:  7 * 24 * time.Hour,
+		Timeout: 5 * time.Minute,
+	}
+}
+
+// exportProfile is account.json, password hash is never exported
+type exportProfile struct {
+	Id        string `json:"id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Nickname  string `json:"nickname"`
+	Email     string `json:"email"`
+	Country   string `json:"country"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+	Version   int64  `json:"version"`
+}
+
+// exportSecurity is security.json, service keeps no sessions so sign-in state is what it knows about account access
+type exportSecurity struct {
+	TwoFactor     *exportTwoFactor     `json:"two_factor"`
+	LoginAttempts *exportLoginAttempts `json:"login_attempts"`
+}
+
+type exportTwoFactor struct {
+	Enabled           bool   `json:"enabled"`
+	EnrolledAt        string `json:"enrolled_at"`
+	RecoveryCodesLeft int    `json:"recovery_codes_left"`
+}
+
+type exportLoginAttempts struct {
+	Failures     int    `json:"failures"`
+	LastFailedAt string `json:"last_failed_at"`
+	LockedUntil  string `json:"locked_until"`
+}
+
+// exportEvent is entry of events.json
+type exportEvent struct {
+	Event     string `json:"event"`
+	RequestId string `json:"request_id"`
+	CreatedAt string `json:"created_at"`
+}
+
+// exportAuditEntry is entry of audit.json, client ip is exported only for changes made by account itself
+type exportAuditEntry struct {
+	Action    string            `json:"action"`
+	Actor     string            `json:"actor"`
+	Changes   map[string]Change `json:"changes"`
+	RequestId string            `json:"request_id"`
+	ClientIP  string            `json:"client_ip,omitempty"`
+	CreatedAt string            `json:"created_at"`
+}
+
+func writeJSON(zw *zip.Writer, name string, content interface{}) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(content)
+}
+
+// detach returns ctx which is never cancelled, with request id and trace of given ctx
+func detach(ctx context.Context) context.Context {
+	detached := logging.WithRequestID(context.Background(), logging.RequestID(ctx))
+
+	return trace.ContextWithSpanContext(detached, trace.SpanContextFromContext(ctx))
+}
+
+// timestamp formats time as RFC 3339, empty for zero time
+func timestamp(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,36 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	"github.com/semirm-dev/faceit/internal/logging"
+)
+
+// AccountEvent is record of event published about an account
+type AccountEvent struct {
+	AccountId string
+	Event     string
+	RequestId string
+	CreatedAt time.Time
+}
+
+// EventHistoryRepository keeps events published about accounts
+type EventHistoryRepository interface {
+	AddAccountEvent(ctx context.Context, ev *AccountEvent) error
+	// GetAccountEvents returns all events of account, oldest first
+	GetAccountEvents(ctx context.Context, accountId string) ([]*AccountEvent, error)
+}
+
+// recordEvent will store event in account history, failure is only logged so it never fails the rpc
+func (svc *accountService) recordEvent(ctx context.Context, ev, accountId string) {
+	err := svc.repo.AddAccountEvent(ctx, &AccountEvent{
+		AccountId: accountId,
+		Event:     ev,
+		RequestId: logging.RequestID(ctx),
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to record account event: ", err)
+	}
+}
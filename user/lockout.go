@@ -0,0 +1,186 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrAccountLocked   = errors.New("account temporarily locked")
+	ErrClientLocked    = errors.New("client temporarily locked")
+	ErrTooManyAttempts = errors.New("too many failed attempts")
+)
+
+// LoginAttempts keeps track of failed credential checks for a single key (account or client ip)
+type LoginAttempts struct {
+	Key          string
+	Failures     int
+	LastFailedAt time.Time
+	LockedUntil  time.Time
+}
+
+// LoginAttemptRepository persists failed credential checks
+type LoginAttemptRepository interface {
+	GetLoginAttempts(ctx context.Context, key string) (*LoginAttempts, error)
+	// AddLoginFailure will atomically count failed check of key and return its attempts, so concurrent failures are
+	// never lost. Failures are counted from scratch once previous lockout expired.
+	AddLoginFailure(ctx context.Context, key string, failedAt time.Time) (*LoginAttempts, error)
+	LockLoginAttempts(ctx context.Context, key string, lockedUntil time.Time) error
+	ResetLoginAttempts(ctx context.Context, key string) error
+}
+
+// LockoutPolicy defines how failed credential checks are throttled
+type LockoutPolicy struct {
+	// MaxAccountFailures before account gets locked
+	MaxAccountFailures int
+	// MaxClientFailures before client ip gets locked
+	MaxClientFailures int
+	// BaseDelay is applied after first failure, and doubled for each next failure
+	BaseDelay time.Duration
+	// MaxDelay caps progressive delay
+	MaxDelay time.Duration
+	// LockoutDuration is how long account or client stays locked
+	LockoutDuration time.Duration
+}
+
+// NewLockoutPolicy will initialize default lockout policy
+func NewLockoutPolicy() *LockoutPolicy {
+	return &LockoutPolicy{
+		MaxAccountFailures: 5,
+		MaxClientFailures:  20,
+		BaseDelay:          time.Second,
+		MaxDelay:           30 * time.Second,
+		LockoutDuration:    15 * time.Minute,
+	}
+}
+
+// delay returns how long to wait before next attempt is allowed
+func (policy *LockoutPolicy) delay(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+
+	d := policy.BaseDelay
+	for i := 1; i < failures; i++ {
+		d *= 2
+		if d >= policy.MaxDelay {
+			return policy.MaxDelay
+		}
+	}
+
+	return d
+}
+
+// loginGuard protects credential checks from brute-force attempts
+type loginGuard struct {
+	repo   LoginAttemptRepository
+	policy *LockoutPolicy
+	now    func() time.Time
+}
+
+func newLoginGuard(repo LoginAttemptRepository, policy *LockoutPolicy) *loginGuard {
+	if policy == nil {
+		policy = NewLockoutPolicy()
+	}
+
+	return &loginGuard{
+		repo:   repo,
+		policy: policy,
+		now:    func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// allow checks if account and client are allowed to attempt credential check
+func (guard *loginGuard) allow(ctx context.Context, accountId, clientIP string) error {
+	if err := guard.check(ctx, accountKey(accountId), ErrAccountLocked); err != nil {
+		return err
+	}
+
+	if clientIP != "" {
+		if err := guard.check(ctx, clientKey(clientIP), ErrClientLocked); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// failed registers failed credential check, returned flags tell if account or client just got locked
+func (guard *loginGuard) failed(ctx context.Context, accountId, clientIP string) (accountLocked, clientLocked bool, err error) {
+	accountLocked, err = guard.register(ctx, accountKey(accountId), guard.policy.MaxAccountFailures)
+	if err != nil {
+		return
+	}
+
+	if clientIP != "" {
+		clientLocked, err = guard.register(ctx, clientKey(clientIP), guard.policy.MaxClientFailures)
+	}
+
+	return
+}
+
+// succeeded resets account failures, client failures are kept so one valid account can't reset them
+func (guard *loginGuard) succeeded(ctx context.Context, accountId string) error {
+	return guard.repo.ResetLoginAttempts(ctx, accountKey(accountId))
+}
+
+// unlock removes account lockout and all of its failures, and of client ip if given
+func (guard *loginGuard) unlock(ctx context.Context, accountId, clientIP string) error {
+	if err := guard.repo.ResetLoginAttempts(ctx, accountKey(accountId)); err != nil {
+		return err
+	}
+
+	if clientIP != "" {
+		return guard.repo.ResetLoginAttempts(ctx, clientKey(clientIP))
+	}
+
+	return nil
+}
+
+func (guard *loginGuard) check(ctx context.Context, key string, lockedErr error) error {
+	attempts, err := guard.repo.GetLoginAttempts(ctx, key)
+	if err != nil {
+		return err
+	}
+	if attempts == nil {
+		return nil
+	}
+
+	now := guard.now()
+
+	if now.Before(attempts.LockedUntil) {
+		return fmt.Errorf("%w, retry after %s", lockedErr, attempts.LockedUntil.Sub(now).Round(time.Second))
+	}
+
+	retryAt := attempts.LastFailedAt.Add(guard.policy.delay(attempts.Failures))
+	if now.Before(retryAt) {
+		return fmt.Errorf("%w, retry after %s", ErrTooManyAttempts, retryAt.Sub(now).Round(time.Second))
+	}
+
+	return nil
+}
+
+func (guard *loginGuard) register(ctx context.Context, key string, maxFailures int) (bool, error) {
+	now := guard.now()
+
+	attempts, err := guard.repo.AddLoginFailure(ctx, key, now)
+	if err != nil {
+		return false, err
+	}
+
+	if maxFailures <= 0 || attempts.Failures < maxFailures {
+		return false, nil
+	}
+
+	return true, guard.repo.LockLoginAttempts(ctx, key, now.Add(guard.policy.LockoutDuration))
+}
+
+func accountKey(id string) string {
+	return "account:" + id
+}
+
+func clientKey(ip string) string {
+	return "client:" + ip
+}
@@ -0,0 +1,91 @@
+package user
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/semirm-dev/faceit/internal/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// ClientIPKey is grpc metadata key used by gateway to forward original client ip, it's trusted only
+// from authenticated clients (bearer token or mTLS)
+const ClientIPKey = "x-forwarded-for"
+
+// APIKeyKey is grpc metadata key used by gateway to forward api key of request,
+// account service validates it again before recording it as actor in audit log
+const APIKeyKey = "x-api-key"
+
+type actorCtx struct{}
+
+// WithActor will store actor in ctx, used for changes not made through grpc
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorCtx{}, actor)
+}
+
+// actor identifies who made the change: service itself, api key forwarded by gateway or client certificate (mTLS),
+// actor is never taken from metadata as is
+func (svc *accountService) actor(ctx context.Context) (string, error) {
+	if actor, ok := ctx.Value(actorCtx{}).(string); ok {
+		return actor, nil
+	}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(APIKeyKey); len(values) > 0 && values[0] != "" {
+			key, err := svc.repo.GetAPIKeyByHash(ctx, hashAPIKey(values[0]))
+			if err != nil {
+				return "", err
+			}
+			if key == nil || !key.Active(time.Now().UTC()) {
+				return "", ErrInvalidAPIKey
+			}
+
+			return APIKeyActor(key.Id), nil
+		}
+	}
+
+	if name := grpc.PeerName(ctx); name != "" {
+		return PeerActor(name), nil
+	}
+
+	return ActorAnonymous, nil
+}
+
+// clientIP will extract original client ip from grpc metadata of authenticated client, or fallback to grpc peer address
+func clientIP(ctx context.Context) string {
+	if ip := forwardedIP(ctx); ip != "" {
+		return ip
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+
+	return host
+}
+
+// forwardedIP will extract original client ip from grpc metadata, empty if client is not authenticated.
+// Peer address of unauthenticated client is usually gateway's, so it's not used to lock out clients.
+func forwardedIP(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || !grpc.Authenticated(ctx) {
+		return ""
+	}
+
+	values := md.Get(ClientIPKey)
+	if len(values) == 0 {
+		return ""
+	}
+
+	// first ip in the list is the original client
+	return strings.TrimSpace(strings.Split(values[0], ",")[0])
+}
@@ -0,0 +1,255 @@
+package user
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gobackpack/crypto"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	Argon2id = "argon2id"
+	BCrypt   = "bcrypt"
+)
+
+// HashAlgorithm is single password hashing algorithm, recognized by its hash prefix
+type HashAlgorithm interface {
+	// Name of the algorithm
+	Name() string
+	// Recognizes returns true if hashed value was produced by this algorithm
+	Recognizes(hashed string) bool
+	Hash(plain string) (string, error)
+	Validate(hashed, plain string) bool
+	// Outdated returns true if hashed value was produced with different cost parameters
+	Outdated(hashed string) bool
+}
+
+// passwordHash will hash new passwords with current algorithm,
+// and validate existing passwords with any of known algorithms
+type passwordHash struct {
+	current    HashAlgorithm
+	algorithms []HashAlgorithm
+}
+
+// NewPasswordHash will create PasswordHash which hashes with current algorithm,
+// other algorithms are used only to validate existing hashes
+func NewPasswordHash(current HashAlgorithm, others ...HashAlgorithm) *passwordHash {
+	return &passwordHash{
+		current:    current,
+		algorithms: append([]HashAlgorithm{current}, others...),
+	}
+}
+
+func (pwdHash *passwordHash) Hash(plain string) (string, error) {
+	return pwdHash.current.Hash(plain)
+}
+
+func (pwdHash *passwordHash) Validate(hashed, plain string) bool {
+	alg := pwdHash.algorithm(hashed)
+	if alg == nil {
+		return false
+	}
+
+	return alg.Validate(hashed, plain)
+}
+
+// NeedsRehash returns true if hashed value was not produced by current algorithm and its cost parameters
+func (pwdHash *passwordHash) NeedsRehash(hashed string) bool {
+	if !pwdHash.current.Recognizes(hashed) {
+		return true
+	}
+
+	return pwdHash.current.Outdated(hashed)
+}
+
+func (pwdHash *passwordHash) algorithm(hashed string) HashAlgorithm {
+	for _, alg := range pwdHash.algorithms {
+		if alg.Recognizes(hashed) {
+			return alg
+		}
+	}
+
+	return nil
+}
+
+// Argon2idHash produces PHC formatted hashes: $argon2id$v=19$m=65536,t=3,p=2$salt$key
+type Argon2idHash struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	SaltLen int
+	KeyLen  uint32
+}
+
+// NewArgon2idHash will initialize default argon2id params
+func NewArgon2idHash() *Argon2idHash {
+	return &Argon2idHash{
+		Time:    3,
+		Memory:  64 * 1024,
+		Threads: 2,
+		SaltLen: 32,
+		KeyLen:  32,
+	}
+}
+
+func (alg *Argon2idHash) Name() string {
+	return Argon2id
+}
+
+func (alg *Argon2idHash) Recognizes(hashed string) bool {
+	return strings.HasPrefix(hashed, "$"+Argon2id+"$")
+}
+
+func (alg *Argon2idHash) Hash(plain string) (string, error) {
+	salt, err := crypto.GenerateSalt(alg.SaltLen)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(plain), salt, alg.Time, alg.Memory, alg.Threads, alg.KeyLen)
+
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		Argon2id, argon2.Version, alg.Memory, alg.Time, alg.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (alg *Argon2idHash) Validate(hashed, plain string) bool {
+	existing, salt, key, err := decodeArgon2id(hashed)
+	if err != nil {
+		return false
+	}
+
+	dk := argon2.IDKey([]byte(plain), salt, existing.Time, existing.Memory, existing.Threads, existing.KeyLen)
+
+	return subtle.ConstantTimeCompare(key, dk) == 1
+}
+
+func (alg *Argon2idHash) Outdated(hashed string) bool {
+	existing, salt, _, err := decodeArgon2id(hashed)
+	if err != nil {
+		return true
+	}
+
+	return existing.Time != alg.Time ||
+		existing.Memory != alg.Memory ||
+		existing.Threads != alg.Threads ||
+		existing.KeyLen != alg.KeyLen ||
+		len(salt) != alg.SaltLen
+}
+
+func decodeArgon2id(hashed string) (*Argon2idHash, []byte, []byte, error) {
+	// "", "argon2id", "v=19", "m=65536,t=3,p=2", salt, key
+	vals := strings.Split(hashed, "$")
+	if len(vals) != 6 || vals[1] != Argon2id {
+		return nil, nil, nil, errors.New("invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(vals[2], "v=%d", &version); err != nil {
+		return nil, nil, nil, err
+	}
+	if version != argon2.Version {
+		return nil, nil, nil, errors.New("incompatible argon2 version")
+	}
+
+	alg := &Argon2idHash{}
+	if _, err := fmt.Sscanf(vals[3], "m=%d,t=%d,p=%d", &alg.Memory, &alg.Time, &alg.Threads); err != nil {
+		return nil, nil, nil, err
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(vals[4])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	alg.SaltLen = len(salt)
+
+	key, err := base64.RawStdEncoding.DecodeString(vals[5])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	alg.KeyLen = uint32(len(key))
+
+	return alg, salt, key, nil
+}
+
+// BCryptHash produces standard bcrypt hashes: $2a$10$...
+type BCryptHash struct {
+	Cost int
+}
+
+// NewBCryptHash will initialize default bcrypt params
+func NewBCryptHash() *BCryptHash {
+	return &BCryptHash{
+		Cost: bcrypt.DefaultCost,
+	}
+}
+
+func (alg *BCryptHash) Name() string {
+	return BCrypt
+}
+
+func (alg *BCryptHash) Recognizes(hashed string) bool {
+	return strings.HasPrefix(hashed, "$2a$") ||
+		strings.HasPrefix(hashed, "$2b$") ||
+		strings.HasPrefix(hashed, "$2y$")
+}
+
+func (alg *BCryptHash) Hash(plain string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(plain), alg.Cost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hashed), nil
+}
+
+func (alg *BCryptHash) Validate(hashed, plain string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hashed), []byte(plain)) == nil
+}
+
+func (alg *BCryptHash) Outdated(hashed string) bool {
+	cost, err := bcrypt.Cost([]byte(hashed))
+	if err != nil {
+		return true
+	}
+
+	return cost != alg.Cost
+}
+
+// LegacyArgon2Hash validates hashes produced by gobackpack/crypto Argon2 (19$65536$3$2$salt$key),
+// used by initial versions of account service. It should never be used as current algorithm.
+type LegacyArgon2Hash struct {
+	argon *crypto.Argon2
+}
+
+func NewLegacyArgon2Hash() *LegacyArgon2Hash {
+	return &LegacyArgon2Hash{
+		argon: crypto.NewArgon2(),
+	}
+}
+
+func (alg *LegacyArgon2Hash) Name() string {
+	return "legacy-argon2"
+}
+
+func (alg *LegacyArgon2Hash) Recognizes(hashed string) bool {
+	return strings.HasPrefix(hashed, fmt.Sprintf("%d$", argon2.Version))
+}
+
+func (alg *LegacyArgon2Hash) Hash(plain string) (string, error) {
+	return crypto.NewArgon2().Hash(plain)
+}
+
+func (alg *LegacyArgon2Hash) Validate(hashed, plain string) bool {
+	return alg.argon.Validate(hashed, plain)
+}
+
+func (alg *LegacyArgon2Hash) Outdated(hashed string) bool {
+	return true
+}
@@ -0,0 +1,68 @@
+package user_test
+
+import (
+	"github.com/gobackpack/crypto"
+	"github.com/semirm-dev/faceit/user"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func lightArgon2id() *user.Argon2idHash {
+	argon := user.NewArgon2idHash()
+	argon.Time = 1
+	argon.Memory = 1024
+	argon.Threads = 1
+
+	return argon
+}
+
+func TestPasswordHash_Argon2id_Valid_Returns_Success(t *testing.T) {
+	pwdHash := user.NewPasswordHash(lightArgon2id())
+
+	hashed, err := pwdHash.Hash("pwd123")
+
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(hashed, "$argon2id$v=19$m=1024,t=1,p=1$"))
+	assert.True(t, pwdHash.Validate(hashed, "pwd123"))
+	assert.False(t, pwdHash.Validate(hashed, "invalid"))
+	assert.False(t, pwdHash.NeedsRehash(hashed))
+}
+
+func TestPasswordHash_OutdatedParams_NeedsRehash(t *testing.T) {
+	old := lightArgon2id()
+	hashed, err := user.NewPasswordHash(old).Hash("pwd123")
+	assert.Nil(t, err)
+
+	current := lightArgon2id()
+	current.Time = 2
+	pwdHash := user.NewPasswordHash(current)
+
+	assert.True(t, pwdHash.Validate(hashed, "pwd123"))
+	assert.True(t, pwdHash.NeedsRehash(hashed))
+}
+
+func TestPasswordHash_OtherAlgorithm_NeedsRehash(t *testing.T) {
+	bc := user.NewBCryptHash()
+	bc.Cost = 4
+
+	bcHashed, err := bc.Hash("pwd123")
+	assert.Nil(t, err)
+
+	legacyHashed, err := crypto.NewArgon2().Hash("pwd123")
+	assert.Nil(t, err)
+
+	pwdHash := user.NewPasswordHash(lightArgon2id(), bc, user.NewLegacyArgon2Hash())
+
+	assert.True(t, pwdHash.Validate(bcHashed, "pwd123"))
+	assert.True(t, pwdHash.NeedsRehash(bcHashed))
+	assert.True(t, pwdHash.Validate(legacyHashed, "pwd123"))
+	assert.True(t, pwdHash.NeedsRehash(legacyHashed))
+}
+
+func TestPasswordHash_UnknownAlgorithm_Returns_Fail(t *testing.T) {
+	pwdHash := user.NewPasswordHash(lightArgon2id())
+
+	assert.False(t, pwdHash.Validate("$unknown$pwd123", "pwd123"))
+	assert.True(t, pwdHash.NeedsRehash("$unknown$pwd123"))
+}
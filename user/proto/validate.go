@@ -0,0 +1,157 @@
+package proto
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Validate methods are called by grpc validation interceptors, on both client and server side
+
+var (
+	errIdRequired       = errors.New("id is required")
+	errEmailRequired    = errors.New("email is required")
+	errPasswordRequired = errors.New("password is required")
+)
+
+// AccountMessage fields which can be modified, named as in update mask
+const (
+	FieldFirstName = "first_name"
+	FieldLastName  = "last_name"
+	FieldNickname  = "nickname"
+	FieldCountry   = "country"
+)
+
+// ModifiableAccountFields are AccountMessage fields which can be named in update mask,
+// gateway and account service take them from here
+var ModifiableAccountFields = []string{FieldFirstName, FieldLastName, FieldNickname, FieldCountry}
+
+// ModifiableField returns value of modifiable field, nil for fields which can't be modified
+func (req *AccountMessage) ModifiableField(field string) *string {
+	switch field {
+	case FieldFirstName:
+		return &req.FirstName
+	case FieldLastName:
+		return &req.LastName
+	case FieldNickname:
+		return &req.Nickname
+	case FieldCountry:
+		return &req.Country
+	default:
+		return nil
+	}
+}
+
+func (req *AccountRequest) Validate() error {
+	if strings.TrimSpace(req.Email) == "" {
+		return errEmailRequired
+	}
+	if req.Password == "" {
+		return errPasswordRequired
+	}
+
+	return nil
+}
+
+func (req *AccountMessage) Validate() error {
+	if err := requireId(req.Id); err != nil {
+		return err
+	}
+
+	for _, path := range req.GetUpdateMask().GetPaths() {
+		if !modifiableAccountField(path) {
+			return fmt.Errorf("field %s can not be modified", path)
+		}
+	}
+
+	return nil
+}
+
+func modifiableAccountField(path string) bool {
+	for _, field := range ModifiableAccountFields {
+		if field == path {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (req *ChangePasswordRequest) Validate() error {
+	if err := requireId(req.Id); err != nil {
+		return err
+	}
+	if req.NewPassword == "" {
+		return errPasswordRequired
+	}
+
+	return nil
+}
+
+func (req *DeleteAccountRequest) Validate() error {
+	return requireId(req.Id)
+}
+
+func (req *UnlockAccountRequest) Validate() error {
+	if req.ClientIp != "" && net.ParseIP(req.ClientIp) == nil {
+		return errors.New("client_ip is not valid ip address")
+	}
+
+	return requireId(req.Id)
+}
+
+func (req *RestoreAccountRequest) Validate() error {
+	return requireId(req.Id)
+}
+
+func (req *PurgeAccountRequest) Validate() error {
+	return requireId(req.Id)
+}
+
+func (req *GetAccountsByFilterRequest) Validate() error {
+	if req.Page < 0 || req.Limit < 0 {
+		return errors.New("page and limit must not be negative")
+	}
+	if req.IncludeDeleted && req.OnlyDeleted {
+		return errors.New("include_deleted and only_deleted can not be combined")
+	}
+
+	return nil
+}
+
+func (req *EnrollTOTPRequest) Validate() error {
+	return requireId(req.Id)
+}
+
+func (req *ConfirmTOTPRequest) Validate() error {
+	return requireId(req.Id)
+}
+
+func (req *DisableTOTPRequest) Validate() error {
+	return requireId(req.Id)
+}
+
+func (req *RegenerateRecoveryCodesRequest) Validate() error {
+	return requireId(req.Id)
+}
+
+func (req *RevokeAPIKeyRequest) Validate() error {
+	return requireId(req.Id)
+}
+
+func (req *ValidateAPIKeyRequest) Validate() error {
+	if req.Key == "" {
+		return errors.New("key is required")
+	}
+
+	return nil
+}
+
+func requireId(id string) error {
+	if strings.TrimSpace(id) == "" {
+		return errIdRequired
+	}
+
+	return nil
+}
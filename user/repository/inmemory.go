@@ -2,37 +2,110 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/google/uuid"
+	"github.com/semirm-dev/faceit/internal/db"
 	"github.com/semirm-dev/faceit/user"
+	"sync"
 	"time"
 )
 
 type inmemory struct {
 	Accounts []*user.Account
+	Attempts map[string]*user.LoginAttempts
+	TOTPs    map[string]*user.TOTP
+	APIKeys  []*user.APIKey
+	Events   []*user.AccountEvent
+	Exports  map[string]*user.AccountExport
+	AuditLog []*user.AuditEntry
+	// txMu serializes transactions, there is no isolation between transaction and calls outside of it,
+	// failed transaction reverts only its own writes
+	txMu sync.Mutex
+	// bgMu guards events, exports and audit log, they are written by background jobs too
+	bgMu sync.Mutex
+	// credsMu guards login attempts and second factors, they are updated by concurrent credential checks
+	credsMu sync.Mutex
+}
+
+type inmemoryTxKey struct{}
+
+// inmemoryTx keeps undo of each write made in transaction, they are run in reverse order when it fails
+type inmemoryTx struct {
+	undo []func()
 }
 
 func NewAccountInmemory() *inmemory {
 	return &inmemory{}
 }
 
+// WithinTx will run fn in transaction, changes made by fn are reverted if it returns error
+func (repo *inmemory) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if ctx.Value(inmemoryTxKey{}) != nil {
+		return fn(ctx)
+	}
+
+	repo.txMu.Lock()
+	defer repo.txMu.Unlock()
+
+	tx := &inmemoryTx{}
+
+	if err := fn(context.WithValue(ctx, inmemoryTxKey{}, tx)); err != nil {
+		for i := len(tx.undo) - 1; i >= 0; i-- {
+			tx.undo[i]()
+		}
+		return err
+	}
+
+	return nil
+}
+
+// onRollback registers undo of write made in transaction of ctx, it's not needed outside of transaction
+func onRollback(ctx context.Context, undo func()) {
+	if tx, ok := ctx.Value(inmemoryTxKey{}).(*inmemoryTx); ok {
+		tx.undo = append(tx.undo, undo)
+	}
+}
+
 func (repo *inmemory) AddAccount(ctx context.Context, account *user.Account) (*user.Account, error) {
 	account.Id = uuid.New().String()
 	account.CreatedAt = time.Now().UTC()
 	account.UpdatedAt = time.Now().UTC()
+	account.Version = 1
 
+	repo.keepAccount(ctx, account.Id)
 	repo.Accounts = append(repo.Accounts, account)
 
 	return account, nil
 }
 
-func (repo *inmemory) ModifyAccount(ctx context.Context, id string, account *user.Account) (*user.Account, error) {
+func (repo *inmemory) ModifyAccount(ctx context.Context, id string, account *user.Account, fields []string) (*user.Account, error) {
 	acc := repo.getById(id)
 	if acc != nil {
-		acc.FirstName = account.FirstName
-		acc.LastName = account.LastName
-		acc.Nickname = account.Nickname
-		acc.Country = account.Country
+		if account.Version != 0 && account.Version != acc.Version {
+			return nil, user.ErrVersionConflict
+		}
+
+		repo.keepAccount(ctx, id)
+		modified := *acc
+		for _, field := range fields {
+			switch field {
+			case user.FieldFirstName:
+				modified.FirstName = account.FirstName
+			case user.FieldLastName:
+				modified.LastName = account.LastName
+			case user.FieldNickname:
+				modified.Nickname = account.Nickname
+			case user.FieldCountry:
+				modified.Country = account.Country
+			default:
+				return nil, fmt.Errorf("field %s can not be modified", field)
+			}
+		}
+
+		*acc = modified
 		acc.UpdatedAt = time.Now().UTC()
+		acc.Version++
 	}
 
 	return acc, nil
@@ -41,31 +114,101 @@ func (repo *inmemory) ModifyAccount(ctx context.Context, id string, account *use
 func (repo *inmemory) ChangePassword(ctx context.Context, id, password string) error {
 	acc := repo.getById(id)
 	if acc != nil {
+		repo.keepAccount(ctx, id)
 		acc.Password = password
 		acc.UpdatedAt = time.Now().UTC()
+		acc.Version++
 	}
 
 	return nil
 }
 
+// DeleteAccount will soft delete account, it can be restored until purged
 func (repo *inmemory) DeleteAccount(ctx context.Context, id string) error {
-	for i, acc := range repo.Accounts {
-		if acc.Id == id {
-			copy(repo.Accounts[i:], repo.Accounts[i+1:])
-			repo.Accounts[len(repo.Accounts)-1] = nil
-			repo.Accounts = repo.Accounts[:len(repo.Accounts)-1]
-			break
+	acc := repo.getById(id)
+	if acc != nil {
+		repo.keepAccount(ctx, id)
+		acc.DeletedAt = time.Now().UTC()
+	}
+
+	return nil
+}
+
+func (repo *inmemory) GetDeletedById(ctx context.Context, id string) (*user.Account, error) {
+	for _, acc := range repo.Accounts {
+		if acc.Id == id && !acc.DeletedAt.IsZero() {
+			return acc, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (repo *inmemory) RestoreAccount(ctx context.Context, id string) error {
+	acc, _ := repo.GetDeletedById(ctx, id)
+	if acc != nil {
+		repo.keepAccount(ctx, id)
+		acc.DeletedAt = time.Time{}
+		acc.UpdatedAt = time.Now().UTC()
+		acc.Version++
+	}
+
+	return nil
+}
+
+func (repo *inmemory) EraseAccount(ctx context.Context, id string, erased *user.Account) error {
+	for _, acc := range repo.Accounts {
+		if acc.Id == id && acc.ErasedAt.IsZero() {
+			repo.keepAccount(ctx, id)
+			acc.FirstName = erased.FirstName
+			acc.LastName = erased.LastName
+			acc.Nickname = erased.Nickname
+			acc.Email = erased.Email
+			acc.Password = erased.Password
+			acc.Country = erased.Country
+			acc.ErasedAt = erased.ErasedAt
+			if acc.DeletedAt.IsZero() {
+				acc.DeletedAt = erased.ErasedAt
+			}
+			acc.UpdatedAt = erased.ErasedAt
+			acc.Version++
 		}
 	}
 
 	return nil
 }
 
+func (repo *inmemory) PurgeAccount(ctx context.Context, id string) error {
+	repo.removeAccounts(ctx, func(acc *user.Account) bool {
+		return acc.Id == id && !acc.DeletedAt.IsZero()
+	}, 0)
+
+	return nil
+}
+
+func (repo *inmemory) PurgeDeleted(ctx context.Context, deletedBefore time.Time, limit int) ([]*user.Account, error) {
+	return repo.removeAccounts(ctx, func(acc *user.Account) bool {
+		return !acc.DeletedAt.IsZero() && acc.DeletedAt.Before(deletedBefore)
+	}, limit), nil
+}
+
 func (repo *inmemory) GetAccountsByFilter(ctx context.Context, filter *user.Filter) ([]*user.Account, error) {
-	accounts := repo.Accounts
+	var accounts []*user.Account
+
+	for _, acc := range repo.Accounts {
+		deleted := !acc.DeletedAt.IsZero()
+
+		if deleted && !filter.IncludeDeleted && !filter.OnlyDeleted {
+			continue
+		}
+		if !deleted && filter.OnlyDeleted {
+			continue
+		}
+		if filter.Country != "" && acc.Country != filter.Country {
+			continue
+		}
 
-	if filter.Country != "" {
-		accounts = repo.getByCountry(filter.Country)
+		accounts = append(accounts, acc)
 	}
 
 	return accounts, nil
@@ -79,9 +222,397 @@ func (repo *inmemory) GetByEmail(ctx context.Context, email string) (*user.Accou
 	return repo.getByEmail(email), nil
 }
 
+func (repo *inmemory) GetLoginAttempts(ctx context.Context, key string) (*user.LoginAttempts, error) {
+	repo.credsMu.Lock()
+	defer repo.credsMu.Unlock()
+
+	attempts, ok := repo.Attempts[key]
+	if !ok {
+		return nil, nil
+	}
+
+	copied := *attempts
+
+	return &copied, nil
+}
+
+func (repo *inmemory) AddLoginFailure(ctx context.Context, key string, failedAt time.Time) (*user.LoginAttempts, error) {
+	repo.credsMu.Lock()
+	defer repo.credsMu.Unlock()
+
+	if repo.Attempts == nil {
+		repo.Attempts = make(map[string]*user.LoginAttempts)
+	}
+
+	repo.keepAttempts(ctx, key)
+
+	attempts, ok := repo.Attempts[key]
+	if !ok {
+		attempts = &user.LoginAttempts{Key: key}
+		repo.Attempts[key] = attempts
+	}
+
+	// previous lockout expired, start counting from scratch
+	if !attempts.LockedUntil.IsZero() && !failedAt.Before(attempts.LockedUntil) {
+		attempts.Failures = 0
+		attempts.LockedUntil = time.Time{}
+	}
+
+	attempts.Failures++
+	attempts.LastFailedAt = failedAt
+
+	copied := *attempts
+
+	return &copied, nil
+}
+
+func (repo *inmemory) LockLoginAttempts(ctx context.Context, key string, lockedUntil time.Time) error {
+	repo.credsMu.Lock()
+	defer repo.credsMu.Unlock()
+
+	if attempts, ok := repo.Attempts[key]; ok {
+		repo.keepAttempts(ctx, key)
+		attempts.LockedUntil = lockedUntil
+	}
+
+	return nil
+}
+
+func (repo *inmemory) ResetLoginAttempts(ctx context.Context, key string) error {
+	repo.credsMu.Lock()
+	defer repo.credsMu.Unlock()
+
+	repo.keepAttempts(ctx, key)
+	delete(repo.Attempts, key)
+
+	return nil
+}
+
+func (repo *inmemory) GetTOTP(ctx context.Context, accountId string) (*user.TOTP, error) {
+	repo.credsMu.Lock()
+	defer repo.credsMu.Unlock()
+
+	totp, ok := repo.TOTPs[accountId]
+	if !ok {
+		return nil, nil
+	}
+
+	copied := *totp
+	copied.RecoveryCodes = append([]string(nil), totp.RecoveryCodes...)
+
+	return &copied, nil
+}
+
+func (repo *inmemory) SaveTOTP(ctx context.Context, totp *user.TOTP) error {
+	repo.credsMu.Lock()
+	defer repo.credsMu.Unlock()
+
+	if repo.TOTPs == nil {
+		repo.TOTPs = make(map[string]*user.TOTP)
+	}
+
+	repo.keepTOTP(ctx, totp.AccountId)
+
+	copied := *totp
+	copied.RecoveryCodes = append([]string(nil), totp.RecoveryCodes...)
+	repo.TOTPs[totp.AccountId] = &copied
+
+	return nil
+}
+
+func (repo *inmemory) DeleteTOTP(ctx context.Context, accountId string) error {
+	repo.credsMu.Lock()
+	defer repo.credsMu.Unlock()
+
+	repo.keepTOTP(ctx, accountId)
+	delete(repo.TOTPs, accountId)
+
+	return nil
+}
+
+func (repo *inmemory) UseTOTPStep(ctx context.Context, accountId string, step int64) (bool, error) {
+	repo.credsMu.Lock()
+	defer repo.credsMu.Unlock()
+
+	totp, ok := repo.TOTPs[accountId]
+	if !ok || totp.LastUsedStep >= step {
+		return false, nil
+	}
+
+	repo.keepTOTP(ctx, accountId)
+	totp.LastUsedStep = step
+
+	return true, nil
+}
+
+func (repo *inmemory) UseRecoveryCode(ctx context.Context, accountId, hashed string) (bool, error) {
+	repo.credsMu.Lock()
+	defer repo.credsMu.Unlock()
+
+	totp, ok := repo.TOTPs[accountId]
+	if !ok {
+		return false, nil
+	}
+
+	for i, code := range totp.RecoveryCodes {
+		if code == hashed {
+			repo.keepTOTP(ctx, accountId)
+			totp.RecoveryCodes = append(totp.RecoveryCodes[:i:i], totp.RecoveryCodes[i+1:]...)
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (repo *inmemory) AddAPIKey(ctx context.Context, key *user.APIKey) (*user.APIKey, error) {
+	key.Id = uuid.New().String()
+	key.CreatedAt = time.Now().UTC()
+
+	repo.keepAPIKey(ctx, key.Id)
+	repo.APIKeys = append(repo.APIKeys, copyAPIKey(key))
+
+	return key, nil
+}
+
+func (repo *inmemory) GetAPIKeyByHash(ctx context.Context, hash string) (*user.APIKey, error) {
+	for _, key := range repo.APIKeys {
+		if key.Hash == hash {
+			return copyAPIKey(key), nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (repo *inmemory) GetAPIKeys(ctx context.Context) ([]*user.APIKey, error) {
+	keys := make([]*user.APIKey, 0, len(repo.APIKeys))
+	for _, key := range repo.APIKeys {
+		keys = append(keys, copyAPIKey(key))
+	}
+
+	return keys, nil
+}
+
+func (repo *inmemory) RevokeAPIKey(ctx context.Context, id string, revokedAt time.Time) error {
+	for _, key := range repo.APIKeys {
+		if key.Id == id {
+			repo.keepAPIKey(ctx, id)
+			key.RevokedAt = revokedAt
+			return nil
+		}
+	}
+
+	return errors.New("api key not found")
+}
+
+func (repo *inmemory) TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+	for _, key := range repo.APIKeys {
+		if key.Id == id {
+			repo.keepAPIKey(ctx, id)
+			key.LastUsedAt = usedAt
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (repo *inmemory) AddAccountEvent(ctx context.Context, ev *user.AccountEvent) error {
+	repo.bgMu.Lock()
+	defer repo.bgMu.Unlock()
+
+	copied := *ev
+	repo.Events = append(repo.Events, &copied)
+
+	onRollback(ctx, func() {
+		repo.bgMu.Lock()
+		defer repo.bgMu.Unlock()
+
+		for i, ev := range repo.Events {
+			if ev == &copied {
+				repo.Events = append(repo.Events[:i:i], repo.Events[i+1:]...)
+				return
+			}
+		}
+	})
+
+	return nil
+}
+
+func (repo *inmemory) GetAccountEvents(ctx context.Context, accountId string) ([]*user.AccountEvent, error) {
+	repo.bgMu.Lock()
+	defer repo.bgMu.Unlock()
+
+	var events []*user.AccountEvent
+	for _, ev := range repo.Events {
+		if ev.AccountId == accountId {
+			copied := *ev
+			events = append(events, &copied)
+		}
+	}
+
+	return events, nil
+}
+
+func (repo *inmemory) AddExport(ctx context.Context, export *user.AccountExport) (*user.AccountExport, error) {
+	repo.bgMu.Lock()
+	defer repo.bgMu.Unlock()
+
+	if repo.Exports == nil {
+		repo.Exports = make(map[string]*user.AccountExport)
+	}
+
+	export.Id = uuid.New().String()
+	repo.keepExport(ctx, export.Id)
+	copied := *export
+	repo.Exports[export.Id] = &copied
+
+	return export, nil
+}
+
+func (repo *inmemory) SaveExport(ctx context.Context, export *user.AccountExport) error {
+	repo.bgMu.Lock()
+	defer repo.bgMu.Unlock()
+
+	if pending, ok := repo.Exports[export.Id]; !ok || pending.Status != user.ExportPending {
+		return user.ErrExportNotFound
+	}
+
+	repo.keepExport(ctx, export.Id)
+	copied := *export
+	repo.Exports[export.Id] = &copied
+
+	return nil
+}
+
+func (repo *inmemory) GetExport(ctx context.Context, id string) (*user.AccountExport, error) {
+	repo.bgMu.Lock()
+	defer repo.bgMu.Unlock()
+
+	export, ok := repo.Exports[id]
+	if !ok {
+		return nil, nil
+	}
+	copied := *export
+
+	return &copied, nil
+}
+
+func (repo *inmemory) DeleteExports(ctx context.Context, accountId string) error {
+	repo.bgMu.Lock()
+	defer repo.bgMu.Unlock()
+
+	for id, export := range repo.Exports {
+		if export.AccountId == accountId {
+			repo.keepExport(ctx, id)
+			delete(repo.Exports, id)
+		}
+	}
+
+	return nil
+}
+
+func (repo *inmemory) DeleteExpiredExports(ctx context.Context, expiredBefore time.Time) error {
+	repo.bgMu.Lock()
+	defer repo.bgMu.Unlock()
+
+	for id, export := range repo.Exports {
+		if export.ExpiresAt.Before(expiredBefore) {
+			repo.keepExport(ctx, id)
+			delete(repo.Exports, id)
+		}
+	}
+
+	return nil
+}
+
+func (repo *inmemory) AddAuditEntry(ctx context.Context, entry *user.AuditEntry) error {
+	repo.bgMu.Lock()
+	defer repo.bgMu.Unlock()
+
+	// entries removed by rollback leave gaps, as sequence would
+	entry.Id = 1
+	if n := len(repo.AuditLog); n > 0 {
+		entry.Id = repo.AuditLog[n-1].Id + 1
+	}
+	copied := *entry
+	repo.AuditLog = append(repo.AuditLog, &copied)
+	repo.keepAuditEntry(ctx, nil, &copied)
+
+	return nil
+}
+
+func (repo *inmemory) GetAuditLog(ctx context.Context, filter *user.AuditFilter) ([]*user.AuditEntry, error) {
+	repo.bgMu.Lock()
+	defer repo.bgMu.Unlock()
+
+	var matching []*user.AuditEntry
+	for i := len(repo.AuditLog) - 1; i >= 0; i-- {
+		entry := repo.AuditLog[i]
+
+		if (filter.TargetId != "" && entry.TargetId != filter.TargetId) ||
+			(filter.Actor != "" && entry.Actor != filter.Actor) ||
+			(filter.Action != "" && entry.Action != filter.Action) ||
+			(!filter.Since.IsZero() && entry.CreatedAt.Before(filter.Since)) ||
+			(!filter.Until.IsZero() && !entry.CreatedAt.Before(filter.Until)) {
+			continue
+		}
+
+		copied := *entry
+		matching = append(matching, &copied)
+	}
+
+	pagination := &db.Pagination{
+		Page:  filter.Page,
+		Limit: filter.Limit,
+	}
+
+	offset := pagination.GetOffset()
+	if offset >= len(matching) {
+		return nil, nil
+	}
+
+	end := offset + pagination.GetLimit()
+	if end > len(matching) {
+		end = len(matching)
+	}
+
+	return matching[offset:end], nil
+}
+
+func (repo *inmemory) RedactAuditLog(ctx context.Context, targetId string, fields []string) error {
+	repo.bgMu.Lock()
+	defer repo.bgMu.Unlock()
+
+	for i, entry := range repo.AuditLog {
+		if entry.TargetId != targetId {
+			continue
+		}
+
+		redacted := *entry
+		redacted.ClientIP = ""
+		redacted.Changes = make(map[string]user.Change, len(entry.Changes))
+		for field, change := range entry.Changes {
+			redacted.Changes[field] = change
+		}
+		for _, field := range fields {
+			if _, ok := redacted.Changes[field]; ok {
+				redacted.Changes[field] = user.Change{Before: user.Redacted, After: user.Redacted}
+			}
+		}
+
+		repo.AuditLog[i] = &redacted
+		repo.keepAuditEntry(ctx, entry, &redacted)
+	}
+
+	return nil
+}
+
+// getById returns account which is not soft deleted
 func (repo *inmemory) getById(id string) *user.Account {
 	for _, acc := range repo.Accounts {
-		if acc.Id == id {
+		if acc.Id == id && acc.DeletedAt.IsZero() {
 			return acc
 		}
 	}
@@ -91,7 +622,7 @@ func (repo *inmemory) getById(id string) *user.Account {
 
 func (repo *inmemory) getByEmail(email string) *user.Account {
 	for _, acc := range repo.Accounts {
-		if acc.Email == email {
+		if acc.Email == email && acc.DeletedAt.IsZero() {
 			return acc
 		}
 	}
@@ -99,14 +630,183 @@ func (repo *inmemory) getByEmail(email string) *user.Account {
 	return nil
 }
 
-func (repo *inmemory) getByCountry(country string) []*user.Account {
-	var accounts []*user.Account
+// removeAccounts will remove up to limit accounts matching fn (0 is unlimited), returns their ids
+func (repo *inmemory) removeAccounts(ctx context.Context, fn func(acc *user.Account) bool, limit int) []*user.Account {
+	var removed []*user.Account
+	kept := repo.Accounts[:0]
 
 	for _, acc := range repo.Accounts {
-		if acc.Country == country {
-			accounts = append(accounts, acc)
+		if fn(acc) && (limit <= 0 || len(removed) < limit) {
+			repo.keepAccount(ctx, acc.Id)
+			removed = append(removed, acc)
+			continue
+		}
+
+		kept = append(kept, acc)
+	}
+
+	for i := len(kept); i < len(repo.Accounts); i++ {
+		repo.Accounts[i] = nil
+	}
+	repo.Accounts = kept
+
+	return removed
+}
+
+// copyAPIKey returns copy of key, so callers can't modify stored keys
+func copyAPIKey(key *user.APIKey) *user.APIKey {
+	copied := *key
+	copied.Scopes = append([]string(nil), key.Scopes...)
+
+	return &copied
+}
+
+// keepAccount registers undo of write to account made in transaction
+func (repo *inmemory) keepAccount(ctx context.Context, id string) {
+	index, prev := len(repo.Accounts), (*user.Account)(nil)
+	for i, acc := range repo.Accounts {
+		if acc.Id == id {
+			copied := *acc
+			index, prev = i, &copied
+			break
+		}
+	}
+
+	onRollback(ctx, func() {
+		for i, acc := range repo.Accounts {
+			if acc.Id == id {
+				if prev == nil {
+					repo.Accounts = append(repo.Accounts[:i:i], repo.Accounts[i+1:]...)
+				} else {
+					*acc = *prev
+				}
+				return
+			}
+		}
+
+		// account was removed, it's put back to its place
+		if prev != nil {
+			if index > len(repo.Accounts) {
+				index = len(repo.Accounts)
+			}
+			repo.Accounts = append(repo.Accounts[:index:index], append([]*user.Account{prev}, repo.Accounts[index:]...)...)
+		}
+	})
+}
+
+// keepAttempts registers undo of write to login attempts made in transaction, credsMu must be held
+func (repo *inmemory) keepAttempts(ctx context.Context, key string) {
+	var prev *user.LoginAttempts
+	if attempts, ok := repo.Attempts[key]; ok {
+		copied := *attempts
+		prev = &copied
+	}
+
+	onRollback(ctx, func() {
+		repo.credsMu.Lock()
+		defer repo.credsMu.Unlock()
+
+		if prev == nil {
+			delete(repo.Attempts, key)
+			return
+		}
+
+		if repo.Attempts == nil {
+			repo.Attempts = make(map[string]*user.LoginAttempts)
+		}
+		repo.Attempts[key] = prev
+	})
+}
+
+// keepTOTP registers undo of write to second factor made in transaction, credsMu must be held
+func (repo *inmemory) keepTOTP(ctx context.Context, accountId string) {
+	var prev *user.TOTP
+	if totp, ok := repo.TOTPs[accountId]; ok {
+		copied := *totp
+		copied.RecoveryCodes = append([]string(nil), totp.RecoveryCodes...)
+		prev = &copied
+	}
+
+	onRollback(ctx, func() {
+		repo.credsMu.Lock()
+		defer repo.credsMu.Unlock()
+
+		if prev == nil {
+			delete(repo.TOTPs, accountId)
+			return
+		}
+
+		if repo.TOTPs == nil {
+			repo.TOTPs = make(map[string]*user.TOTP)
 		}
+		repo.TOTPs[accountId] = prev
+	})
+}
+
+// keepAPIKey registers undo of write to api key made in transaction
+func (repo *inmemory) keepAPIKey(ctx context.Context, id string) {
+	var prev *user.APIKey
+	for _, key := range repo.APIKeys {
+		if key.Id == id {
+			prev = copyAPIKey(key)
+			break
+		}
+	}
+
+	onRollback(ctx, func() {
+		for i, key := range repo.APIKeys {
+			if key.Id == id {
+				if prev == nil {
+					repo.APIKeys = append(repo.APIKeys[:i:i], repo.APIKeys[i+1:]...)
+				} else {
+					*key = *prev
+				}
+				return
+			}
+		}
+	})
+}
+
+// keepExport registers undo of write to export made in transaction, bgMu must be held
+func (repo *inmemory) keepExport(ctx context.Context, id string) {
+	var prev *user.AccountExport
+	if export, ok := repo.Exports[id]; ok {
+		copied := *export
+		prev = &copied
 	}
 
-	return accounts
+	onRollback(ctx, func() {
+		repo.bgMu.Lock()
+		defer repo.bgMu.Unlock()
+
+		if prev == nil {
+			delete(repo.Exports, id)
+			return
+		}
+
+		if repo.Exports == nil {
+			repo.Exports = make(map[string]*user.AccountExport)
+		}
+		repo.Exports[id] = prev
+	})
+}
+
+// keepAuditEntry registers undo of audit entry written in transaction, entries are never modified in place,
+// so added entry is removed and replaced one is put back
+func (repo *inmemory) keepAuditEntry(ctx context.Context, prev, written *user.AuditEntry) {
+	onRollback(ctx, func() {
+		repo.bgMu.Lock()
+		defer repo.bgMu.Unlock()
+
+		for i, entry := range repo.AuditLog {
+			if entry == written {
+				if prev == nil {
+					repo.AuditLog = append(repo.AuditLog[:i:i], repo.AuditLog[i+1:]...)
+				} else {
+					repo.AuditLog[i] = prev
+				}
+				return
+			}
+		}
+	})
 }
@@ -0,0 +1,173 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"github.com/semirm-dev/faceit/user"
+	"github.com/semirm-dev/faceit/user/repository"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+var errFailed = errors.New("failed")
+
+func TestInmemory_WithinTx_Failed_RollsBack(t *testing.T) {
+	repo := repository.NewAccountInmemory()
+	repo.Accounts = []*user.Account{
+		{
+			Id:        "123",
+			FirstName: "user 1",
+			Email:     "user1@mail.com",
+			Version:   1,
+		},
+	}
+
+	err := repo.WithinTx(context.Background(), func(ctx context.Context) error {
+		if _, err := repo.AddAccount(ctx, &user.Account{Email: "user2@mail.com"}); err != nil {
+			return err
+		}
+
+		if _, err := repo.ModifyAccount(ctx, "123", &user.Account{FirstName: "modified"}, []string{user.FieldFirstName}); err != nil {
+			return err
+		}
+
+		if err := repo.SaveTOTP(ctx, &user.TOTP{AccountId: "123", Secret: "secret"}); err != nil {
+			return err
+		}
+
+		if _, err := repo.AddAPIKey(ctx, &user.APIKey{Name: "matchmaking", Scopes: []string{user.ScopeAdmin}}); err != nil {
+			return err
+		}
+
+		if err := repo.AddAuditEntry(ctx, &user.AuditEntry{Action: user.ActionAddAccount, TargetId: "123"}); err != nil {
+			return err
+		}
+
+		return errFailed
+	})
+
+	assert.Equal(t, errFailed, err)
+	assert.Equal(t, 1, len(repo.Accounts))
+	assert.Equal(t, "user 1", repo.Accounts[0].FirstName)
+	assert.Equal(t, int64(1), repo.Accounts[0].Version)
+	assert.Empty(t, repo.TOTPs)
+	assert.Empty(t, repo.APIKeys)
+	assert.Empty(t, repo.AuditLog)
+}
+
+func TestInmemory_WithinTx_Succeeded_Keeps_Changes(t *testing.T) {
+	repo := repository.NewAccountInmemory()
+
+	err := repo.WithinTx(context.Background(), func(ctx context.Context) error {
+		if _, err := repo.AddAccount(ctx, &user.Account{Email: "user1@mail.com"}); err != nil {
+			return err
+		}
+
+		return repo.AddAuditEntry(ctx, &user.AuditEntry{Action: user.ActionAddAccount, CreatedAt: time.Now()})
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(repo.Accounts))
+	assert.Equal(t, 1, len(repo.AuditLog))
+}
+
+func TestInmemory_WithinTx_Nested_RollsBack_Outer(t *testing.T) {
+	repo := repository.NewAccountInmemory()
+
+	err := repo.WithinTx(context.Background(), func(ctx context.Context) error {
+		if _, err := repo.AddAccount(ctx, &user.Account{Email: "user1@mail.com"}); err != nil {
+			return err
+		}
+
+		// nested call joins outer transaction
+		err := repo.WithinTx(ctx, func(ctx context.Context) error {
+			_, err := repo.AddAccount(ctx, &user.Account{Email: "user2@mail.com"})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		return errFailed
+	})
+
+	assert.Equal(t, errFailed, err)
+	assert.Empty(t, repo.Accounts)
+}
+
+func TestInmemory_WithinTx_Failed_Keeps_Writes_OutsideTx(t *testing.T) {
+	repo := repository.NewAccountInmemory()
+	repo.Accounts = []*user.Account{
+		{Id: "123", FirstName: "user 1", Email: "user1@mail.com", Version: 1},
+		{Id: "456", FirstName: "user 2", Email: "user2@mail.com", Version: 1, DeletedAt: time.Now().UTC()},
+	}
+
+	var export *user.AccountExport
+
+	err := repo.WithinTx(context.Background(), func(ctx context.Context) error {
+		if _, err := repo.ModifyAccount(ctx, "123", &user.Account{FirstName: "modified"}, []string{user.FieldFirstName}); err != nil {
+			return err
+		}
+
+		if err := repo.PurgeAccount(ctx, "456"); err != nil {
+			return err
+		}
+
+		if err := repo.AddAuditEntry(ctx, &user.AuditEntry{Action: user.ActionModifyAccount, TargetId: "123"}); err != nil {
+			return err
+		}
+
+		// background work writes while transaction is open
+		background := context.Background()
+
+		if err := repo.AddAccountEvent(background, &user.AccountEvent{AccountId: "123", Event: "account_modified"}); err != nil {
+			return err
+		}
+
+		var err error
+		if export, err = repo.AddExport(background, &user.AccountExport{AccountId: "123"}); err != nil {
+			return err
+		}
+
+		if _, err = repo.AddLoginFailure(background, "client:127.0.0.1", time.Now()); err != nil {
+			return err
+		}
+
+		if err = repo.AddAuditEntry(background, &user.AuditEntry{Action: user.ActionExportAccountData, TargetId: "123"}); err != nil {
+			return err
+		}
+
+		return errFailed
+	})
+
+	assert.Equal(t, errFailed, err)
+
+	assert.Equal(t, 2, len(repo.Accounts))
+	assert.Equal(t, "user 1", repo.Accounts[0].FirstName)
+	assert.Equal(t, int64(1), repo.Accounts[0].Version)
+	assert.Equal(t, "456", repo.Accounts[1].Id)
+
+	assert.Equal(t, 1, len(repo.AuditLog))
+	assert.Equal(t, user.ActionExportAccountData, repo.AuditLog[0].Action)
+
+	assert.Equal(t, 1, len(repo.Events))
+	assert.Contains(t, repo.Exports, export.Id)
+	assert.Equal(t, 1, repo.Attempts["client:127.0.0.1"].Failures)
+}
+
+func TestInmemory_SaveExport_NotPending_Returns_NotFound(t *testing.T) {
+	repo := repository.NewAccountInmemory()
+
+	export, err := repo.AddExport(context.Background(), &user.AccountExport{AccountId: "123", Status: user.ExportPending})
+	assert.Nil(t, err)
+
+	export.Status = user.ExportReady
+	assert.Nil(t, repo.SaveExport(context.Background(), export))
+	assert.Equal(t, user.ErrExportNotFound, repo.SaveExport(context.Background(), export))
+
+	// export removed when account was erased is not stored again
+	assert.Nil(t, repo.DeleteExports(context.Background(), "123"))
+	assert.Equal(t, user.ErrExportNotFound, repo.SaveExport(context.Background(), export))
+	assert.Empty(t, repo.Exports)
+}
@@ -2,11 +2,17 @@ package repository
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/google/uuid"
 	"github.com/semirm-dev/faceit/internal/db"
 	"github.com/semirm-dev/faceit/user"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"math"
+	"strings"
 	"time"
 )
 
@@ -21,47 +27,175 @@ type Account struct {
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	DeletedAt gorm.DeletedAt `gorm:"index"`
+	Version   int64
+	ErasedAt  sql.NullTime
+}
+
+type LoginAttempt struct {
+	Key          string `gorm:"primarykey"`
+	Failures     int
+	LastFailedAt time.Time
+	LockedUntil  time.Time
+	UpdatedAt    time.Time
+}
+
+type AccountTOTP struct {
+	AccountId     uuid.UUID `gorm:"primarykey"`
+	Secret        string
+	Confirmed     bool
+	RecoveryCodes string
+	LastUsedStep  int64
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+type ApiKey struct {
+	Id         uuid.UUID `gorm:"primarykey"`
+	Name       string
+	Hash       string `gorm:"uniqueIndex"`
+	Scopes     string
+	AccountId  string
+	ExpiresAt  time.Time
+	LastUsedAt time.Time
+	RevokedAt  time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+type AccountEvent struct {
+	Id        int64 `gorm:"primarykey"`
+	AccountId uuid.UUID
+	Event     string
+	RequestId string
+	CreatedAt time.Time
+}
+
+type AccountExport struct {
+	Id          uuid.UUID `gorm:"primarykey"`
+	AccountId   uuid.UUID
+	Status      string
+	Archive     []byte
+	Error       string
+	CreatedAt   time.Time
+	CompletedAt time.Time
+	ExpiresAt   time.Time
+	UpdatedAt   time.Time
+}
+
+type AuditEntry struct {
+	Id         int64 `gorm:"primarykey"`
+	Actor      string
+	Action     string
+	TargetType string
+	TargetId   string
+	// Changes is json object, field name to user.Change
+	Changes   string
+	RequestId string
+	ClientIP  string
+	CreatedAt time.Time
+}
+
+func (AuditEntry) TableName() string {
+	return "audit_log"
 }
 
 type pgDb struct {
 	db *gorm.DB
 }
 
-func NewPgDb(db *gorm.DB) *pgDb {
-	db.AutoMigrate(&Account{})
+type pgTxKey struct{}
 
+// NewPgDb will create postgres repository, schema is managed by db migrations
+func NewPgDb(db *gorm.DB) *pgDb {
 	return &pgDb{
 		db: db,
 	}
 }
 
+// WithinTx will run fn in database transaction, committed if fn returns nil and rolled back otherwise.
+// Repository calls made with ctx passed to fn join the transaction, accounts read by id or email are locked until it ends.
+func (repo *pgDb) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(pgTxKey{}).(*gorm.DB); ok {
+		return fn(ctx)
+	}
+
+	return repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(context.WithValue(ctx, pgTxKey{}, tx))
+	})
+}
+
+// conn returns transaction from ctx if there is one, statements are cancelled together with ctx
+func (repo *pgDb) conn(ctx context.Context) *gorm.DB {
+	if tx, ok := ctx.Value(pgTxKey{}).(*gorm.DB); ok {
+		return tx.WithContext(ctx)
+	}
+
+	return repo.db.WithContext(ctx)
+}
+
+// lockForUpdate will lock selected rows until transaction ends, outside of transaction it does nothing
+func lockForUpdate(ctx context.Context, query *gorm.DB) *gorm.DB {
+	if _, ok := ctx.Value(pgTxKey{}).(*gorm.DB); ok {
+		return query.Clauses(clause.Locking{Strength: "UPDATE"})
+	}
+
+	return query
+}
+
 func (repo *pgDb) AddAccount(ctx context.Context, account *user.Account) (*user.Account, error) {
 	acc := accountToEntity(account)
 	acc.Id = uuid.New()
+	acc.Version = 1
 
-	if err := repo.db.Create(&acc).Error; err != nil {
+	if err := repo.conn(ctx).Create(&acc).Error; err != nil {
 		return nil, err
 	}
 
 	account.Id = acc.Id.String()
 	account.CreatedAt = acc.CreatedAt
 	account.UpdatedAt = acc.UpdatedAt
+	account.Version = acc.Version
 
 	return account, nil
 }
 
-func (repo *pgDb) ModifyAccount(ctx context.Context, id string, account *user.Account) (*user.Account, error) {
-	var acc *Account
-	if err := repo.db.Where("id = ?", id).Find(&acc).Error; err != nil {
-		return nil, err
+// ModifyAccount will update given fields of account only if its version matches account.Version (0 skips the check)
+func (repo *pgDb) ModifyAccount(ctx context.Context, id string, account *user.Account, fields []string) (*user.Account, error) {
+	updates := map[string]interface{}{
+		"updated_at": time.Now().UTC(),
+		"version":    gorm.Expr("version + 1"),
+	}
+
+	for _, field := range fields {
+		switch field {
+		case user.FieldFirstName:
+			updates["firstname"] = account.FirstName
+		case user.FieldLastName:
+			updates["lastname"] = account.LastName
+		case user.FieldNickname:
+			updates["nickname"] = account.Nickname
+		case user.FieldCountry:
+			updates["country"] = account.Country
+		default:
+			return nil, fmt.Errorf("field %s can not be modified", field)
+		}
 	}
 
-	acc.Firstname = account.FirstName
-	acc.Lastname = account.LastName
-	acc.Nickname = account.Nickname
-	acc.Country = account.Country
+	query := repo.conn(ctx).Model(&Account{}).Where("id = ?", id)
+	if account.Version != 0 {
+		query = query.Where("version = ?", account.Version)
+	}
+
+	res := query.Updates(updates)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 && account.Version != 0 {
+		return nil, user.ErrVersionConflict
+	}
 
-	if err := repo.db.Save(&acc).Error; err != nil {
+	var acc *Account
+	if err := repo.conn(ctx).Where("id = ?", id).Find(&acc).Error; err != nil {
 		return nil, err
 	}
 
@@ -69,54 +203,507 @@ func (repo *pgDb) ModifyAccount(ctx context.Context, id string, account *user.Ac
 }
 
 func (repo *pgDb) ChangePassword(ctx context.Context, id, password string) error {
+	return repo.conn(ctx).Model(&Account{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"password":   password,
+		"updated_at": time.Now().UTC(),
+		"version":    gorm.Expr("version + 1"),
+	}).Error
+}
+
+// DeleteAccount will soft delete account, it can be restored until purged
+func (repo *pgDb) DeleteAccount(ctx context.Context, id string) error {
+	return repo.conn(ctx).Where("id = ?", id).Delete(&Account{}).Error
+}
+
+func (repo *pgDb) GetDeletedById(ctx context.Context, id string) (*user.Account, error) {
 	var acc *Account
-	if err := repo.db.Where("id = ?", id).Find(&acc).Error; err != nil {
-		return err
+	err := lockForUpdate(ctx, repo.conn(ctx)).Unscoped().
+		Where("id = ? AND deleted_at IS NOT NULL", id).
+		Find(&acc).Error
+	if err != nil {
+		return nil, err
+	}
+	if acc == nil || acc.Email == "" {
+		return nil, nil
 	}
 
-	acc.Password = password
+	return entityToAccount(acc), nil
+}
+
+func (repo *pgDb) RestoreAccount(ctx context.Context, id string) error {
+	return repo.conn(ctx).Unscoped().Model(&Account{}).
+		Where("id = ? AND deleted_at IS NOT NULL", id).
+		Updates(map[string]interface{}{
+			"deleted_at": nil,
+			"updated_at": time.Now().UTC(),
+			"version":    gorm.Expr("version + 1"),
+		}).Error
+}
 
-	return repo.db.Save(&acc).Error
+// EraseAccount will overwrite personal data of account with placeholders, account is soft deleted if it's not already
+func (repo *pgDb) EraseAccount(ctx context.Context, id string, erased *user.Account) error {
+	return repo.conn(ctx).Unscoped().Model(&Account{}).
+		Where("id = ? AND erased_at IS NULL", id).
+		Updates(map[string]interface{}{
+			"firstname":  erased.FirstName,
+			"lastname":   erased.LastName,
+			"nickname":   erased.Nickname,
+			"email":      erased.Email,
+			"password":   erased.Password,
+			"country":    erased.Country,
+			"erased_at":  erased.ErasedAt,
+			"deleted_at": gorm.Expr("COALESCE(deleted_at, ?)", erased.ErasedAt),
+			"updated_at": erased.ErasedAt,
+			"version":    gorm.Expr("version + 1"),
+		}).Error
 }
 
-func (repo *pgDb) DeleteAccount(ctx context.Context, id string) error {
-	var acc *Account
-	if err := repo.db.Where("id = ?", id).Find(&acc).Error; err != nil {
-		return err
+func (repo *pgDb) PurgeAccount(ctx context.Context, id string) error {
+	return repo.conn(ctx).Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id).Delete(&Account{}).Error
+}
+
+// PurgeDeleted skips accounts locked by other transactions, so concurrent purges don't remove the same accounts
+func (repo *pgDb) PurgeDeleted(ctx context.Context, deletedBefore time.Time, limit int) ([]*user.Account, error) {
+	var accounts []*Account
+	err := repo.conn(ctx).Unscoped().
+		Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("deleted_at < ?", deletedBefore).
+		Order("deleted_at asc").
+		Limit(limit).
+		Find(&accounts).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(accounts) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]string, 0, len(accounts))
+	purged := make([]*user.Account, 0, len(accounts))
+	for _, acc := range accounts {
+		account := entityToAccount(acc)
+		ids = append(ids, account.Id)
+		purged = append(purged, account)
+	}
+
+	if err = repo.conn(ctx).Unscoped().Where("id IN ?", ids).Delete(&Account{}).Error; err != nil {
+		return nil, err
 	}
 
-	return repo.db.Delete(acc).Error
+	return purged, nil
 }
 
 func (repo *pgDb) GetAccountsByFilter(ctx context.Context, filter *user.Filter) ([]*user.Account, error) {
 	var accounts []*Account
 
-	repo.db.Scopes(
-		byCountry(repo.db, accounts, filter.Country),
-		paginate(repo.db, accounts, &db.Pagination{
+	conn := repo.conn(ctx)
+	switch {
+	case filter.OnlyDeleted:
+		conn = conn.Unscoped().Where("deleted_at IS NOT NULL")
+	case filter.IncludeDeleted:
+		conn = conn.Unscoped()
+	}
+	// new session, so conn can be reused for count and find
+	conn = conn.Session(&gorm.Session{})
+
+	err := conn.Scopes(
+		byCountry(conn, accounts, filter.Country),
+		paginate(conn, accounts, &db.Pagination{
 			Page:  filter.Page,
 			Limit: filter.Limit,
-		})).Find(&accounts)
+		})).Find(&accounts).Error
+	if err != nil {
+		return nil, err
+	}
 
 	return entitiesToAccounts(accounts), nil
 }
 
 func (repo *pgDb) GetById(ctx context.Context, id string) (*user.Account, error) {
 	var acc *Account
-	if err := repo.db.Where("id = ?", id).Find(&acc).Error; err != nil {
+	if err := lockForUpdate(ctx, repo.conn(ctx)).Where("id = ?", id).Find(&acc).Error; err != nil {
 		return nil, err
 	}
 	return entityToAccount(acc), nil
 }
 
 func (repo *pgDb) GetByEmail(ctx context.Context, email string) (*user.Account, error) {
+	if _, ok := ctx.Value(pgTxKey{}).(*gorm.DB); ok {
+		// there may be no row to lock, so concurrent transactions checking the same email wait on advisory lock
+		if err := repo.conn(ctx).Exec("SELECT pg_advisory_xact_lock(hashtext(?))", email).Error; err != nil {
+			return nil, err
+		}
+	}
+
 	var acc *Account
-	if err := repo.db.Where("email = ?", email).Find(&acc).Error; err != nil {
+	if err := repo.conn(ctx).Where("email = ?", email).Find(&acc).Error; err != nil {
 		return nil, err
 	}
 	return entityToAccount(acc), nil
 }
 
+func (repo *pgDb) GetLoginAttempts(ctx context.Context, key string) (*user.LoginAttempts, error) {
+	var attempt *LoginAttempt
+	if err := repo.conn(ctx).Where("key = ?", key).Find(&attempt).Error; err != nil {
+		return nil, err
+	}
+	if attempt == nil || attempt.Key == "" {
+		return nil, nil
+	}
+
+	return &user.LoginAttempts{
+		Key:          attempt.Key,
+		Failures:     attempt.Failures,
+		LastFailedAt: attempt.LastFailedAt,
+		LockedUntil:  attempt.LockedUntil,
+	}, nil
+}
+
+// AddLoginFailure will count failure with single upsert, concurrent failures of the same key wait for each other
+func (repo *pgDb) AddLoginFailure(ctx context.Context, key string, failedAt time.Time) (*user.LoginAttempts, error) {
+	var attempt LoginAttempt
+	err := repo.conn(ctx).Raw(`INSERT INTO login_attempts AS a (key, failures, last_failed_at, locked_until, updated_at)
+		VALUES (@key, 1, @failed_at, @never, @failed_at)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN a.locked_until > @never AND a.locked_until <= @failed_at THEN 1 ELSE a.failures + 1 END,
+			locked_until = CASE WHEN a.locked_until <= @failed_at THEN @never ELSE a.locked_until END,
+			last_failed_at = @failed_at,
+			updated_at = @failed_at
+		RETURNING key, failures, last_failed_at, locked_until`,
+		map[string]interface{}{"key": key, "failed_at": failedAt, "never": time.Time{}}).Scan(&attempt).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &user.LoginAttempts{
+		Key:          attempt.Key,
+		Failures:     attempt.Failures,
+		LastFailedAt: attempt.LastFailedAt,
+		LockedUntil:  attempt.LockedUntil,
+	}, nil
+}
+
+func (repo *pgDb) LockLoginAttempts(ctx context.Context, key string, lockedUntil time.Time) error {
+	return repo.conn(ctx).Model(&LoginAttempt{}).Where("key = ?", key).Update("locked_until", lockedUntil).Error
+}
+
+func (repo *pgDb) ResetLoginAttempts(ctx context.Context, key string) error {
+	return repo.conn(ctx).Where("key = ?", key).Delete(&LoginAttempt{}).Error
+}
+
+func (repo *pgDb) GetTOTP(ctx context.Context, accountId string) (*user.TOTP, error) {
+	var totp *AccountTOTP
+	if err := repo.conn(ctx).Where("account_id = ?", accountId).Find(&totp).Error; err != nil {
+		return nil, err
+	}
+	if totp == nil || totp.Secret == "" {
+		return nil, nil
+	}
+
+	var codes []string
+	if totp.RecoveryCodes != "" {
+		codes = strings.Split(totp.RecoveryCodes, ",")
+	}
+
+	return &user.TOTP{
+		AccountId:     totp.AccountId.String(),
+		Secret:        totp.Secret,
+		Confirmed:     totp.Confirmed,
+		RecoveryCodes: codes,
+		LastUsedStep:  totp.LastUsedStep,
+		CreatedAt:     totp.CreatedAt,
+	}, nil
+}
+
+func (repo *pgDb) SaveTOTP(ctx context.Context, totp *user.TOTP) error {
+	accountId, err := uuid.Parse(totp.AccountId)
+	if err != nil {
+		return err
+	}
+
+	return repo.conn(ctx).Save(&AccountTOTP{
+		AccountId:     accountId,
+		Secret:        totp.Secret,
+		Confirmed:     totp.Confirmed,
+		RecoveryCodes: strings.Join(totp.RecoveryCodes, ","),
+		LastUsedStep:  totp.LastUsedStep,
+		CreatedAt:     totp.CreatedAt,
+	}).Error
+}
+
+func (repo *pgDb) DeleteTOTP(ctx context.Context, accountId string) error {
+	return repo.conn(ctx).Where("account_id = ?", accountId).Delete(&AccountTOTP{}).Error
+}
+
+func (repo *pgDb) UseTOTPStep(ctx context.Context, accountId string, step int64) (bool, error) {
+	res := repo.conn(ctx).Model(&AccountTOTP{}).
+		Where("account_id = ? AND last_used_step < ?", accountId, step).
+		Update("last_used_step", step)
+
+	return res.RowsAffected == 1, res.Error
+}
+
+func (repo *pgDb) UseRecoveryCode(ctx context.Context, accountId, hashed string) (bool, error) {
+	res := repo.conn(ctx).Exec(`UPDATE account_totps
+		SET recovery_codes = array_to_string(array_remove(string_to_array(recovery_codes, ','), ?), ','), updated_at = now()
+		WHERE account_id = ? AND ? = ANY(string_to_array(recovery_codes, ','))`, hashed, accountId, hashed)
+
+	return res.RowsAffected == 1, res.Error
+}
+
+func (repo *pgDb) AddAPIKey(ctx context.Context, key *user.APIKey) (*user.APIKey, error) {
+	entity := &ApiKey{
+		Id:        uuid.New(),
+		Name:      key.Name,
+		Hash:      key.Hash,
+		Scopes:    strings.Join(key.Scopes, ","),
+		AccountId: key.AccountId,
+		ExpiresAt: key.ExpiresAt,
+	}
+
+	if err := repo.conn(ctx).Create(entity).Error; err != nil {
+		return nil, err
+	}
+
+	key.Id = entity.Id.String()
+	key.CreatedAt = entity.CreatedAt
+
+	return key, nil
+}
+
+func (repo *pgDb) GetAPIKeyByHash(ctx context.Context, hash string) (*user.APIKey, error) {
+	var key *ApiKey
+	if err := repo.conn(ctx).Where("hash = ?", hash).Find(&key).Error; err != nil {
+		return nil, err
+	}
+	if key == nil || key.Hash == "" {
+		return nil, nil
+	}
+
+	return entityToAPIKey(key), nil
+}
+
+func (repo *pgDb) GetAPIKeys(ctx context.Context) ([]*user.APIKey, error) {
+	var keys []*ApiKey
+	if err := repo.conn(ctx).Order("created_at asc").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+
+	var apiKeys []*user.APIKey
+	for _, key := range keys {
+		apiKeys = append(apiKeys, entityToAPIKey(key))
+	}
+
+	return apiKeys, nil
+}
+
+func (repo *pgDb) RevokeAPIKey(ctx context.Context, id string, revokedAt time.Time) error {
+	res := repo.conn(ctx).Model(&ApiKey{}).Where("id = ?", id).Update("revoked_at", revokedAt)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errors.New("api key not found")
+	}
+
+	return nil
+}
+
+func (repo *pgDb) TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+	return repo.conn(ctx).Model(&ApiKey{}).Where("id = ?", id).Update("last_used_at", usedAt).Error
+}
+
+func (repo *pgDb) AddAccountEvent(ctx context.Context, ev *user.AccountEvent) error {
+	accountId, err := uuid.Parse(ev.AccountId)
+	if err != nil {
+		return err
+	}
+
+	return repo.conn(ctx).Create(&AccountEvent{
+		AccountId: accountId,
+		Event:     ev.Event,
+		RequestId: ev.RequestId,
+		CreatedAt: ev.CreatedAt,
+	}).Error
+}
+
+func (repo *pgDb) GetAccountEvents(ctx context.Context, accountId string) ([]*user.AccountEvent, error) {
+	var entities []*AccountEvent
+	if err := repo.conn(ctx).Where("account_id = ?", accountId).Order("id asc").Find(&entities).Error; err != nil {
+		return nil, err
+	}
+
+	var events []*user.AccountEvent
+	for _, ev := range entities {
+		events = append(events, &user.AccountEvent{
+			AccountId: ev.AccountId.String(),
+			Event:     ev.Event,
+			RequestId: ev.RequestId,
+			CreatedAt: ev.CreatedAt,
+		})
+	}
+
+	return events, nil
+}
+
+func (repo *pgDb) AddExport(ctx context.Context, export *user.AccountExport) (*user.AccountExport, error) {
+	entity, err := exportToEntity(export)
+	if err != nil {
+		return nil, err
+	}
+	entity.Id = uuid.New()
+
+	if err = repo.conn(ctx).Create(entity).Error; err != nil {
+		return nil, err
+	}
+
+	export.Id = entity.Id.String()
+
+	return export, nil
+}
+
+func (repo *pgDb) SaveExport(ctx context.Context, export *user.AccountExport) error {
+	entity, err := exportToEntity(export)
+	if err != nil {
+		return err
+	}
+
+	// export removed meanwhile (e.g. account was erased) must not be inserted again, so only pending row is updated
+	res := repo.conn(ctx).Model(&AccountExport{}).Where("id = ? AND status = ?", entity.Id, user.ExportPending).Updates(map[string]interface{}{
+		"status":       entity.Status,
+		"archive":      entity.Archive,
+		"error":        entity.Error,
+		"completed_at": entity.CompletedAt,
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return user.ErrExportNotFound
+	}
+
+	return nil
+}
+
+func (repo *pgDb) GetExport(ctx context.Context, id string) (*user.AccountExport, error) {
+	var export *AccountExport
+	if err := repo.conn(ctx).Where("id = ?", id).Find(&export).Error; err != nil {
+		return nil, err
+	}
+	if export == nil || export.Status == "" {
+		return nil, nil
+	}
+
+	return &user.AccountExport{
+		Id:          export.Id.String(),
+		AccountId:   export.AccountId.String(),
+		Status:      export.Status,
+		Archive:     export.Archive,
+		Error:       export.Error,
+		CreatedAt:   export.CreatedAt,
+		CompletedAt: export.CompletedAt,
+		ExpiresAt:   export.ExpiresAt,
+	}, nil
+}
+
+func (repo *pgDb) DeleteExports(ctx context.Context, accountId string) error {
+	return repo.conn(ctx).Where("account_id = ?", accountId).Delete(&AccountExport{}).Error
+}
+
+func (repo *pgDb) DeleteExpiredExports(ctx context.Context, expiredBefore time.Time) error {
+	return repo.conn(ctx).Where("expires_at < ?", expiredBefore).Delete(&AccountExport{}).Error
+}
+
+func (repo *pgDb) AddAuditEntry(ctx context.Context, entry *user.AuditEntry) error {
+	changes, err := json.Marshal(entry.Changes)
+	if err != nil {
+		return err
+	}
+	if entry.Changes == nil {
+		changes = []byte("{}")
+	}
+
+	entity := &AuditEntry{
+		Actor:      entry.Actor,
+		Action:     entry.Action,
+		TargetType: entry.TargetType,
+		TargetId:   entry.TargetId,
+		Changes:    string(changes),
+		RequestId:  entry.RequestId,
+		ClientIP:   entry.ClientIP,
+		CreatedAt:  entry.CreatedAt,
+	}
+
+	if err = repo.conn(ctx).Create(entity).Error; err != nil {
+		return err
+	}
+	entry.Id = entity.Id
+
+	return nil
+}
+
+func (repo *pgDb) GetAuditLog(ctx context.Context, filter *user.AuditFilter) ([]*user.AuditEntry, error) {
+	query := repo.conn(ctx).Model(&AuditEntry{})
+	if filter.TargetId != "" {
+		query = query.Where("target_id = ?", filter.TargetId)
+	}
+	if filter.Actor != "" {
+		query = query.Where("actor = ?", filter.Actor)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("created_at >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query = query.Where("created_at < ?", filter.Until)
+	}
+
+	pagination := &db.Pagination{
+		Page:  filter.Page,
+		Limit: filter.Limit,
+		Sort:  "id desc",
+	}
+
+	var entities []*AuditEntry
+	err := query.Offset(pagination.GetOffset()).Limit(pagination.GetLimit()).Order(pagination.GetSort()).Find(&entities).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*user.AuditEntry
+	for _, entity := range entities {
+		changes := make(map[string]user.Change)
+		if err = json.Unmarshal([]byte(entity.Changes), &changes); err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, &user.AuditEntry{
+			Id:         entity.Id,
+			Actor:      entity.Actor,
+			Action:     entity.Action,
+			TargetType: entity.TargetType,
+			TargetId:   entity.TargetId,
+			Changes:    changes,
+			RequestId:  entity.RequestId,
+			ClientIP:   entity.ClientIP,
+			CreatedAt:  entity.CreatedAt,
+		})
+	}
+
+	return entries, nil
+}
+
+// RedactAuditLog will redact given fields and client ip with redact_audit_log function, application role can't update audit_log itself
+func (repo *pgDb) RedactAuditLog(ctx context.Context, targetId string, fields []string) error {
+	if _, ok := ctx.Value(pgTxKey{}).(*gorm.DB); !ok {
+		return errors.New("audit log can only be redacted in transaction")
+	}
+
+	return repo.conn(ctx).Exec("SELECT redact_audit_log(?, ?::text[])", targetId, "{"+strings.Join(fields, ",")+"}").Error
+}
+
 func paginate(db *gorm.DB, model interface{}, pagination *db.Pagination) func(db *gorm.DB) *gorm.DB {
 	var totalRows int64
 	db.Model(model).Count(&totalRows)
@@ -165,9 +752,55 @@ func entityToAccount(acc *Account) *user.Account {
 		CreatedAt: acc.CreatedAt,
 		UpdatedAt: acc.UpdatedAt,
 		DeletedAt: acc.DeletedAt.Time,
+		Version:   acc.Version,
+		ErasedAt:  acc.ErasedAt.Time,
 	}
 }
 
+func entityToAPIKey(key *ApiKey) *user.APIKey {
+	var scopes []string
+	if key.Scopes != "" {
+		scopes = strings.Split(key.Scopes, ",")
+	}
+
+	return &user.APIKey{
+		Id:         key.Id.String(),
+		Name:       key.Name,
+		Hash:       key.Hash,
+		Scopes:     scopes,
+		AccountId:  key.AccountId,
+		ExpiresAt:  key.ExpiresAt,
+		LastUsedAt: key.LastUsedAt,
+		RevokedAt:  key.RevokedAt,
+		CreatedAt:  key.CreatedAt,
+	}
+}
+
+func exportToEntity(export *user.AccountExport) (*AccountExport, error) {
+	entity := &AccountExport{
+		Status:      export.Status,
+		Archive:     export.Archive,
+		Error:       export.Error,
+		CreatedAt:   export.CreatedAt,
+		CompletedAt: export.CompletedAt,
+		ExpiresAt:   export.ExpiresAt,
+	}
+
+	accountId, err := uuid.Parse(export.AccountId)
+	if err != nil {
+		return nil, err
+	}
+	entity.AccountId = accountId
+
+	if export.Id != "" {
+		if entity.Id, err = uuid.Parse(export.Id); err != nil {
+			return nil, err
+		}
+	}
+
+	return entity, nil
+}
+
 func entitiesToAccounts(accs []*Account) []*user.Account {
 	var accounts []*user.Account
 
@@ -5,9 +5,13 @@ import (
 	"errors"
 	"github.com/semirm-dev/faceit/event"
 	"github.com/semirm-dev/faceit/internal/grpc"
+	"github.com/semirm-dev/faceit/internal/health"
+	"github.com/semirm-dev/faceit/internal/logging"
 	pbUser "github.com/semirm-dev/faceit/user/proto"
-	"github.com/sirupsen/logrus"
 	grpcLib "google.golang.org/grpc"
+	"strconv"
+	"sync"
+	"time"
 )
 
 const serviceName = "account management service"
@@ -15,10 +19,43 @@ const serviceName = "account management service"
 // accountService will expose account management service via grpc
 type accountService struct {
 	pbUser.UnimplementedAccountManagementServer
-	addr    string
-	repo    AccountRepository
-	pub     AccountPublisher
-	pwdHash PasswordHash
+	addr       string
+	repo       AccountRepository
+	pub        AccountPublisher
+	pwdHash    PasswordHash
+	pwdChecker PasswordChecker
+	guard      *loginGuard
+	totp       *totpManager
+	readiness  *health.Checker
+	server     *grpc.ServerOptions
+	purge      *PurgePolicy
+	exports    *ExportPolicy
+	// publishing tracks events being published in background
+	publishing sync.WaitGroup
+	// exporting tracks account exports being generated in background
+	exporting sync.WaitGroup
+	// purging tracks scheduled purge of deleted accounts
+	purging sync.WaitGroup
+}
+
+// Config for account service
+type Config struct {
+	Addr    string
+	Lockout *LockoutPolicy
+	// PasswordChecker rejects weak and breached passwords, bundled common passwords by default
+	PasswordChecker PasswordChecker
+	// TOTPIssuer is shown in authenticator apps
+	TOTPIssuer string
+	// TOTPKey encrypts stored totp secrets, must be 16, 24 or 32 bytes long, 2fa is disabled if empty
+	TOTPKey string
+	// Readiness checks of service dependencies, reported through grpc health service
+	Readiness *health.Checker
+	// Server configures grpc interceptor chain
+	Server *grpc.ServerOptions
+	// Purge defines when soft deleted accounts are permanently removed
+	Purge *PurgePolicy
+	// Exports defines how account data exports are generated and kept
+	Exports *ExportPolicy
 }
 
 // Filter to apply when querying data store for user accounts
@@ -26,57 +63,126 @@ type Filter struct {
 	Page    int
 	Limit   int
 	Country string
+	// IncludeDeleted returns soft deleted accounts too, OnlyDeleted returns just them
+	IncludeDeleted bool
+	OnlyDeleted    bool
 }
 
 // AccountRepository communicates to data store with user accounts
 type AccountRepository interface {
 	AddAccount(ctx context.Context, account *Account) (*Account, error)
-	ModifyAccount(ctx context.Context, id string, account *Account) (*Account, error)
+	// ModifyAccount will update only given fields of account, version is checked if account.Version is set
+	ModifyAccount(ctx context.Context, id string, account *Account, fields []string) (*Account, error)
 	ChangePassword(ctx context.Context, id, newPassword string) error
 	DeleteAccount(ctx context.Context, id string) error
 	GetById(ctx context.Context, id string) (*Account, error)
 	GetByEmail(ctx context.Context, email string) (*Account, error)
 	GetAccountsByFilter(ctx context.Context, filter *Filter) ([]*Account, error)
+	// GetDeletedById returns soft deleted account, nil if account doesn't exist or is not deleted
+	GetDeletedById(ctx context.Context, id string) (*Account, error)
+	RestoreAccount(ctx context.Context, id string) error
+	// EraseAccount will replace personal data of account (deleted or not) with erased placeholders and soft delete it
+	EraseAccount(ctx context.Context, id string, erased *Account) error
+	// PurgeAccount will permanently remove soft deleted account
+	PurgeAccount(ctx context.Context, id string) error
+	// PurgeDeleted will permanently remove up to limit accounts deleted before given time, returns removed accounts
+	PurgeDeleted(ctx context.Context, deletedBefore time.Time, limit int) ([]*Account, error)
+	// WithinTx will run fn atomically, repository calls made with ctx passed to fn are part of the transaction
+	WithinTx(ctx context.Context, fn func(ctx context.Context) error) error
+	LoginAttemptRepository
+	TOTPRepository
+	APIKeyRepository
+	EventHistoryRepository
+	ExportRepository
+	AuditRepository
 }
 
 // AccountPublisher will publish event that corresponds to an account action
 type AccountPublisher interface {
-	Publish(event string, msg interface{}) error
+	Publish(ctx context.Context, event string, msg interface{}) error
 }
 
+// PasswordHash will hash and validate account passwords
 type PasswordHash interface {
 	Hash(plain string) (string, error)
 	Validate(hashed, plain string) bool
+	// NeedsRehash returns true if hashed value uses outdated algorithm or cost parameters
+	NeedsRehash(hashed string) bool
+}
+
+// NewConfig will initialize default account service config
+func NewConfig() *Config {
+	return &Config{
+		Addr:            ":8001",
+		Lockout:         NewLockoutPolicy(),
+		PasswordChecker: CommonPasswords(),
+		TOTPIssuer:      "faceit",
+		Server:          grpc.NewServerOptions(),
+		Purge:           NewPurgePolicy(),
+		Exports:         NewExportPolicy(),
+	}
 }
 
-func NewAccountService(addr string, repo AccountRepository, pub AccountPublisher, pwdHash PasswordHash) *accountService {
+func NewAccountService(conf *Config, repo AccountRepository, pub AccountPublisher, pwdHash PasswordHash) *accountService {
+	if conf == nil {
+		conf = NewConfig()
+	}
+
 	return &accountService{
-		addr:    addr,
-		repo:    repo,
-		pub:     pub,
-		pwdHash: pwdHash,
+		addr:       conf.Addr,
+		repo:       repo,
+		pub:        pub,
+		pwdHash:    pwdHash,
+		pwdChecker: conf.PasswordChecker,
+		guard:      newLoginGuard(repo, conf.Lockout),
+		totp:       newTOTPManager(conf.TOTPIssuer, conf.TOTPKey),
+		readiness:  conf.Readiness,
+		server:     conf.Server,
+		purge:      conf.Purge,
+		exports:    conf.Exports,
 	}
 }
 
+// ListenForConnections will serve grpc until ctx is done, in-flight calls are finished before it returns
 func (svc *accountService) ListenForConnections(ctx context.Context) {
-	grpc.ListenForConnections(ctx, svc, svc.addr, serviceName)
+	grpc.ListenForConnections(ctx, svc, svc.addr, serviceName, svc.server)
+}
+
+// Close will wait for scheduled purge to stop, exports still being generated and events still being published,
+// or until ctx is done
+func (svc *accountService) Close(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		svc.purging.Wait()
+		svc.exporting.Wait()
+		svc.publishing.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func (svc *accountService) RegisterGrpcServer(server *grpcLib.Server) {
 	pbUser.RegisterAccountManagementServer(server, svc)
 }
 
+// Ready reports if account service dependencies are ready
+func (svc *accountService) Ready(ctx context.Context) error {
+	return svc.readiness.Ready(ctx)
+}
+
 // AddAccount will add new user account
 func (svc *accountService) AddAccount(ctx context.Context, req *pbUser.AccountRequest) (*pbUser.AccountMessage, error) {
-	existing, err := svc.repo.GetByEmail(ctx, req.Email)
-	if err != nil {
+	// slow hashing is done before transaction, so it doesn't hold locks meanwhile
+	if err := svc.validatePassword(req.Password); err != nil {
 		return nil, err
 	}
 
-	if existing != nil && existing.Email == req.Email {
-		return nil, errors.New("email already exists")
-	}
-
 	hashed, err := svc.pwdHash.Hash(req.Password)
 	if err != nil {
 		return nil, err
@@ -84,43 +190,84 @@ func (svc *accountService) AddAccount(ctx context.Context, req *pbUser.AccountRe
 
 	req.Password = hashed
 
-	account, err := svc.repo.AddAccount(ctx, protoReqToUserAccount(req))
+	var account *Account
+
+	err = svc.repo.WithinTx(ctx, func(ctx context.Context) error {
+		existing, err := svc.repo.GetByEmail(ctx, req.Email)
+		if err != nil {
+			return err
+		}
+
+		if existing != nil && existing.Email == req.Email {
+			return errors.New("email already exists")
+		}
+
+		account, err = svc.repo.AddAccount(ctx, protoReqToUserAccount(req))
+		if err != nil {
+			return err
+		}
+
+		return svc.audit(ctx, ActionAddAccount, TargetAccount, account.Id, diff(nil, accountFields(account)))
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	go func(id string) {
-		if pubErr := svc.pub.Publish(event.AccountCreated, id); pubErr != nil {
-			logrus.Error(pubErr)
-		}
-	}(account.Id)
+	svc.publishAccount(ctx, event.AccountCreated, account.Id)
 
 	return userAccountToProto(account), nil
 }
 
+// ModifyAccount will update account fields named in update mask, or all modifiable fields without it
 func (svc *accountService) ModifyAccount(ctx context.Context, req *pbUser.AccountMessage) (*pbUser.AccountMessage, error) {
-	existing, err := svc.repo.GetById(ctx, req.Id)
-	if err != nil {
-		return nil, err
-	}
-	if existing == nil || existing.Email == "" {
-		return nil, errors.New("account not found")
+	fields := ModifiableFields
+	if req.UpdateMask != nil {
+		fields = req.UpdateMask.Paths
 	}
 
-	account, err := svc.repo.ModifyAccount(ctx, req.Id, protoToUserAccount(req))
+	var account *Account
+
+	err := svc.repo.WithinTx(ctx, func(ctx context.Context) error {
+		existing, err := svc.repo.GetById(ctx, req.Id)
+		if err != nil {
+			return err
+		}
+		if existing == nil || existing.Email == "" {
+			return errors.New("account not found")
+		}
+
+		if len(fields) == 0 {
+			if req.Version != 0 && req.Version != existing.Version {
+				return ErrVersionConflict
+			}
+			account = existing
+			return nil
+		}
+
+		// inmemory repository modifies existing account in place
+		before := accountFields(existing)
+
+		account, err = svc.repo.ModifyAccount(ctx, req.Id, protoToUserAccount(req), fields)
+		if err != nil {
+			return err
+		}
+
+		return svc.audit(ctx, ActionModifyAccount, TargetAccount, account.Id, diff(before, accountFields(account)))
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	go func(id string) {
-		if pubErr := svc.pub.Publish(event.AccountModified, id); pubErr != nil {
-			logrus.Error(pubErr)
-		}
-	}(account.Id)
+	if len(fields) > 0 {
+		svc.publishAccount(ctx, event.AccountModified, account.Id)
+	}
 
 	return userAccountToProto(account), nil
 }
 
+// ChangePassword will replace account password, credentials are checked outside of transaction
+// so failed attempts are recorded even though password is not changed. Version is checked before credentials,
+// as their check may rehash the password.
 func (svc *accountService) ChangePassword(ctx context.Context, req *pbUser.ChangePasswordRequest) (*pbUser.ChangePasswordResponse, error) {
 	account, err := svc.repo.GetById(ctx, req.Id)
 	if err != nil {
@@ -129,9 +276,16 @@ func (svc *accountService) ChangePassword(ctx context.Context, req *pbUser.Chang
 	if account == nil || account.Email == "" {
 		return nil, errors.New("account not found")
 	}
+	if req.Version != 0 && req.Version != account.Version {
+		return nil, ErrVersionConflict
+	}
 
-	if !svc.pwdHash.Validate(account.Password, req.OldPassword) {
-		return nil, errors.New("invalid credentials")
+	if err = svc.validatePassword(req.NewPassword); err != nil {
+		return nil, err
+	}
+
+	if err = svc.checkCredentials(ctx, account, req.OldPassword, req.TotpCode); err != nil {
+		return nil, err
 	}
 
 	hashed, err := svc.pwdHash.Hash(req.NewPassword)
@@ -139,51 +293,243 @@ func (svc *accountService) ChangePassword(ctx context.Context, req *pbUser.Chang
 		return nil, err
 	}
 
-	if err = svc.repo.ChangePassword(ctx, req.Id, hashed); err != nil {
+	var version int64
+	err = svc.repo.WithinTx(ctx, func(ctx context.Context) error {
+		current, err := svc.repo.GetById(ctx, req.Id)
+		if err != nil {
+			return err
+		}
+		if current == nil || current.Email == "" {
+			return errors.New("account not found")
+		}
+		// row is locked now, version checked before it could be changed by concurrent request meanwhile
+		if req.Version != 0 && req.Version != current.Version {
+			return ErrVersionConflict
+		}
+		if current.Password != account.Password && !svc.pwdHash.Validate(current.Password, req.OldPassword) {
+			return errors.New("password was changed in the meantime")
+		}
+
+		if err = svc.repo.ChangePassword(ctx, req.Id, hashed); err != nil {
+			return err
+		}
+		version = current.Version + 1
+
+		return svc.audit(ctx, ActionChangePassword, TargetAccount, req.Id, map[string]Change{
+			"password": {Before: Redacted, After: Redacted},
+			"version":  {Before: strconv.FormatInt(current.Version, 10), After: strconv.FormatInt(version, 10)},
+		})
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	go func(id string) {
-		if pubErr := svc.pub.Publish(event.AccountModified, id); pubErr != nil {
-			logrus.Error(pubErr)
-		}
-	}(account.Id)
+	svc.publishAccount(ctx, event.AccountModified, account.Id)
 
 	return &pbUser.ChangePasswordResponse{
 		Success: true,
+		Version: version,
 	}, nil
 }
 
 func (svc *accountService) DeleteAccount(ctx context.Context, req *pbUser.DeleteAccountRequest) (*pbUser.DeleteAccountResponse, error) {
-	account, err := svc.repo.GetById(ctx, req.Id)
+	err := svc.repo.WithinTx(ctx, func(ctx context.Context) error {
+		account, err := svc.repo.GetById(ctx, req.Id)
+		if err != nil {
+			return err
+		}
+		if account == nil || account.Email == "" {
+			return errors.New("account not found")
+		}
+		before := accountFields(account)
+
+		if err = svc.repo.DeleteAccount(ctx, req.Id); err != nil {
+			return err
+		}
+
+		deleted, err := svc.repo.GetDeletedById(ctx, req.Id)
+		if err != nil {
+			return err
+		}
+
+		return svc.audit(ctx, ActionDeleteAccount, TargetAccount, req.Id, diff(before, accountFields(deleted)))
+	})
 	if err != nil {
 		return nil, err
 	}
-	if account == nil || account.Email == "" {
-		return nil, errors.New("account not found")
-	}
 
-	if err = svc.repo.DeleteAccount(ctx, req.Id); err != nil {
+	svc.publishAccount(ctx, event.AccountDeleted, req.Id)
+
+	return &pbUser.DeleteAccountResponse{
+		Success: true,
+	}, nil
+}
+
+// UnlockAccount will remove temporary lockout caused by failed credential checks, of account and optionally
+// of client ip, meant for admins
+func (svc *accountService) UnlockAccount(ctx context.Context, req *pbUser.UnlockAccountRequest) (*pbUser.UnlockAccountResponse, error) {
+	err := svc.repo.WithinTx(ctx, func(ctx context.Context) error {
+		account, err := svc.repo.GetById(ctx, req.Id)
+		if err != nil {
+			return err
+		}
+		if account == nil || account.Email == "" {
+			return errors.New("account not found")
+		}
+
+		attempts, err := svc.repo.GetLoginAttempts(ctx, accountKey(req.Id))
+		if err != nil {
+			return err
+		}
+
+		var clientAttempts *LoginAttempts
+		if req.ClientIp != "" {
+			if clientAttempts, err = svc.repo.GetLoginAttempts(ctx, clientKey(req.ClientIp)); err != nil {
+				return err
+			}
+		}
+
+		if err = svc.guard.unlock(ctx, req.Id, req.ClientIp); err != nil {
+			return err
+		}
+
+		changes := make(map[string]Change)
+		if attempts != nil {
+			changes["failures"] = Change{Before: strconv.Itoa(attempts.Failures), After: "0"}
+			changes["locked_until"] = Change{Before: timestamp(attempts.LockedUntil)}
+		}
+		if clientAttempts != nil {
+			changes["client_failures"] = Change{Before: strconv.Itoa(clientAttempts.Failures), After: "0"}
+			changes["client_locked_until"] = Change{Before: timestamp(clientAttempts.LockedUntil)}
+		}
+
+		return svc.audit(ctx, ActionUnlockAccount, TargetAccount, req.Id, changes)
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	go func(id string) {
-		if pubErr := svc.pub.Publish(event.AccountDeleted, id); pubErr != nil {
-			logrus.Error(pubErr)
-		}
-	}(req.Id)
+	svc.publishAccount(ctx, event.AccountUnlocked, req.Id)
 
-	return &pbUser.DeleteAccountResponse{
+	return &pbUser.UnlockAccountResponse{
 		Success: true,
 	}, nil
 }
 
+// checkCredentials will validate plain password and second factor code (if enabled) against account,
+// with brute-force protection per account and client ip (only if forwarded by authenticated gateway)
+func (svc *accountService) checkCredentials(ctx context.Context, account *Account, plain, code string) error {
+	ip := forwardedIP(ctx)
+
+	if err := svc.guard.allow(ctx, account.Id, ip); err != nil {
+		return err
+	}
+
+	if !svc.pwdHash.Validate(account.Password, plain) {
+		svc.credentialsFailed(ctx, account.Id, ip)
+		return errors.New("invalid credentials")
+	}
+
+	totp, err := svc.repo.GetTOTP(ctx, account.Id)
+	if err != nil {
+		return err
+	}
+
+	if totp != nil && totp.Confirmed {
+		if err = svc.useTOTP(ctx, totp, code, true); err != nil {
+			if errors.Is(err, ErrInvalidTOTP) {
+				svc.credentialsFailed(ctx, account.Id, ip)
+			}
+			return err
+		}
+	}
+
+	svc.upgradePasswordHash(ctx, account, plain)
+
+	return svc.guard.succeeded(ctx, account.Id)
+}
+
+// credentialsFailed will register failed credential check and publish lockout events
+func (svc *accountService) credentialsFailed(ctx context.Context, accountId, ip string) {
+	accountLocked, clientLocked, err := svc.guard.failed(ctx, accountId, ip)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+	}
+
+	if accountLocked {
+		logging.FromContext(ctx).Warnf("account %s locked after failed credential checks", accountId)
+
+		svc.publishAccount(ctx, event.AccountLocked, accountId)
+	}
+
+	if clientLocked {
+		logging.FromContext(ctx).Warnf("client %s locked after failed credential checks", ip)
+
+		svc.publish(ctx, event.ClientLocked, ip)
+	}
+}
+
+// publish will send event in background, so rpc response does not wait for RabbitMQ
+func (svc *accountService) publish(ctx context.Context, ev string, msg interface{}) {
+	// rpc ctx is cancelled once handler returns, event is still published
+	ctx = detach(ctx)
+	svc.publishing.Add(1)
+
+	go func() {
+		defer svc.publishing.Done()
+
+		if err := svc.pub.Publish(ctx, ev, msg); err != nil {
+			logging.FromContext(ctx).Error(err)
+		}
+	}()
+}
+
+// publishAccount will record event in account history and publish it with account id as message
+func (svc *accountService) publishAccount(ctx context.Context, ev, accountId string) {
+	svc.recordEvent(ctx, ev, accountId)
+	svc.publish(ctx, ev, accountId)
+}
+
+// validatePassword will apply password policy to new password
+func (svc *accountService) validatePassword(plain string) error {
+	if svc.pwdChecker != nil && svc.pwdChecker.Breached(plain) {
+		return ErrBreachedPassword
+	}
+
+	return nil
+}
+
+// upgradePasswordHash will rehash password if stored hash uses outdated algorithm or cost parameters,
+// plain password is known only after successful validation
+func (svc *accountService) upgradePasswordHash(ctx context.Context, account *Account, plain string) {
+	if !svc.pwdHash.NeedsRehash(account.Password) {
+		return
+	}
+
+	hashed, err := svc.pwdHash.Hash(plain)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return
+	}
+
+	if err = svc.repo.ChangePassword(ctx, account.Id, hashed); err != nil {
+		logging.FromContext(ctx).Error(err)
+		return
+	}
+
+	account.Password = hashed
+
+	logging.FromContext(ctx).Infof("account %s password rehashed", account.Id)
+}
+
 // GetAccountsByFilter will get user accounts based on given filters
 func (svc *accountService) GetAccountsByFilter(ctx context.Context, req *pbUser.GetAccountsByFilterRequest) (*pbUser.AccountsResponse, error) {
 	accounts, err := svc.repo.GetAccountsByFilter(ctx, &Filter{
-		Page:    int(req.Page),
-		Limit:   int(req.Limit),
-		Country: req.Country,
+		Page:           int(req.Page),
+		Limit:          int(req.Limit),
+		Country:        req.Country,
+		IncludeDeleted: req.IncludeDeleted,
+		OnlyDeleted:    req.OnlyDeleted,
 	})
 	if err != nil {
 		return nil, err
@@ -216,6 +562,8 @@ func userAccountToProto(account *Account) *pbUser.AccountMessage {
 		CreatedAt: account.CreatedAt.String(),
 		UpdatedAt: account.UpdatedAt.String(),
 		DeletedAt: account.DeletedAt.String(),
+		Version:   account.Version,
+		ErasedAt:  account.ErasedAt.String(),
 	}
 }
 
@@ -239,5 +587,6 @@ func protoToUserAccount(pbAccount *pbUser.AccountMessage) *Account {
 		Password:  pbAccount.Password,
 		Email:     pbAccount.Email,
 		Country:   pbAccount.Country,
+		Version:   pbAccount.Version,
 	}
 }
@@ -0,0 +1,155 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/semirm-dev/faceit/internal/logging"
+	pbUser "github.com/semirm-dev/faceit/user/proto"
+	"strings"
+	"time"
+)
+
+// CreateAPIKey will create new api key, plain key is returned only once and never stored
+func (svc *accountService) CreateAPIKey(ctx context.Context, req *pbUser.CreateAPIKeyRequest) (*pbUser.APIKeyMessage, error) {
+	if strings.TrimSpace(req.Name) == "" {
+		return nil, errors.New("api key name is required")
+	}
+	if len(req.Scopes) == 0 {
+		return nil, errors.New("api key requires at least one scope")
+	}
+	for _, scope := range req.Scopes {
+		if !validScope(scope) {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownScope, scope)
+		}
+	}
+
+	if req.AccountId != "" {
+		if (&APIKey{Scopes: req.Scopes}).HasScope(ScopeAdmin) {
+			return nil, errors.New("api key owned by account can't have admin scope")
+		}
+
+		account, err := svc.repo.GetById(ctx, req.AccountId)
+		if err != nil {
+			return nil, err
+		}
+		if account == nil || account.Email == "" {
+			return nil, errors.New("account not found")
+		}
+	}
+
+	plain, hash, err := generateAPIKey()
+	if err != nil {
+		return nil, err
+	}
+
+	key := &APIKey{
+		Name:      req.Name,
+		Hash:      hash,
+		Scopes:    req.Scopes,
+		AccountId: req.AccountId,
+	}
+	if req.TtlSeconds > 0 {
+		key.ExpiresAt = time.Now().UTC().Add(time.Duration(req.TtlSeconds) * time.Second)
+	}
+
+	err = svc.repo.WithinTx(ctx, func(ctx context.Context) error {
+		key, err = svc.repo.AddAPIKey(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		return svc.audit(ctx, ActionCreateAPIKey, TargetAPIKey, key.Id, diff(nil, apiKeyFields(key)))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logging.FromContext(ctx).Infof("api key %s (%s) created", key.Id, key.Name)
+
+	msg := apiKeyToProto(key)
+	msg.Key = plain
+
+	return msg, nil
+}
+
+// RevokeAPIKey will permanently disable api key
+func (svc *accountService) RevokeAPIKey(ctx context.Context, req *pbUser.RevokeAPIKeyRequest) (*pbUser.RevokeAPIKeyResponse, error) {
+	err := svc.repo.WithinTx(ctx, func(ctx context.Context) error {
+		revokedAt := time.Now().UTC()
+
+		if err := svc.repo.RevokeAPIKey(ctx, req.Id, revokedAt); err != nil {
+			return err
+		}
+
+		return svc.audit(ctx, ActionRevokeAPIKey, TargetAPIKey, req.Id, map[string]Change{
+			"revoked_at": {After: timestamp(revokedAt)},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logging.FromContext(ctx).Infof("api key %s revoked", req.Id)
+
+	return &pbUser.RevokeAPIKeyResponse{
+		Success: true,
+	}, nil
+}
+
+// GetAPIKeys will get all api keys, without hashes
+func (svc *accountService) GetAPIKeys(ctx context.Context, req *pbUser.GetAPIKeysRequest) (*pbUser.APIKeysResponse, error) {
+	keys, err := svc.repo.GetAPIKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var msgs []*pbUser.APIKeyMessage
+	for _, key := range keys {
+		msgs = append(msgs, apiKeyToProto(key))
+	}
+
+	return &pbUser.APIKeysResponse{
+		ApiKeys: msgs,
+	}, nil
+}
+
+// ValidateAPIKey will check if api key is active and has required scope (optional), and track its usage
+func (svc *accountService) ValidateAPIKey(ctx context.Context, req *pbUser.ValidateAPIKeyRequest) (*pbUser.APIKeyMessage, error) {
+	key, err := svc.repo.GetAPIKeyByHash(ctx, hashAPIKey(req.Key))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+
+	if key == nil || !key.Active(now) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if req.Scope != "" && !key.HasScope(req.Scope) {
+		return nil, ErrAPIKeyScope
+	}
+
+	if now.Sub(key.LastUsedAt) >= apiKeyTouchInterval {
+		if err = svc.repo.TouchAPIKey(ctx, key.Id, now); err != nil {
+			logging.FromContext(ctx).Error(err)
+		}
+		key.LastUsedAt = now
+	}
+
+	return apiKeyToProto(key), nil
+}
+
+func apiKeyToProto(key *APIKey) *pbUser.APIKeyMessage {
+	return &pbUser.APIKeyMessage{
+		Id:         key.Id,
+		Name:       key.Name,
+		Scopes:     key.Scopes,
+		AccountId:  key.AccountId,
+		ExpiresAt:  key.ExpiresAt.String(),
+		LastUsedAt: key.LastUsedAt.String(),
+		RevokedAt:  key.RevokedAt.String(),
+		CreatedAt:  key.CreatedAt.String(),
+	}
+}
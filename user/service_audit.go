@@ -0,0 +1,81 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	pbUser "github.com/semirm-dev/faceit/user/proto"
+)
+
+var ErrInvalidAuditTime = errors.New("since and until must be RFC 3339 timestamps")
+
+// GetAuditLog will get audit entries matching filter, newest first
+func (svc *accountService) GetAuditLog(ctx context.Context, req *pbUser.GetAuditLogRequest) (*pbUser.AuditLogResponse, error) {
+	filter := &AuditFilter{
+		Page:     int(req.Page),
+		Limit:    int(req.Limit),
+		TargetId: req.TargetId,
+		Actor:    req.Actor,
+		Action:   req.Action,
+	}
+	if filter.Limit > maxAuditLimit {
+		filter.Limit = maxAuditLimit
+	}
+
+	var err error
+	if filter.Since, err = parseAuditTime(req.Since); err != nil {
+		return nil, err
+	}
+	if filter.Until, err = parseAuditTime(req.Until); err != nil {
+		return nil, err
+	}
+
+	entries, err := svc.repo.GetAuditLog(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &pbUser.AuditLogResponse{}
+	for _, entry := range entries {
+		resp.Entries = append(resp.Entries, auditEntryToProto(entry))
+	}
+
+	return resp, nil
+}
+
+func parseAuditTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, ErrInvalidAuditTime
+	}
+
+	return t.UTC(), nil
+}
+
+func auditEntryToProto(entry *AuditEntry) *pbUser.AuditEntryMessage {
+	msg := &pbUser.AuditEntryMessage{
+		Id:         entry.Id,
+		Actor:      entry.Actor,
+		Action:     entry.Action,
+		TargetType: entry.TargetType,
+		TargetId:   entry.TargetId,
+		RequestId:  entry.RequestId,
+		ClientIp:   entry.ClientIP,
+		CreatedAt:  timestamp(entry.CreatedAt),
+	}
+
+	for _, field := range sortedFields(entry.Changes) {
+		msg.Changes = append(msg.Changes, &pbUser.AuditChange{
+			Field:  field,
+			Before: entry.Changes[field].Before,
+			After:  entry.Changes[field].After,
+		})
+	}
+
+	return msg
+}
@@ -0,0 +1,279 @@
+package user
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/semirm-dev/faceit/event"
+	"github.com/semirm-dev/faceit/internal/logging"
+	pbUser "github.com/semirm-dev/faceit/user/proto"
+)
+
+// ExportAccountData will start generating archive with account data, it's ready when account_export_ready event is
+// published and can be downloaded with GetAccountExport until it expires
+func (svc *accountService) ExportAccountData(ctx context.Context, req *pbUser.ExportAccountDataRequest) (*pbUser.AccountExportMessage, error) {
+	account, err := svc.repo.GetById(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+	if account == nil || account.Email == "" {
+		return nil, errors.New("account not found")
+	}
+
+	now := time.Now().UTC()
+
+	var export *AccountExport
+	err = svc.repo.WithinTx(ctx, func(ctx context.Context) error {
+		export, err = svc.repo.AddExport(ctx, &AccountExport{
+			AccountId: account.Id,
+			Status:    ExportPending,
+			CreatedAt: now,
+			ExpiresAt: now.Add(svc.exports.Expiry),
+		})
+		if err != nil {
+			return err
+		}
+
+		return svc.audit(ctx, ActionExportAccountData, TargetAccount, account.Id, map[string]Change{
+			"export": {After: export.Id},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logging.FromContext(ctx).Infof("export %s of account %s started", export.Id, account.Id)
+
+	// export outlives rpc, so it must not be cancelled together with rpc ctx
+	exportCtx, cancel := context.WithTimeout(detach(ctx), svc.exports.Timeout)
+	pending := *export
+
+	svc.exporting.Add(1)
+	go func() {
+		defer svc.exporting.Done()
+		defer cancel()
+
+		svc.generateExport(exportCtx, &pending)
+	}()
+
+	return exportToProto(export), nil
+}
+
+// GetAccountExport will get export status, archive is included once it's ready
+func (svc *accountService) GetAccountExport(ctx context.Context, req *pbUser.GetAccountExportRequest) (*pbUser.AccountExportMessage, error) {
+	export, err := svc.repo.GetExport(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+	if export == nil || (req.AccountId != "" && export.AccountId != req.AccountId) || !export.ExpiresAt.After(time.Now().UTC()) {
+		return nil, ErrExportNotFound
+	}
+
+	msg := exportToProto(export)
+	if export.Status == ExportReady {
+		msg.Archive = export.Archive
+	}
+
+	return msg, nil
+}
+
+// generateExport will build archive and store it, expired exports are removed on the way
+func (svc *accountService) generateExport(ctx context.Context, export *AccountExport) {
+	if err := svc.repo.DeleteExpiredExports(ctx, time.Now().UTC()); err != nil {
+		logging.FromContext(ctx).Error("failed to delete expired exports: ", err)
+	}
+
+	archive, err := svc.exportArchive(ctx, export.AccountId)
+	if err == nil && len(archive) > MaxExportSize {
+		err = ErrExportTooLarge
+	}
+	if err != nil {
+		logging.FromContext(ctx).Errorf("export %s of account %s failed: %s", export.Id, export.AccountId, err)
+		export.Status = ExportFailed
+		export.Error = err.Error()
+	} else {
+		export.Status = ExportReady
+		export.Archive = archive
+	}
+	export.CompletedAt = time.Now().UTC()
+
+	err = svc.repo.WithinTx(ctx, func(ctx context.Context) error {
+		// account erased or purged while archive was generated has its exports removed, archive must not be stored
+		account, err := svc.repo.GetById(ctx, export.AccountId)
+		if err != nil {
+			return err
+		}
+		if account == nil || !account.ErasedAt.IsZero() {
+			return ErrExportNotFound
+		}
+
+		return svc.repo.SaveExport(ctx, export)
+	})
+	if errors.Is(err, ErrExportNotFound) {
+		logging.FromContext(ctx).Warnf("export %s of account %s was removed before it was saved", export.Id, export.AccountId)
+		return
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to save export: ", err)
+		return
+	}
+
+	if export.Status == ExportReady {
+		svc.recordEvent(ctx, event.AccountExportReady, export.AccountId)
+		svc.publish(ctx, event.AccountExportReady, export.Id)
+	}
+}
+
+// exportArchive will collect account data into zip with one json file per kind of data
+func (svc *accountService) exportArchive(ctx context.Context, accountId string) ([]byte, error) {
+	account, err := svc.repo.GetById(ctx, accountId)
+	if err != nil {
+		return nil, err
+	}
+	if account == nil || account.Email == "" {
+		return nil, errors.New("account not found")
+	}
+
+	totp, err := svc.repo.GetTOTP(ctx, accountId)
+	if err != nil {
+		return nil, err
+	}
+
+	attempts, err := svc.repo.GetLoginAttempts(ctx, accountKey(accountId))
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := svc.repo.GetAccountEvents(ctx, accountId)
+	if err != nil {
+		return nil, err
+	}
+
+	audit, err := svc.accountAuditLog(ctx, accountId)
+	if err != nil {
+		return nil, err
+	}
+
+	security := &exportSecurity{}
+	if totp != nil {
+		security.TwoFactor = &exportTwoFactor{
+			Enabled:           totp.Confirmed,
+			EnrolledAt:        timestamp(totp.CreatedAt),
+			RecoveryCodesLeft: len(totp.RecoveryCodes),
+		}
+	}
+	if attempts != nil {
+		security.LoginAttempts = &exportLoginAttempts{
+			Failures:     attempts.Failures,
+			LastFailedAt: timestamp(attempts.LastFailedAt),
+			LockedUntil:  timestamp(attempts.LockedUntil),
+		}
+	}
+
+	events := make([]*exportEvent, 0, len(history))
+	for _, ev := range history {
+		events = append(events, &exportEvent{
+			Event:     ev.Event,
+			RequestId: ev.RequestId,
+			CreatedAt: timestamp(ev.CreatedAt),
+		})
+	}
+
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+
+	files := []struct {
+		name    string
+		content interface{}
+	}{
+		{"account.json", &exportProfile{
+			Id:        account.Id,
+			FirstName: account.FirstName,
+			LastName:  account.LastName,
+			Nickname:  account.Nickname,
+			Email:     account.Email,
+			Country:   account.Country,
+			CreatedAt: timestamp(account.CreatedAt),
+			UpdatedAt: timestamp(account.UpdatedAt),
+			Version:   account.Version,
+		}},
+		{"security.json", security},
+		{"events.json", events},
+		{"audit.json", audit},
+	}
+
+	for _, file := range files {
+		if err = writeJSON(zw, file.name, file.content); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// accountAuditLog returns all audit entries of account, newest first. Client ip of other actors (e.g. admins)
+// is their personal data, only ip of changes made with keys owned by account is exported
+func (svc *accountService) accountAuditLog(ctx context.Context, accountId string) ([]*exportAuditEntry, error) {
+	keys, err := svc.repo.GetAPIKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	owned := make(map[string]bool)
+	for _, key := range keys {
+		if key.AccountId == accountId {
+			owned[APIKeyActor(key.Id)] = true
+		}
+	}
+
+	audit := make([]*exportAuditEntry, 0)
+
+	for page := 1; ; page++ {
+		entries, err := svc.repo.GetAuditLog(ctx, &AuditFilter{
+			Page:     page,
+			Limit:    maxAuditLimit,
+			TargetId: accountId,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			exported := &exportAuditEntry{
+				Action:    entry.Action,
+				Actor:     entry.Actor,
+				Changes:   entry.Changes,
+				RequestId: entry.RequestId,
+				CreatedAt: timestamp(entry.CreatedAt),
+			}
+			if owned[entry.Actor] {
+				exported.ClientIP = entry.ClientIP
+			}
+
+			audit = append(audit, exported)
+		}
+
+		if len(entries) < maxAuditLimit {
+			return audit, nil
+		}
+	}
+}
+
+func exportToProto(export *AccountExport) *pbUser.AccountExportMessage {
+	return &pbUser.AccountExportMessage{
+		Id:          export.Id,
+		AccountId:   export.AccountId,
+		Status:      export.Status,
+		Error:       export.Error,
+		CreatedAt:   timestamp(export.CreatedAt),
+		CompletedAt: timestamp(export.CompletedAt),
+		ExpiresAt:   timestamp(export.ExpiresAt),
+	}
+}
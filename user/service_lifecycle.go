@@ -0,0 +1,255 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/semirm-dev/faceit/event"
+	"github.com/semirm-dev/faceit/internal/logging"
+	pbUser "github.com/semirm-dev/faceit/user/proto"
+)
+
+var (
+	ErrDeletedAccountNotFound = errors.New("deleted account not found")
+	ErrAccountErased          = errors.New("account is erased")
+)
+
+// PurgePolicy defines when soft deleted accounts are permanently removed
+type PurgePolicy struct {
+	// Retention is how long soft deleted accounts are kept, 0 disables scheduled purge
+	Retention time.Duration
+	// Interval between scheduled purges
+	Interval time.Duration
+	// BatchSize is max number of accounts purged in one transaction
+	BatchSize int
+}
+
+// NewPurgePolicy will initialize default purge policy
+func NewPurgePolicy() *PurgePolicy {
+	return &PurgePolicy{
+		Retention: 30 * 24 * time.Hour,
+		Interval:  time.Hour,
+		BatchSize: 100,
+	}
+}
+
+// RestoreAccount will restore soft deleted account, unless its email was taken in the meantime
+func (svc *accountService) RestoreAccount(ctx context.Context, req *pbUser.RestoreAccountRequest) (*pbUser.AccountMessage, error) {
+	var account *Account
+
+	err := svc.repo.WithinTx(ctx, func(ctx context.Context) error {
+		deleted, err := svc.repo.GetDeletedById(ctx, req.Id)
+		if err != nil {
+			return err
+		}
+		if deleted == nil {
+			return ErrDeletedAccountNotFound
+		}
+		if !deleted.ErasedAt.IsZero() {
+			return ErrAccountErased
+		}
+
+		existing, err := svc.repo.GetByEmail(ctx, deleted.Email)
+		if err != nil {
+			return err
+		}
+		if existing != nil && existing.Email == deleted.Email {
+			return errors.New("email already exists")
+		}
+
+		before := accountFields(deleted)
+
+		if err = svc.repo.RestoreAccount(ctx, req.Id); err != nil {
+			return err
+		}
+
+		account, err = svc.repo.GetById(ctx, req.Id)
+		if err != nil {
+			return err
+		}
+
+		return svc.audit(ctx, ActionRestoreAccount, TargetAccount, req.Id, diff(before, accountFields(account)))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	svc.publishAccount(ctx, event.AccountRestored, account.Id)
+
+	return userAccountToProto(account), nil
+}
+
+// PurgeAccount will permanently remove soft deleted account, together with its second factor, login attempts and exports
+func (svc *accountService) PurgeAccount(ctx context.Context, req *pbUser.PurgeAccountRequest) (*pbUser.PurgeAccountResponse, error) {
+	err := svc.repo.WithinTx(ctx, func(ctx context.Context) error {
+		deleted, err := svc.repo.GetDeletedById(ctx, req.Id)
+		if err != nil {
+			return err
+		}
+		if deleted == nil {
+			return ErrDeletedAccountNotFound
+		}
+		if err = svc.repo.PurgeAccount(ctx, req.Id); err != nil {
+			return err
+		}
+
+		if err = svc.purgeRelated(ctx, req.Id); err != nil {
+			return err
+		}
+
+		return svc.audit(ctx, ActionPurgeAccount, TargetAccount, req.Id, purgeChanges(deleted))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	svc.publishAccount(ctx, event.AccountPurged, req.Id)
+
+	return &pbUser.PurgeAccountResponse{
+		Success: true,
+	}, nil
+}
+
+// EraseAccount will irreversibly replace personal data of account with placeholders, while its id stays valid.
+// Account is soft deleted if it's not already, and is purged as any other deleted account.
+func (svc *accountService) EraseAccount(ctx context.Context, req *pbUser.EraseAccountRequest) (*pbUser.EraseAccountResponse, error) {
+	err := svc.repo.WithinTx(ctx, func(ctx context.Context) error {
+		account, err := svc.repo.GetById(ctx, req.Id)
+		if err != nil {
+			return err
+		}
+		if account == nil || account.Email == "" {
+			if account, err = svc.repo.GetDeletedById(ctx, req.Id); err != nil {
+				return err
+			}
+		}
+		if account == nil || account.Email == "" {
+			return errors.New("account not found")
+		}
+		if !account.ErasedAt.IsZero() {
+			return ErrAccountErased
+		}
+		before := accountFields(account)
+
+		if err = svc.repo.EraseAccount(ctx, req.Id, erasedAccount(req.Id, time.Now().UTC())); err != nil {
+			return err
+		}
+
+		if err = svc.purgeRelated(ctx, req.Id); err != nil {
+			return err
+		}
+
+		erased, err := svc.repo.GetDeletedById(ctx, req.Id)
+		if err != nil {
+			return err
+		}
+
+		return svc.audit(ctx, ActionEraseAccount, TargetAccount, req.Id, redactPersonal(diff(before, accountFields(erased))))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logging.FromContext(ctx).Infof("account %s erased", req.Id)
+
+	svc.publishAccount(ctx, event.AccountErased, req.Id)
+
+	return &pbUser.EraseAccountResponse{
+		Success: true,
+	}, nil
+}
+
+// PurgeDeleted will periodically purge accounts soft deleted longer than retention period in background,
+// until ctx is done, Close waits for it to stop
+func (svc *accountService) PurgeDeleted(ctx context.Context) {
+	if svc.purge == nil || svc.purge.Retention <= 0 {
+		return
+	}
+
+	svc.purging.Add(1)
+
+	go func() {
+		defer svc.purging.Done()
+
+		ticker := time.NewTicker(svc.purge.Interval)
+		defer ticker.Stop()
+
+		for {
+			svc.purgeDeleted(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// purgeDeleted will purge expired accounts in batches, replicas running it at the same time skip each other's batches
+func (svc *accountService) purgeDeleted(ctx context.Context) {
+	ctx = WithActor(ctx, ActorSystem)
+	deletedBefore := time.Now().UTC().Add(-svc.purge.Retention)
+
+	for ctx.Err() == nil {
+		var purged []string
+
+		err := svc.repo.WithinTx(ctx, func(ctx context.Context) error {
+			accounts, err := svc.repo.PurgeDeleted(ctx, deletedBefore, svc.purge.BatchSize)
+			if err != nil {
+				return err
+			}
+
+			purged = purged[:0]
+			for _, account := range accounts {
+				if err = svc.purgeRelated(ctx, account.Id); err != nil {
+					return err
+				}
+
+				if err = svc.audit(ctx, ActionPurgeAccount, TargetAccount, account.Id, purgeChanges(account)); err != nil {
+					return err
+				}
+
+				purged = append(purged, account.Id)
+			}
+
+			return nil
+		})
+		if err != nil {
+			logging.FromContext(ctx).Error("failed to purge deleted accounts: ", err)
+			return
+		}
+
+		// purge is committed, its events are recorded and published even if ctx is done meanwhile
+		for _, id := range purged {
+			svc.publishAccount(detach(ctx), event.AccountPurged, id)
+		}
+
+		if len(purged) > 0 {
+			logging.FromContext(ctx).Infof("%d accounts deleted before %s purged", len(purged), deletedBefore.Format(time.RFC3339))
+		}
+
+		if len(purged) < svc.purge.BatchSize {
+			return
+		}
+	}
+}
+
+// purgeRelated will remove data kept for account outside of accounts table and redact personal data from its audit log,
+// event history holds no personal data
+func (svc *accountService) purgeRelated(ctx context.Context, id string) error {
+	if err := svc.repo.RedactAuditLog(ctx, id, personalFields); err != nil {
+		return err
+	}
+
+	if err := svc.repo.DeleteTOTP(ctx, id); err != nil {
+		return err
+	}
+
+	if err := svc.repo.DeleteExports(ctx, id); err != nil {
+		return err
+	}
+
+	return svc.repo.ResetLoginAttempts(ctx, accountKey(id))
+}
@@ -1,61 +1,146 @@
 package user_test
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
+	"fmt"
+	internalGrpc "github.com/semirm-dev/faceit/internal/grpc"
 	"github.com/semirm-dev/faceit/user"
 	pbUser "github.com/semirm-dev/faceit/user/proto"
 	"github.com/semirm-dev/faceit/user/repository"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"io"
 	"net"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
 const (
-	bufSize = 1024 * 1024
-	addr    = "8001"
+	bufSize      = 1024 * 1024
+	addr         = "8001"
+	gatewayToken = "gateway-token"
 )
 
 var (
-	lis       *bufconn.Listener
-	repo      = repository.NewAccountInmemory()
-	publisher = &mockPublisher{events: make(map[string]interface{})}
+	lis        *bufconn.Listener
+	gatewayLis *bufconn.Listener
+	repo       = repository.NewAccountInmemory()
+	publisher  = newMockPublisher()
+	pwdHash    = &mockPwdHash{}
 )
 
 func init() {
 	lis = bufconn.Listen(bufSize)
 	srv := grpc.NewServer()
 
-	pbUser.RegisterAccountManagementServer(srv, user.NewAccountService(
-		addr,
+	// gatewaySrv authenticates its clients, so metadata they forward is trusted
+	gatewayLis = bufconn.Listen(bufSize)
+	serverOpts := internalGrpc.NewServerOptions()
+	serverOpts.Auth = internalGrpc.TokenAuth(gatewayToken)
+	gatewaySrv := internalGrpc.NewServer(serverOpts)
+
+	conf := user.NewConfig()
+	conf.Addr = addr
+	conf.Lockout.MaxAccountFailures = 3
+	conf.Lockout.BaseDelay = 0
+	conf.PasswordChecker = &mockPwdChecker{breached: "password"}
+	conf.TOTPKey = "0123456789abcdef0123456789abcdef"
+
+	svc := user.NewAccountService(
+		conf,
 		repo,
 		publisher,
-		&mockPwdHash{}))
+		pwdHash)
+
+	pbUser.RegisterAccountManagementServer(srv, svc)
+	pbUser.RegisterAccountManagementServer(gatewaySrv, svc)
 
 	go func() {
 		if err := srv.Serve(lis); err != nil {
 			logrus.Fatalf("grpc server failed: %v", err)
 		}
 	}()
+
+	go func() {
+		if err := gatewaySrv.Serve(gatewayLis); err != nil {
+			logrus.Fatalf("grpc server failed: %v", err)
+		}
+	}()
 }
 
+// mockPublisher is safe for concurrent use, account service publishes events in background
 type mockPublisher struct {
-	events map[string]interface{}
+	mu       sync.Mutex
+	events   map[string]interface{}
+	contexts map[string]context.Context
+}
+
+func newMockPublisher() *mockPublisher {
+	return &mockPublisher{
+		events:   make(map[string]interface{}),
+		contexts: make(map[string]context.Context),
+	}
 }
 
-func (pub *mockPublisher) Publish(event string, msg interface{}) error {
+func (pub *mockPublisher) Publish(ctx context.Context, event string, msg interface{}) error {
+	pub.mu.Lock()
+	defer pub.mu.Unlock()
+
 	pub.events[event] = msg
+	pub.contexts[event] = ctx
 	return nil
 }
 
-type mockPwdHash struct{}
+func (pub *mockPublisher) published(event string) interface{} {
+	pub.mu.Lock()
+	defer pub.mu.Unlock()
+
+	return pub.events[event]
+}
+
+func (pub *mockPublisher) reset() {
+	pub.mu.Lock()
+	defer pub.mu.Unlock()
+
+	pub.events = make(map[string]interface{})
+	pub.contexts = make(map[string]context.Context)
+}
+
+// context returns ctx event was published with
+func (pub *mockPublisher) context(event string) context.Context {
+	pub.mu.Lock()
+	defer pub.mu.Unlock()
+
+	return pub.contexts[event]
+}
+
+// eventually waits for event to be published in background
+func (pub *mockPublisher) eventually(t *testing.T, event string) {
+	assert.Eventually(t, func() bool {
+		return pub.published(event) != nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+type mockPwdHash struct {
+	// onHash is called while password is hashed
+	onHash func()
+}
 
 func (pwdHash *mockPwdHash) Hash(plain string) (string, error) {
+	if pwdHash.onHash != nil {
+		pwdHash.onHash()
+	}
 	return plain + "-hashed", nil
 }
 func (pwdHash *mockPwdHash) Validate(hashed, plain string) bool {
@@ -63,6 +148,17 @@ func (pwdHash *mockPwdHash) Validate(hashed, plain string) bool {
 
 	return h == plain
 }
+func (pwdHash *mockPwdHash) NeedsRehash(hashed string) bool {
+	return false
+}
+
+type mockPwdChecker struct {
+	breached string
+}
+
+func (checker *mockPwdChecker) Breached(plain string) bool {
+	return plain == checker.breached
+}
 
 func bufDialer(context.Context, string) (net.Conn, error) {
 	return lis.Dial()
@@ -88,10 +184,40 @@ func grpcClient() pbUser.AccountManagementClient {
 	return pbUser.NewAccountManagementClient(conn)
 }
 
+// bearerToken authenticates client as gateway
+type bearerToken string
+
+func (token bearerToken) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(token)}, nil
+}
+
+func (token bearerToken) RequireTransportSecurity() bool {
+	return false
+}
+
+// gatewayClient is authenticated with bearer token, as gateway is
+func gatewayClient() pbUser.AccountManagementClient {
+	ctx := context.Background()
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return gatewayLis.Dial()
+		}),
+		grpc.WithPerRPCCredentials(bearerToken(gatewayToken)),
+	}
+
+	conn, err := grpc.DialContext(ctx, addr, opts...)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	return pbUser.NewAccountManagementClient(conn)
+}
+
 func TestAccountService_AddAccount_Valid_Returns_Success(t *testing.T) {
 	// given
 	repo.Accounts = nil
-	publisher.events = make(map[string]interface{})
+	publisher.reset()
 
 	rpcClient := grpcClient()
 	rootCtx, rootCancel := context.WithCancel(context.Background())
@@ -117,8 +243,27 @@ func TestAccountService_AddAccount_Valid_Returns_Success(t *testing.T) {
 	assert.NotNil(t, resp.CreatedAt)
 	assert.Equal(t, 1, len(repo.Accounts))
 
-	publishedMsg := publisher.events["account_created"]
-	assert.NotNil(t, publishedMsg)
+	publisher.eventually(t, "account_created")
+}
+
+func TestAccountService_Publish_OutlivesRequestContext(t *testing.T) {
+	repo.Accounts = nil
+	publisher.reset()
+
+	rpcClient := grpcClient()
+	rootCtx, rootCancel := context.WithCancel(context.Background())
+	defer rootCancel()
+
+	_, err := rpcClient.AddAccount(rootCtx, &pbUser.AccountRequest{
+		Password: "pwd123",
+		Email:    "user1@mail.com",
+	})
+	assert.Nil(t, err)
+
+	publisher.eventually(t, "account_created")
+
+	// rpc ctx is cancelled by now, event must not be published with it
+	assert.Nil(t, publisher.context("account_created").Err())
 }
 
 func TestAccountService_AddAccount_ExistingEmail_Returns_Fail(t *testing.T) {
@@ -137,7 +282,7 @@ func TestAccountService_AddAccount_ExistingEmail_Returns_Fail(t *testing.T) {
 			DeletedAt: time.Time{},
 		},
 	}
-	publisher.events = make(map[string]interface{})
+	publisher.reset()
 
 	rpcClient := grpcClient()
 	rootCtx, rootCancel := context.WithCancel(context.Background())
@@ -160,8 +305,33 @@ func TestAccountService_AddAccount_ExistingEmail_Returns_Fail(t *testing.T) {
 	assert.Nil(t, resp)
 	assert.Contains(t, err.Error(), "email already exists")
 
-	publishedMsg := publisher.events["account_created"]
-	assert.Nil(t, publishedMsg)
+	assert.Nil(t, publisher.published("account_created"))
+}
+
+func TestAccountService_AddAccount_BreachedPassword_Returns_Fail(t *testing.T) {
+	repo.Accounts = nil
+	publisher.reset()
+
+	rpcClient := grpcClient()
+	rootCtx, rootCancel := context.WithCancel(context.Background())
+	defer rootCancel()
+
+	accountReq := &pbUser.AccountRequest{
+		FirstName: "user 1",
+		LastName:  "user 1",
+		Nickname:  "user_1",
+		Password:  "password",
+		Email:     "user1@mail.com",
+		Country:   "country1",
+	}
+
+	resp, err := rpcClient.AddAccount(rootCtx, accountReq)
+
+	assert.NotNil(t, err)
+	assert.Nil(t, resp)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Contains(t, err.Error(), "appeared in a data breach")
+	assert.Equal(t, 0, len(repo.Accounts))
 }
 
 func TestAccountService_ModifyAccount_Valid_Returns_Success(t *testing.T) {
@@ -179,7 +349,7 @@ func TestAccountService_ModifyAccount_Valid_Returns_Success(t *testing.T) {
 			DeletedAt: time.Time{},
 		},
 	}
-	publisher.events = make(map[string]interface{})
+	publisher.reset()
 
 	rpcClient := grpcClient()
 	rootCtx, rootCancel := context.WithCancel(context.Background())
@@ -202,8 +372,88 @@ func TestAccountService_ModifyAccount_Valid_Returns_Success(t *testing.T) {
 	assert.Equal(t, "pwd123", resp.Password)      // shouldnt be changed
 	assert.Equal(t, "user1@mail.com", resp.Email) // shouldnt be changed
 
-	publishedMsg := publisher.events["account_modified"]
-	assert.NotNil(t, publishedMsg)
+	publisher.eventually(t, "account_modified")
+}
+
+func TestAccountService_ModifyAccount_StaleVersion_Returns_Aborted(t *testing.T) {
+	repo.Accounts = []*user.Account{
+		{
+			Id:        "123",
+			FirstName: "user 1",
+			LastName:  "user 1",
+			Nickname:  "user_1",
+			Password:  "pwd123",
+			Email:     "user1@mail.com",
+			Country:   "country1",
+			Version:   2,
+		},
+	}
+	publisher.reset()
+
+	rpcClient := grpcClient()
+	rootCtx, rootCancel := context.WithCancel(context.Background())
+	defer rootCancel()
+
+	accountReq := &pbUser.AccountMessage{
+		Id:        "123",
+		FirstName: "user 1 changed",
+		Country:   "country1",
+		Version:   1,
+	}
+
+	resp, err := rpcClient.ModifyAccount(rootCtx, accountReq)
+
+	assert.Nil(t, resp)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	assert.Equal(t, "user 1", repo.Accounts[0].FirstName)
+	assert.Nil(t, publisher.published("account_modified"))
+
+	accountReq.Version = 2
+	resp, err = rpcClient.ModifyAccount(rootCtx, accountReq)
+
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), resp.Version)
+}
+
+func TestAccountService_ModifyAccount_UpdateMask_Updates_OnlyNamedFields(t *testing.T) {
+	repo.Accounts = []*user.Account{
+		{
+			Id:        "123",
+			FirstName: "user 1",
+			LastName:  "user 1",
+			Nickname:  "user_1",
+			Password:  "pwd123",
+			Email:     "user1@mail.com",
+			Country:   "country1",
+		},
+	}
+	publisher.reset()
+
+	rpcClient := grpcClient()
+	rootCtx, rootCancel := context.WithCancel(context.Background())
+	defer rootCancel()
+
+	accountReq := &pbUser.AccountMessage{
+		Id:         "123",
+		Nickname:   "user_1 changed",
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"nickname"}},
+	}
+
+	resp, err := rpcClient.ModifyAccount(rootCtx, accountReq)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "user_1 changed", resp.Nickname)
+	assert.Equal(t, "user 1", resp.FirstName)
+	assert.Equal(t, "user 1", resp.LastName)
+	assert.Equal(t, "country1", resp.Country)
+	publisher.eventually(t, "account_modified")
+
+	accountReq.UpdateMask.Paths = []string{"email"}
+	resp, err = rpcClient.ModifyAccount(rootCtx, accountReq)
+
+	assert.Nil(t, resp)
+	assert.Contains(t, err.Error(), "field email can not be modified")
+	assert.Equal(t, "user1@mail.com", repo.Accounts[0].Email)
 }
 
 func TestAccountService_ModifyAccount_NoAccount_Returns_Fail(t *testing.T) {
@@ -221,7 +471,7 @@ func TestAccountService_ModifyAccount_NoAccount_Returns_Fail(t *testing.T) {
 			DeletedAt: time.Time{},
 		},
 	}
-	publisher.events = make(map[string]interface{})
+	publisher.reset()
 
 	rpcClient := grpcClient()
 	rootCtx, rootCancel := context.WithCancel(context.Background())
@@ -243,8 +493,7 @@ func TestAccountService_ModifyAccount_NoAccount_Returns_Fail(t *testing.T) {
 	assert.Nil(t, resp)
 	assert.Contains(t, err.Error(), "account not found")
 
-	publishedMsg := publisher.events["account_modified"]
-	assert.Nil(t, publishedMsg)
+	assert.Nil(t, publisher.published("account_modified"))
 }
 
 func TestAccountService_ChangePassword_ValidPassword_Returns_Success(t *testing.T) {
@@ -262,7 +511,7 @@ func TestAccountService_ChangePassword_ValidPassword_Returns_Success(t *testing.
 			DeletedAt: time.Time{},
 		},
 	}
-	publisher.events = make(map[string]interface{})
+	publisher.reset()
 
 	rpcClient := grpcClient()
 	rootCtx, rootCancel := context.WithCancel(context.Background())
@@ -279,8 +528,7 @@ func TestAccountService_ChangePassword_ValidPassword_Returns_Success(t *testing.
 	assert.Nil(t, err)
 	assert.True(t, resp.Success)
 
-	publishedMsg := publisher.events["account_modified"]
-	assert.NotNil(t, publishedMsg)
+	publisher.eventually(t, "account_modified")
 }
 
 func TestAccountService_ChangePassword_InvalidPassword_Returns_Fail(t *testing.T) {
@@ -298,7 +546,7 @@ func TestAccountService_ChangePassword_InvalidPassword_Returns_Fail(t *testing.T
 			DeletedAt: time.Time{},
 		},
 	}
-	publisher.events = make(map[string]interface{})
+	publisher.reset()
 
 	rpcClient := grpcClient()
 	rootCtx, rootCancel := context.WithCancel(context.Background())
@@ -319,7 +567,7 @@ func TestAccountService_ChangePassword_InvalidPassword_Returns_Fail(t *testing.T
 
 func TestAccountService_ChangePassword_NoAccount_Returns_Fail(t *testing.T) {
 	repo.Accounts = nil
-	publisher.events = make(map[string]interface{})
+	publisher.reset()
 
 	rpcClient := grpcClient()
 	rootCtx, rootCancel := context.WithCancel(context.Background())
@@ -338,7 +586,44 @@ func TestAccountService_ChangePassword_NoAccount_Returns_Fail(t *testing.T) {
 	assert.Contains(t, err.Error(), "account not found")
 }
 
-func TestAccountService_DeleteAccount(t *testing.T) {
+func TestAccountService_ChangePassword_ChangedDuringHashing_Returns_Aborted(t *testing.T) {
+	repo.Accounts = []*user.Account{
+		{
+			Id:       "123",
+			Password: "pwd123",
+			Email:    "user1@mail.com",
+			Version:  1,
+		},
+	}
+	repo.Attempts = nil
+	repo.TOTPs = nil
+	publisher.reset()
+
+	// concurrent request changes account after version was checked, while new password is hashed
+	pwdHash.onHash = func() {
+		repo.Accounts[0].Version = 2
+	}
+	defer func() {
+		pwdHash.onHash = nil
+	}()
+
+	rpcClient := grpcClient()
+	rootCtx, rootCancel := context.WithCancel(context.Background())
+	defer rootCancel()
+
+	resp, err := rpcClient.ChangePassword(rootCtx, &pbUser.ChangePasswordRequest{
+		Id:          "123",
+		OldPassword: "pwd123",
+		NewPassword: "pwd12345",
+		Version:     1,
+	})
+
+	assert.Nil(t, resp)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	assert.Equal(t, "pwd123", repo.Accounts[0].Password)
+}
+
+func TestAccountService_ChangePassword_TooManyFailures_LocksAccount(t *testing.T) {
 	repo.Accounts = []*user.Account{
 		{
 			Id:        "123",
@@ -353,49 +638,42 @@ func TestAccountService_DeleteAccount(t *testing.T) {
 			DeletedAt: time.Time{},
 		},
 	}
-	publisher.events = make(map[string]interface{})
+	repo.Attempts = nil
+	publisher.reset()
 
 	rpcClient := grpcClient()
 	rootCtx, rootCancel := context.WithCancel(context.Background())
 	defer rootCancel()
 
-	accountReq := &pbUser.DeleteAccountRequest{
-		Id: "123",
+	invalidReq := &pbUser.ChangePasswordRequest{
+		Id:          "123",
+		OldPassword: "invalid",
+		NewPassword: "pwd12345",
 	}
 
-	resp, err := rpcClient.DeleteAccount(rootCtx, accountReq)
-
-	assert.Nil(t, err)
-	assert.True(t, resp.Success)
-	assert.Equal(t, 0, len(repo.Accounts))
-
-	publishedMsg := publisher.events["account_deleted"]
-	assert.NotNil(t, publishedMsg)
-}
-
-func TestAccountService_DeleteAccount_NoAccount_Returns_Fail(t *testing.T) {
-	repo.Accounts = nil
-	publisher.events = make(map[string]interface{})
-
-	rpcClient := grpcClient()
-	rootCtx, rootCancel := context.WithCancel(context.Background())
-	defer rootCancel()
-
-	accountReq := &pbUser.DeleteAccountRequest{
-		Id: "123",
+	for i := 0; i < 3; i++ {
+		_, err := rpcClient.ChangePassword(rootCtx, invalidReq)
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "invalid credentials")
 	}
 
-	resp, err := rpcClient.DeleteAccount(rootCtx, accountReq)
+	resp, err := rpcClient.ChangePassword(rootCtx, &pbUser.ChangePasswordRequest{
+		Id:          "123",
+		OldPassword: "pwd123",
+		NewPassword: "pwd12345",
+	})
 
 	assert.NotNil(t, err)
 	assert.Nil(t, resp)
-	assert.Contains(t, err.Error(), "account not found")
+	assert.Contains(t, err.Error(), "account temporarily locked")
+
+	publisher.eventually(t, "account_locked")
 }
 
-func TestAccountService_GetAccountsByFilter(t *testing.T) {
+func TestAccountService_UnlockAccount_Returns_Success(t *testing.T) {
 	repo.Accounts = []*user.Account{
 		{
-			Id:        "1",
+			Id:        "123",
 			FirstName: "user 1",
 			LastName:  "user 1",
 			Nickname:  "user_1",
@@ -406,37 +684,932 @@ func TestAccountService_GetAccountsByFilter(t *testing.T) {
 			UpdatedAt: time.Time{},
 			DeletedAt: time.Time{},
 		},
+	}
+	repo.Attempts = map[string]*user.LoginAttempts{
+		"account:123": {
+			Key:          "account:123",
+			Failures:     3,
+			LastFailedAt: time.Now().UTC(),
+			LockedUntil:  time.Now().UTC().Add(time.Hour),
+		},
+	}
+	publisher.reset()
+
+	rpcClient := grpcClient()
+	rootCtx, rootCancel := context.WithCancel(context.Background())
+	defer rootCancel()
+
+	resp, err := rpcClient.UnlockAccount(rootCtx, &pbUser.UnlockAccountRequest{
+		Id: "123",
+	})
+
+	assert.Nil(t, err)
+	assert.True(t, resp.Success)
+
+	pwdResp, err := rpcClient.ChangePassword(rootCtx, &pbUser.ChangePasswordRequest{
+		Id:          "123",
+		OldPassword: "pwd123",
+		NewPassword: "pwd12345",
+	})
+
+	assert.Nil(t, err)
+	assert.True(t, pwdResp.Success)
+}
+
+func TestAccountService_UnlockAccount_Removes_ClientLockout(t *testing.T) {
+	repo.Accounts = []*user.Account{
 		{
-			Id:        "2",
-			FirstName: "user 2",
-			LastName:  "user 2",
-			Nickname:  "user_2",
-			Password:  "pwd123",
-			Email:     "user2@mail.com",
-			Country:   "country2",
-			CreatedAt: time.Time{},
-			UpdatedAt: time.Time{},
-			DeletedAt: time.Time{},
+			Id:       "123",
+			Password: "pwd123",
+			Email:    "user1@mail.com",
+		},
+	}
+	repo.Attempts = map[string]*user.LoginAttempts{
+		"client:10.0.0.1": {
+			Key:          "client:10.0.0.1",
+			Failures:     20,
+			LastFailedAt: time.Now().UTC(),
+			LockedUntil:  time.Now().UTC().Add(time.Hour),
 		},
 	}
-	publisher.events = make(map[string]interface{})
+	publisher.reset()
 
 	rpcClient := grpcClient()
 	rootCtx, rootCancel := context.WithCancel(context.Background())
 	defer rootCancel()
 
-	accountReq := &pbUser.GetAccountsByFilterRequest{
-		Page:    0,
-		Limit:   0,
-		Country: "country1",
+	resp, err := rpcClient.UnlockAccount(rootCtx, &pbUser.UnlockAccountRequest{
+		Id:       "123",
+		ClientIp: "10.0.0.1",
+	})
+
+	assert.Nil(t, err)
+	assert.True(t, resp.Success)
+
+	attempts, err := repo.GetLoginAttempts(rootCtx, "client:10.0.0.1")
+	assert.Nil(t, err)
+	assert.Nil(t, attempts)
+}
+
+func TestAccountService_ClientIP_Trusted_Only_From_AuthenticatedClient(t *testing.T) {
+	repo.Accounts = []*user.Account{
+		{
+			Id:       "123",
+			Password: "pwd123",
+			Email:    "user1@mail.com",
+		},
 	}
+	repo.Attempts = nil
+	publisher.reset()
 
-	resp, err := rpcClient.GetAccountsByFilter(rootCtx, accountReq)
+	rootCtx, rootCancel := context.WithCancel(context.Background())
+	defer rootCancel()
+
+	ctx := metadata.AppendToOutgoingContext(rootCtx, user.ClientIPKey, "10.0.0.1")
+	invalidReq := &pbUser.ChangePasswordRequest{
+		Id:          "123",
+		OldPassword: "invalid",
+		NewPassword: "pwd12345",
+	}
+
+	_, err := grpcClient().ChangePassword(ctx, invalidReq)
+	assert.NotNil(t, err)
 
+	attempts, err := repo.GetLoginAttempts(rootCtx, "client:10.0.0.1")
 	assert.Nil(t, err)
-	assert.Equal(t, 1, len(resp.Accounts))
+	assert.Nil(t, attempts)
+	// peer address of unauthenticated client is not counted either
+	for key := range repo.Attempts {
+		assert.False(t, strings.HasPrefix(key, "client:"), key)
+	}
 
-	acc := resp.Accounts[0]
-	assert.NotNil(t, acc)
-	assert.Equal(t, "1", acc.Id)
+	_, err = gatewayClient().ChangePassword(ctx, invalidReq)
+	assert.NotNil(t, err)
+
+	attempts, err = repo.GetLoginAttempts(rootCtx, "client:10.0.0.1")
+	assert.Nil(t, err)
+	assert.NotNil(t, attempts)
+	assert.Equal(t, 1, attempts.Failures)
+}
+
+func TestAccountService_UnauthenticatedClient_Failures_DontLockOtherUsers(t *testing.T) {
+	repo.Accounts = nil
+	for i := 0; i <= 10; i++ {
+		repo.Accounts = append(repo.Accounts, &user.Account{
+			Id:       fmt.Sprint(i),
+			Password: "pwd123",
+			Email:    fmt.Sprintf("user%d@mail.com", i),
+		})
+	}
+	repo.Attempts = nil
+	publisher.reset()
+
+	rpcClient := grpcClient()
+	rootCtx, rootCancel := context.WithCancel(context.Background())
+	defer rootCancel()
+
+	// more failures than client lockout allows (20), all users come through the same unauthenticated gateway
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 3; j++ {
+			_, err := rpcClient.ChangePassword(rootCtx, &pbUser.ChangePasswordRequest{
+				Id:          fmt.Sprint(i),
+				OldPassword: "invalid",
+				NewPassword: "pwd12345",
+			})
+			assert.Contains(t, err.Error(), "invalid credentials")
+		}
+	}
+
+	resp, err := rpcClient.ChangePassword(rootCtx, &pbUser.ChangePasswordRequest{
+		Id:          "10",
+		OldPassword: "pwd123",
+		NewPassword: "pwd12345",
+	})
+	assert.Nil(t, err)
+	assert.True(t, resp.Success)
+}
+
+func TestAccountService_ConcurrentFailures_AreAllCounted(t *testing.T) {
+	repo.Accounts = nil
+	for i := 0; i < 10; i++ {
+		repo.Accounts = append(repo.Accounts, &user.Account{
+			Id:       fmt.Sprint(i),
+			Password: "pwd123",
+			Email:    fmt.Sprintf("user%d@mail.com", i),
+		})
+	}
+	repo.Attempts = nil
+	publisher.reset()
+
+	rpcClient := gatewayClient()
+	rootCtx, rootCancel := context.WithCancel(context.Background())
+	defer rootCancel()
+
+	ctx := metadata.AppendToOutgoingContext(rootCtx, user.ClientIPKey, "10.0.0.1")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+
+			_, err := rpcClient.ChangePassword(ctx, &pbUser.ChangePasswordRequest{
+				Id:          id,
+				OldPassword: "invalid",
+				NewPassword: "pwd12345",
+			})
+			assert.NotNil(t, err)
+		}(fmt.Sprint(i))
+	}
+	wg.Wait()
+
+	attempts, err := repo.GetLoginAttempts(rootCtx, "client:10.0.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, 10, attempts.Failures)
+}
+
+func TestAccountService_TOTP_Enabled_Requires_Code(t *testing.T) {
+	repo.Accounts = []*user.Account{
+		{
+			Id:        "123",
+			FirstName: "user 1",
+			LastName:  "user 1",
+			Nickname:  "user_1",
+			Password:  "pwd123-hashed",
+			Email:     "user1@mail.com",
+			Country:   "country1",
+			CreatedAt: time.Time{},
+			UpdatedAt: time.Time{},
+			DeletedAt: time.Time{},
+		},
+	}
+	repo.Attempts = nil
+	repo.TOTPs = nil
+	publisher.reset()
+
+	rpcClient := grpcClient()
+	rootCtx, rootCancel := context.WithCancel(context.Background())
+	defer rootCancel()
+
+	enrollResp, err := rpcClient.EnrollTOTP(rootCtx, &pbUser.EnrollTOTPRequest{
+		Id:       "123",
+		Password: "pwd123",
+	})
+
+	assert.Nil(t, err)
+	assert.NotEmpty(t, enrollResp.Secret)
+	assert.Contains(t, enrollResp.Uri, "otpauth://totp/")
+	assert.NotContains(t, repo.TOTPs["123"].Secret, enrollResp.Secret) // stored encrypted
+
+	code, err := user.GenerateTOTPCode(enrollResp.Secret, time.Now())
+	assert.Nil(t, err)
+
+	confirmResp, err := rpcClient.ConfirmTOTP(rootCtx, &pbUser.ConfirmTOTPRequest{
+		Id:   "123",
+		Code: code,
+	})
+
+	assert.Nil(t, err)
+	assert.True(t, confirmResp.Success)
+	assert.Equal(t, 10, len(confirmResp.RecoveryCodes))
+
+	_, err = rpcClient.ChangePassword(rootCtx, &pbUser.ChangePasswordRequest{
+		Id:          "123",
+		OldPassword: "pwd123",
+		NewPassword: "pwd12345",
+	})
+
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "two-factor code required")
+
+	pwdResp, err := rpcClient.ChangePassword(rootCtx, &pbUser.ChangePasswordRequest{
+		Id:          "123",
+		OldPassword: "pwd123",
+		NewPassword: "pwd12345",
+		TotpCode:    confirmResp.RecoveryCodes[0],
+	})
+
+	assert.Nil(t, err)
+	assert.True(t, pwdResp.Success)
+	assert.Equal(t, 9, len(repo.TOTPs["123"].RecoveryCodes))
+
+	publisher.eventually(t, "totp_enabled")
+}
+
+func TestAccountService_TOTP_ConcurrentRequests_UseCodeOnce(t *testing.T) {
+	repo.Accounts = []*user.Account{
+		{
+			Id:       "123",
+			Password: "pwd123-hashed",
+			Email:    "user1@mail.com",
+		},
+	}
+	repo.Attempts = nil
+	repo.TOTPs = nil
+	publisher.reset()
+
+	rpcClient := grpcClient()
+	rootCtx, rootCancel := context.WithCancel(context.Background())
+	defer rootCancel()
+
+	enrollResp, err := rpcClient.EnrollTOTP(rootCtx, &pbUser.EnrollTOTPRequest{
+		Id:       "123",
+		Password: "pwd123",
+	})
+	assert.Nil(t, err)
+
+	code, err := user.GenerateTOTPCode(enrollResp.Secret, time.Now())
+	assert.Nil(t, err)
+
+	_, err = rpcClient.ConfirmTOTP(rootCtx, &pbUser.ConfirmTOTPRequest{
+		Id:   "123",
+		Code: code,
+	})
+	assert.Nil(t, err)
+
+	// code of next step is accepted too, but only once
+	next, err := user.GenerateTOTPCode(enrollResp.Secret, time.Now().Add(30*time.Second))
+	assert.Nil(t, err)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded := 0
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := rpcClient.RegenerateRecoveryCodes(rootCtx, &pbUser.RegenerateRecoveryCodesRequest{
+				Id:   "123",
+				Code: next,
+			})
+			if err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, succeeded)
+}
+
+func TestAccountService_DisableTOTP_Removes_OnlyAccountTOTP(t *testing.T) {
+	repo.Accounts = []*user.Account{
+		{
+			Id:       "123",
+			Password: "pwd123-hashed",
+			Email:    "user1@mail.com",
+		},
+		{
+			Id:       "456",
+			Password: "pwd456-hashed",
+			Email:    "user2@mail.com",
+		},
+	}
+	repo.Attempts = nil
+	repo.TOTPs = nil
+	publisher.reset()
+
+	rpcClient := grpcClient()
+	rootCtx, rootCancel := context.WithCancel(context.Background())
+	defer rootCancel()
+
+	recoveryCodes := make(map[string][]string)
+	for id, password := range map[string]string{"123": "pwd123", "456": "pwd456"} {
+		enrollResp, err := rpcClient.EnrollTOTP(rootCtx, &pbUser.EnrollTOTPRequest{
+			Id:       id,
+			Password: password,
+		})
+		assert.Nil(t, err)
+
+		code, err := user.GenerateTOTPCode(enrollResp.Secret, time.Now())
+		assert.Nil(t, err)
+
+		confirmResp, err := rpcClient.ConfirmTOTP(rootCtx, &pbUser.ConfirmTOTPRequest{
+			Id:   id,
+			Code: code,
+		})
+		assert.Nil(t, err)
+
+		recoveryCodes[id] = confirmResp.RecoveryCodes
+	}
+
+	disableResp, err := rpcClient.DisableTOTP(rootCtx, &pbUser.DisableTOTPRequest{
+		Id:       "123",
+		Password: "pwd123",
+		Code:     recoveryCodes["123"][0],
+	})
+
+	assert.Nil(t, err)
+	assert.True(t, disableResp.Success)
+	assert.Nil(t, repo.TOTPs["123"])
+	assert.NotNil(t, repo.TOTPs["456"])
+	assert.True(t, repo.TOTPs["456"].Confirmed)
+}
+
+func TestAccountService_APIKey_Lifecycle(t *testing.T) {
+	repo.APIKeys = nil
+
+	rpcClient := grpcClient()
+	rootCtx, rootCancel := context.WithCancel(context.Background())
+	defer rootCancel()
+
+	created, err := rpcClient.CreateAPIKey(rootCtx, &pbUser.CreateAPIKeyRequest{
+		Name:       "matchmaking",
+		Scopes:     []string{user.ScopeAccountsRead},
+		TtlSeconds: 3600,
+	})
+
+	assert.Nil(t, err)
+	assert.NotEmpty(t, created.Id)
+	assert.True(t, strings.HasPrefix(created.Key, "fk_"))
+	assert.Equal(t, 1, len(repo.APIKeys))
+	assert.NotEqual(t, created.Key, repo.APIKeys[0].Hash) // only hash is stored
+
+	validated, err := rpcClient.ValidateAPIKey(rootCtx, &pbUser.ValidateAPIKeyRequest{
+		Key:   created.Key,
+		Scope: user.ScopeAccountsRead,
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, created.Id, validated.Id)
+	assert.Empty(t, validated.Key)
+	assert.False(t, repo.APIKeys[0].LastUsedAt.IsZero())
+
+	_, err = rpcClient.ValidateAPIKey(rootCtx, &pbUser.ValidateAPIKeyRequest{
+		Key:   created.Key,
+		Scope: user.ScopeAccountsWrite,
+	})
+
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "missing required scope")
+
+	revoked, err := rpcClient.RevokeAPIKey(rootCtx, &pbUser.RevokeAPIKeyRequest{
+		Id: created.Id,
+	})
+
+	assert.Nil(t, err)
+	assert.True(t, revoked.Success)
+
+	_, err = rpcClient.ValidateAPIKey(rootCtx, &pbUser.ValidateAPIKeyRequest{
+		Key: created.Key,
+	})
+
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "invalid api key")
+}
+
+func TestAccountService_CreateAPIKey_UnknownScope_Returns_Fail(t *testing.T) {
+	repo.APIKeys = nil
+
+	rpcClient := grpcClient()
+	rootCtx, rootCancel := context.WithCancel(context.Background())
+	defer rootCancel()
+
+	_, err := rpcClient.CreateAPIKey(rootCtx, &pbUser.CreateAPIKeyRequest{
+		Name:   "matchmaking",
+		Scopes: []string{user.ScopeAccountsRead, "accounts:delete"},
+	})
+
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "unknown api key scope")
+	assert.Empty(t, repo.APIKeys)
+}
+
+func TestAccountService_CreateAPIKey_OwnedByAccount(t *testing.T) {
+	repo.Accounts = []*user.Account{
+		{
+			Id:    "123",
+			Email: "user1@mail.com",
+		},
+	}
+	repo.APIKeys = nil
+
+	rpcClient := grpcClient()
+	rootCtx, rootCancel := context.WithCancel(context.Background())
+	defer rootCancel()
+
+	_, err := rpcClient.CreateAPIKey(rootCtx, &pbUser.CreateAPIKeyRequest{
+		Name:      "user 1",
+		Scopes:    []string{user.ScopeAccountsRead},
+		AccountId: "456",
+	})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "account not found")
+
+	_, err = rpcClient.CreateAPIKey(rootCtx, &pbUser.CreateAPIKeyRequest{
+		Name:      "user 1",
+		Scopes:    []string{user.ScopeAdmin},
+		AccountId: "123",
+	})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "can't have admin scope")
+
+	created, err := rpcClient.CreateAPIKey(rootCtx, &pbUser.CreateAPIKeyRequest{
+		Name:      "user 1",
+		Scopes:    []string{user.ScopeAccountsRead},
+		AccountId: "123",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "123", created.AccountId)
+
+	validated, err := rpcClient.ValidateAPIKey(rootCtx, &pbUser.ValidateAPIKeyRequest{
+		Key: created.Key,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "123", validated.AccountId)
+}
+
+func TestAccountService_APIKeys_Returned_AsCopies(t *testing.T) {
+	repo.APIKeys = nil
+
+	rpcClient := grpcClient()
+	rootCtx, rootCancel := context.WithCancel(context.Background())
+	defer rootCancel()
+
+	_, err := rpcClient.CreateAPIKey(rootCtx, &pbUser.CreateAPIKeyRequest{
+		Name:   "matchmaking",
+		Scopes: []string{user.ScopeAccountsRead},
+	})
+	assert.Nil(t, err)
+
+	keys, err := repo.GetAPIKeys(rootCtx)
+	assert.Nil(t, err)
+	keys[0].Scopes[0] = user.ScopeAdmin
+
+	key, err := repo.GetAPIKeyByHash(rootCtx, repo.APIKeys[0].Hash)
+	assert.Nil(t, err)
+	key.Name = "changed"
+
+	assert.Equal(t, []string{user.ScopeAccountsRead}, repo.APIKeys[0].Scopes)
+	assert.Equal(t, "matchmaking", repo.APIKeys[0].Name)
+}
+
+func TestAccountService_DeleteAccount(t *testing.T) {
+	repo.Accounts = []*user.Account{
+		{
+			Id:        "123",
+			FirstName: "user 1",
+			LastName:  "user 1",
+			Nickname:  "user_1",
+			Password:  "pwd123",
+			Email:     "user1@mail.com",
+			Country:   "country1",
+			CreatedAt: time.Time{},
+			UpdatedAt: time.Time{},
+			DeletedAt: time.Time{},
+		},
+	}
+	publisher.reset()
+
+	rpcClient := grpcClient()
+	rootCtx, rootCancel := context.WithCancel(context.Background())
+	defer rootCancel()
+
+	accountReq := &pbUser.DeleteAccountRequest{
+		Id: "123",
+	}
+
+	resp, err := rpcClient.DeleteAccount(rootCtx, accountReq)
+
+	assert.Nil(t, err)
+	assert.True(t, resp.Success)
+	assert.Equal(t, 1, len(repo.Accounts))
+	assert.False(t, repo.Accounts[0].DeletedAt.IsZero()) // soft deleted
+
+	publisher.eventually(t, "account_deleted")
+}
+
+func TestAccountService_DeletedAccount_Restore_And_Purge(t *testing.T) {
+	repo.Accounts = []*user.Account{
+		{
+			Id:        "123",
+			FirstName: "user 1",
+			Email:     "user1@mail.com",
+			Country:   "country1",
+			DeletedAt: time.Now().UTC(),
+		},
+		{
+			Id:      "456",
+			Email:   "user2@mail.com",
+			Country: "country1",
+		},
+	}
+	repo.TOTPs = map[string]*user.TOTP{"123": {AccountId: "123"}}
+	publisher.reset()
+
+	rpcClient := grpcClient()
+	rootCtx, rootCancel := context.WithCancel(context.Background())
+	defer rootCancel()
+
+	active, err := rpcClient.GetAccountsByFilter(rootCtx, &pbUser.GetAccountsByFilterRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(active.Accounts))
+
+	deleted, err := rpcClient.GetAccountsByFilter(rootCtx, &pbUser.GetAccountsByFilterRequest{OnlyDeleted: true})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(deleted.Accounts))
+	assert.Equal(t, "123", deleted.Accounts[0].Id)
+
+	all, err := rpcClient.GetAccountsByFilter(rootCtx, &pbUser.GetAccountsByFilterRequest{IncludeDeleted: true})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(all.Accounts))
+
+	// active account can not be purged
+	_, err = rpcClient.PurgeAccount(rootCtx, &pbUser.PurgeAccountRequest{Id: "456"})
+	assert.NotNil(t, err)
+
+	restored, err := rpcClient.RestoreAccount(rootCtx, &pbUser.RestoreAccountRequest{Id: "123"})
+	assert.Nil(t, err)
+	assert.Equal(t, "user 1", restored.FirstName)
+	assert.True(t, repo.Accounts[0].DeletedAt.IsZero())
+	publisher.eventually(t, "account_restored")
+
+	_, err = rpcClient.DeleteAccount(rootCtx, &pbUser.DeleteAccountRequest{Id: "123"})
+	assert.Nil(t, err)
+
+	purged, err := rpcClient.PurgeAccount(rootCtx, &pbUser.PurgeAccountRequest{Id: "123"})
+	assert.Nil(t, err)
+	assert.True(t, purged.Success)
+	assert.Equal(t, 1, len(repo.Accounts))
+	assert.Nil(t, repo.TOTPs["123"])
+	publisher.eventually(t, "account_purged")
+
+	_, err = rpcClient.RestoreAccount(rootCtx, &pbUser.RestoreAccountRequest{Id: "123"})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "deleted account not found")
+}
+
+func TestAccountService_EraseAccount_Scrubs_PersonalData(t *testing.T) {
+	repo.Accounts = []*user.Account{
+		{
+			Id:        "123",
+			FirstName: "user 1",
+			LastName:  "user 1",
+			Nickname:  "user_1",
+			Password:  "pwd123",
+			Email:     "user1@mail.com",
+			Country:   "country1",
+			Version:   1,
+		},
+	}
+	repo.TOTPs = map[string]*user.TOTP{"123": {AccountId: "123"}}
+	publisher.reset()
+
+	rpcClient := grpcClient()
+	rootCtx, rootCancel := context.WithCancel(context.Background())
+	defer rootCancel()
+
+	resp, err := rpcClient.EraseAccount(rootCtx, &pbUser.EraseAccountRequest{Id: "123"})
+	assert.Nil(t, err)
+	assert.True(t, resp.Success)
+	publisher.eventually(t, "account_erased")
+
+	erased := repo.Accounts[0]
+	assert.Equal(t, "123", erased.Id)
+	assert.Equal(t, user.ErasedValue, erased.FirstName)
+	assert.Equal(t, user.ErasedValue, erased.LastName)
+	assert.Equal(t, user.ErasedValue, erased.Nickname)
+	assert.NotContains(t, erased.Email, "user1")
+	assert.Empty(t, erased.Password)
+	assert.Empty(t, erased.Country)
+	assert.False(t, erased.ErasedAt.IsZero())
+	assert.False(t, erased.DeletedAt.IsZero())
+	assert.Nil(t, repo.TOTPs["123"])
+
+	_, err = rpcClient.RestoreAccount(rootCtx, &pbUser.RestoreAccountRequest{Id: "123"})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "account is erased")
+
+	_, err = rpcClient.EraseAccount(rootCtx, &pbUser.EraseAccountRequest{Id: "123"})
+	assert.NotNil(t, err)
+}
+
+func TestAccountService_AuditLog_Records_Changes(t *testing.T) {
+	repo.Accounts = []*user.Account{
+		{
+			Id:        "123",
+			FirstName: "user 1",
+			Email:     "user1@mail.com",
+			Country:   "country1",
+			Version:   1,
+		},
+	}
+	repo.AuditLog = nil
+	repo.APIKeys = nil
+	publisher.reset()
+
+	rpcClient := gatewayClient()
+	rootCtx, rootCancel := context.WithCancel(context.Background())
+	defer rootCancel()
+
+	key, err := rpcClient.CreateAPIKey(rootCtx, &pbUser.CreateAPIKeyRequest{Name: "gateway", Scopes: []string{user.ScopeAdmin}})
+	assert.Nil(t, err)
+
+	// gateway forwards validated api key, actor is derived from it
+	ctx := metadata.AppendToOutgoingContext(rootCtx, user.APIKeyKey, key.Key, user.ClientIPKey, "10.0.0.1")
+
+	_, err = rpcClient.ModifyAccount(ctx, &pbUser.AccountMessage{
+		Id:         "123",
+		Country:    "country2",
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"country"}},
+	})
+	assert.Nil(t, err)
+
+	log, err := rpcClient.GetAuditLog(rootCtx, &pbUser.GetAuditLogRequest{TargetId: "123", Action: user.ActionModifyAccount})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(log.Entries))
+
+	entry := log.Entries[0]
+	assert.Equal(t, user.APIKeyActor(key.Id), entry.Actor)
+	assert.Equal(t, "10.0.0.1", entry.ClientIp)
+	assert.Equal(t, user.TargetAccount, entry.TargetType)
+
+	changes := make(map[string]string)
+	for _, change := range entry.Changes {
+		changes[change.Field] = change.Before + " -> " + change.After
+	}
+	assert.Equal(t, map[string]string{"country": "country1 -> country2", "version": "1 -> 2"}, changes)
+
+	_, err = rpcClient.EraseAccount(ctx, &pbUser.EraseAccountRequest{Id: "123"})
+	assert.Nil(t, err)
+
+	// newest first, personal data of erased account is redacted
+	log, err = rpcClient.GetAuditLog(rootCtx, &pbUser.GetAuditLogRequest{TargetId: "123"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(log.Entries))
+	assert.Equal(t, user.ActionEraseAccount, log.Entries[0].Action)
+	// client ip of entries made before erasure is redacted as well
+	assert.Empty(t, log.Entries[1].ClientIp)
+	for _, entry := range log.Entries {
+		for _, change := range entry.Changes {
+			assert.NotContains(t, []string{"user 1", "user1@mail.com", "country1", "country2"}, change.Before)
+			assert.NotContains(t, []string{"user 1", "user1@mail.com", "country1", "country2"}, change.After)
+		}
+	}
+
+	paged, err := rpcClient.GetAuditLog(rootCtx, &pbUser.GetAuditLogRequest{TargetId: "123", Page: 2, Limit: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(paged.Entries))
+	assert.Equal(t, user.ActionModifyAccount, paged.Entries[0].Action)
+
+	_, err = rpcClient.GetAuditLog(rootCtx, &pbUser.GetAuditLogRequest{Since: "yesterday"})
+	assert.NotNil(t, err)
+}
+
+func TestAccountService_ExportAccountData(t *testing.T) {
+	repo.Accounts = nil
+	publisher.reset()
+
+	rpcClient := grpcClient()
+	rootCtx, rootCancel := context.WithCancel(context.Background())
+	defer rootCancel()
+
+	account, err := rpcClient.AddAccount(rootCtx, &pbUser.AccountRequest{
+		FirstName: "user 1",
+		Email:     "export@mail.com",
+		Password:  "pwd123",
+		Country:   "country1",
+	})
+	assert.Nil(t, err)
+
+	started, err := rpcClient.ExportAccountData(rootCtx, &pbUser.ExportAccountDataRequest{Id: account.Id})
+	assert.Nil(t, err)
+	assert.Equal(t, account.Id, started.AccountId)
+	assert.NotEmpty(t, started.Id)
+
+	publisher.eventually(t, "account_export_ready")
+	assert.Equal(t, started.Id, publisher.published("account_export_ready"))
+
+	export, err := rpcClient.GetAccountExport(rootCtx, &pbUser.GetAccountExportRequest{Id: started.Id, AccountId: account.Id})
+	assert.Nil(t, err)
+	assert.Equal(t, user.ExportReady, export.Status)
+
+	archive, err := zip.NewReader(bytes.NewReader(export.Archive), int64(len(export.Archive)))
+	assert.Nil(t, err)
+
+	files := make(map[string]string)
+	for _, file := range archive.File {
+		r, err := file.Open()
+		assert.Nil(t, err)
+		content, err := io.ReadAll(r)
+		assert.Nil(t, err)
+		files[file.Name] = string(content)
+	}
+
+	assert.Contains(t, files["account.json"], "export@mail.com")
+	assert.NotContains(t, files["account.json"], "pwd123")
+	assert.Contains(t, files, "security.json")
+	assert.Contains(t, files["events.json"], "account_created")
+
+	// export of other account is not found
+	_, err = rpcClient.GetAccountExport(rootCtx, &pbUser.GetAccountExportRequest{Id: started.Id, AccountId: "456"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestAccountService_ExportAccountData_Exports_ClientIP_OfAccount_Only(t *testing.T) {
+	repo.Accounts = []*user.Account{
+		{Id: "123", FirstName: "user 1", Email: "user1@mail.com", Country: "country1", Version: 1},
+	}
+	repo.AuditLog = nil
+	repo.APIKeys = nil
+	publisher.reset()
+
+	rpcClient := gatewayClient()
+	rootCtx, rootCancel := context.WithCancel(context.Background())
+	defer rootCancel()
+
+	admin, err := rpcClient.CreateAPIKey(rootCtx, &pbUser.CreateAPIKeyRequest{Name: "admin", Scopes: []string{user.ScopeAdmin}})
+	assert.Nil(t, err)
+	owned, err := rpcClient.CreateAPIKey(rootCtx, &pbUser.CreateAPIKeyRequest{Name: "owner", Scopes: []string{user.ScopeAccountsWrite}, AccountId: "123"})
+	assert.Nil(t, err)
+
+	for key, ip := range map[string]string{owned.Key: "10.0.0.1", admin.Key: "10.0.0.2"} {
+		ctx := metadata.AppendToOutgoingContext(rootCtx, user.APIKeyKey, key, user.ClientIPKey, ip)
+
+		_, err = rpcClient.ModifyAccount(ctx, &pbUser.AccountMessage{
+			Id:         "123",
+			Country:    "country2",
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"country"}},
+		})
+		assert.Nil(t, err)
+	}
+
+	started, err := rpcClient.ExportAccountData(rootCtx, &pbUser.ExportAccountDataRequest{Id: "123"})
+	assert.Nil(t, err)
+	publisher.eventually(t, "account_export_ready")
+
+	export, err := rpcClient.GetAccountExport(rootCtx, &pbUser.GetAccountExportRequest{Id: started.Id, AccountId: "123"})
+	assert.Nil(t, err)
+
+	archive, err := zip.NewReader(bytes.NewReader(export.Archive), int64(len(export.Archive)))
+	assert.Nil(t, err)
+
+	r, err := archive.Open("audit.json")
+	assert.Nil(t, err)
+	audit, err := io.ReadAll(r)
+	assert.Nil(t, err)
+
+	assert.Contains(t, string(audit), user.APIKeyActor(admin.Id))
+	assert.Contains(t, string(audit), "10.0.0.1")
+	assert.NotContains(t, string(audit), "10.0.0.2")
+}
+
+func TestAccountService_DeleteAccount_NoAccount_Returns_Fail(t *testing.T) {
+	repo.Accounts = nil
+	publisher.reset()
+
+	rpcClient := grpcClient()
+	rootCtx, rootCancel := context.WithCancel(context.Background())
+	defer rootCancel()
+
+	accountReq := &pbUser.DeleteAccountRequest{
+		Id: "123",
+	}
+
+	resp, err := rpcClient.DeleteAccount(rootCtx, accountReq)
+
+	assert.NotNil(t, err)
+	assert.Nil(t, resp)
+	assert.Contains(t, err.Error(), "account not found")
+}
+
+func TestAccountService_GetAccountsByFilter(t *testing.T) {
+	repo.Accounts = []*user.Account{
+		{
+			Id:        "1",
+			FirstName: "user 1",
+			LastName:  "user 1",
+			Nickname:  "user_1",
+			Password:  "pwd123",
+			Email:     "user1@mail.com",
+			Country:   "country1",
+			CreatedAt: time.Time{},
+			UpdatedAt: time.Time{},
+			DeletedAt: time.Time{},
+		},
+		{
+			Id:        "2",
+			FirstName: "user 2",
+			LastName:  "user 2",
+			Nickname:  "user_2",
+			Password:  "pwd123",
+			Email:     "user2@mail.com",
+			Country:   "country2",
+			CreatedAt: time.Time{},
+			UpdatedAt: time.Time{},
+			DeletedAt: time.Time{},
+		},
+	}
+	publisher.reset()
+
+	rpcClient := grpcClient()
+	rootCtx, rootCancel := context.WithCancel(context.Background())
+	defer rootCancel()
+
+	accountReq := &pbUser.GetAccountsByFilterRequest{
+		Page:    0,
+		Limit:   0,
+		Country: "country1",
+	}
+
+	resp, err := rpcClient.GetAccountsByFilter(rootCtx, accountReq)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(resp.Accounts))
+
+	acc := resp.Accounts[0]
+	assert.NotNil(t, acc)
+	assert.Equal(t, "1", acc.Id)
+}
+
+func TestAccountService_PurgeDeleted_Removes_ExpiredAccounts(t *testing.T) {
+	purgeRepo := repository.NewAccountInmemory()
+	purgeRepo.Accounts = []*user.Account{
+		{Id: "1", Email: "user1@mail.com", DeletedAt: time.Now().UTC().Add(-48 * time.Hour)},
+		{Id: "2", Email: "user2@mail.com", DeletedAt: time.Now().UTC().Add(-48 * time.Hour)},
+		{Id: "3", Email: "user3@mail.com", DeletedAt: time.Now().UTC().Add(-time.Hour)},
+		{Id: "4", Email: "user4@mail.com"},
+	}
+	purgePublisher := newMockPublisher()
+
+	conf := user.NewConfig()
+	conf.Purge.Retention = 24 * time.Hour
+	conf.Purge.BatchSize = 1
+
+	svc := user.NewAccountService(conf, purgeRepo, purgePublisher, &mockPwdHash{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	svc.PurgeDeleted(ctx)
+	assert.Nil(t, svc.Close(context.Background()))
+
+	assert.Equal(t, 2, len(purgeRepo.Accounts))
+	assert.Equal(t, "3", purgeRepo.Accounts[0].Id)
+	assert.Equal(t, "4", purgeRepo.Accounts[1].Id)
+	purgePublisher.eventually(t, "account_purged")
+}
+
+func TestAccountService_Close_WaitsFor_ScheduledPurge(t *testing.T) {
+	conf := user.NewConfig()
+	conf.Purge.Retention = 24 * time.Hour
+
+	svc := user.NewAccountService(conf, repository.NewAccountInmemory(), newMockPublisher(), &mockPwdHash{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	svc.PurgeDeleted(ctx)
+
+	closeCtx, closeCancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer closeCancel()
+
+	// purge is still scheduled
+	assert.Equal(t, context.DeadlineExceeded, svc.Close(closeCtx))
+
+	cancel()
+	assert.Nil(t, svc.Close(context.Background()))
 }
@@ -0,0 +1,241 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"github.com/semirm-dev/faceit/event"
+	pbUser "github.com/semirm-dev/faceit/user/proto"
+)
+
+// EnrollTOTP will generate new totp secret for account, it has to be confirmed with valid code before it's enabled
+func (svc *accountService) EnrollTOTP(ctx context.Context, req *pbUser.EnrollTOTPRequest) (*pbUser.EnrollTOTPResponse, error) {
+	account, err := svc.repo.GetById(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+	if account == nil || account.Email == "" {
+		return nil, errors.New("account not found")
+	}
+
+	existing, err := svc.repo.GetTOTP(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil && existing.Confirmed {
+		return nil, ErrTOTPEnabled
+	}
+
+	if err = svc.checkCredentials(ctx, account, req.Password, ""); err != nil {
+		return nil, err
+	}
+
+	totp, secret, uri, err := svc.totp.generate(account.Id, account.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	err = svc.repo.WithinTx(ctx, func(ctx context.Context) error {
+		if err := svc.repo.SaveTOTP(ctx, totp); err != nil {
+			return err
+		}
+
+		return svc.audit(ctx, ActionEnrollTOTP, TargetAccount, account.Id, twoFactorChange(existing, totp))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &pbUser.EnrollTOTPResponse{
+		Secret: secret,
+		Uri:    uri,
+	}, nil
+}
+
+// ConfirmTOTP will enable enrolled totp once user proves authenticator app is set up, recovery codes are returned only once
+func (svc *accountService) ConfirmTOTP(ctx context.Context, req *pbUser.ConfirmTOTPRequest) (*pbUser.ConfirmTOTPResponse, error) {
+	account, totp, err := svc.accountTOTP(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+	if totp.Confirmed {
+		return nil, ErrTOTPEnabled
+	}
+
+	if err = svc.checkTOTPCode(ctx, account, totp, req.Code); err != nil {
+		return nil, err
+	}
+
+	codes, err := svc.totp.recoveryCodes(totp)
+	if err != nil {
+		return nil, err
+	}
+
+	before := *totp
+	totp.Confirmed = true
+
+	err = svc.repo.WithinTx(ctx, func(ctx context.Context) error {
+		if err := svc.repo.SaveTOTP(ctx, totp); err != nil {
+			return err
+		}
+
+		return svc.audit(ctx, ActionConfirmTOTP, TargetAccount, account.Id, twoFactorChange(&before, totp))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	svc.publishAccount(ctx, event.TOTPEnabled, account.Id)
+
+	return &pbUser.ConfirmTOTPResponse{
+		Success:       true,
+		RecoveryCodes: codes,
+	}, nil
+}
+
+// DisableTOTP will remove second factor, both password and code (or recovery code) are required
+func (svc *accountService) DisableTOTP(ctx context.Context, req *pbUser.DisableTOTPRequest) (*pbUser.DisableTOTPResponse, error) {
+	account, totp, err := svc.accountTOTP(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+	if !totp.Confirmed {
+		return nil, ErrTOTPNotEnrolled
+	}
+
+	if err = svc.checkCredentials(ctx, account, req.Password, req.Code); err != nil {
+		return nil, err
+	}
+
+	err = svc.repo.WithinTx(ctx, func(ctx context.Context) error {
+		if err := svc.repo.DeleteTOTP(ctx, account.Id); err != nil {
+			return err
+		}
+
+		return svc.audit(ctx, ActionDisableTOTP, TargetAccount, account.Id, twoFactorChange(totp, nil))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	svc.publishAccount(ctx, event.TOTPDisabled, account.Id)
+
+	return &pbUser.DisableTOTPResponse{
+		Success: true,
+	}, nil
+}
+
+// RegenerateRecoveryCodes will replace all recovery codes with new ones
+func (svc *accountService) RegenerateRecoveryCodes(ctx context.Context, req *pbUser.RegenerateRecoveryCodesRequest) (*pbUser.RecoveryCodesResponse, error) {
+	account, totp, err := svc.accountTOTP(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+	if !totp.Confirmed {
+		return nil, ErrTOTPNotEnrolled
+	}
+
+	if err = svc.checkTOTPCode(ctx, account, totp, req.Code); err != nil {
+		return nil, err
+	}
+
+	codes, err := svc.totp.recoveryCodes(totp)
+	if err != nil {
+		return nil, err
+	}
+
+	err = svc.repo.WithinTx(ctx, func(ctx context.Context) error {
+		if err := svc.repo.SaveTOTP(ctx, totp); err != nil {
+			return err
+		}
+
+		return svc.audit(ctx, ActionRegenerateRecoveryCodes, TargetAccount, account.Id, map[string]Change{
+			"recovery_codes": {Before: Redacted, After: Redacted},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &pbUser.RecoveryCodesResponse{
+		RecoveryCodes: codes,
+	}, nil
+}
+
+// twoFactorChange describes second factor state change for audit log, secrets are never audited
+func twoFactorChange(before, after *TOTP) map[string]Change {
+	state := func(totp *TOTP) string {
+		switch {
+		case totp == nil:
+			return "disabled"
+		case totp.Confirmed:
+			return "enabled"
+		default:
+			return "enrolled"
+		}
+	}
+
+	return map[string]Change{
+		"two_factor": {Before: state(before), After: state(after)},
+	}
+}
+
+func (svc *accountService) accountTOTP(ctx context.Context, id string) (*Account, *TOTP, error) {
+	account, err := svc.repo.GetById(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if account == nil || account.Email == "" {
+		return nil, nil, errors.New("account not found")
+	}
+
+	totp, err := svc.repo.GetTOTP(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if totp == nil {
+		return nil, nil, ErrTOTPNotEnrolled
+	}
+
+	return account, totp, nil
+}
+
+// checkTOTPCode will validate totp code (recovery codes not allowed), with brute-force protection
+func (svc *accountService) checkTOTPCode(ctx context.Context, account *Account, totp *TOTP, code string) error {
+	ip := forwardedIP(ctx)
+
+	if err := svc.guard.allow(ctx, account.Id, ip); err != nil {
+		return err
+	}
+
+	if err := svc.useTOTP(ctx, totp, code, false); err != nil {
+		if errors.Is(err, ErrInvalidTOTP) {
+			svc.credentialsFailed(ctx, account.Id, ip)
+		}
+		return err
+	}
+
+	return svc.guard.succeeded(ctx, account.Id)
+}
+
+// useTOTP will validate code and mark it as used, code used by concurrent request in the meantime is invalid
+func (svc *accountService) useTOTP(ctx context.Context, totp *TOTP, code string, allowRecovery bool) error {
+	use, err := svc.totp.validate(totp, code, allowRecovery)
+	if err != nil {
+		return err
+	}
+
+	var used bool
+	if use.recoveryCode != "" {
+		used, err = svc.repo.UseRecoveryCode(ctx, totp.AccountId, use.recoveryCode)
+	} else {
+		used, err = svc.repo.UseTOTPStep(ctx, totp.AccountId, use.step)
+	}
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidTOTP
+	}
+
+	return nil
+}
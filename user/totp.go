@@ -0,0 +1,276 @@
+package user
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gobackpack/crypto"
+)
+
+const (
+	totpDigits        = 6
+	totpPeriod        = 30
+	totpSkew          = 1
+	totpSecretLen     = 20
+	recoveryCodes     = 10
+	recoveryCodeBytes = 5
+)
+
+var (
+	ErrTOTPNotConfigured = errors.New("two-factor authentication is not configured")
+	ErrTOTPNotEnrolled   = errors.New("two-factor authentication is not enrolled")
+	ErrTOTPEnabled       = errors.New("two-factor authentication is already enabled")
+	ErrTOTPRequired      = errors.New("two-factor code required")
+	ErrInvalidTOTP       = errors.New("invalid two-factor code")
+)
+
+// TOTP is time-based one-time password second factor of an account
+type TOTP struct {
+	AccountId string
+	// Secret is encrypted base32 secret
+	Secret    string
+	Confirmed bool
+	// RecoveryCodes are SHA-256 hashes of unused recovery codes
+	RecoveryCodes []string
+	// LastUsedStep prevents same code from being used twice
+	LastUsedStep int64
+	CreatedAt    time.Time
+}
+
+// TOTPRepository persists account second factors
+type TOTPRepository interface {
+	GetTOTP(ctx context.Context, accountId string) (*TOTP, error)
+	SaveTOTP(ctx context.Context, totp *TOTP) error
+	DeleteTOTP(ctx context.Context, accountId string) error
+	// UseTOTPStep will atomically mark step as used, returns false if the same or later step was used already
+	UseTOTPStep(ctx context.Context, accountId string, step int64) (bool, error)
+	// UseRecoveryCode will atomically remove hashed recovery code, returns false if it was used already
+	UseRecoveryCode(ctx context.Context, accountId, hashed string) (bool, error)
+}
+
+// totpUse is what validated code used up, totp step or hashed recovery code
+type totpUse struct {
+	step         int64
+	recoveryCode string
+}
+
+// ValidateTOTPKey checks key used to encrypt totp secrets, empty key is valid as it disables 2fa
+func ValidateTOTPKey(key string) error {
+	if key == "" {
+		return nil
+	}
+
+	if !utf8.ValidString(key) {
+		return errors.New("totp key must be valid utf-8 text")
+	}
+
+	if _, err := aes.NewCipher([]byte(key)); err != nil {
+		return fmt.Errorf("totp key must be 16, 24 or 32 bytes long, got %d", len(key))
+	}
+
+	return nil
+}
+
+// GenerateTOTPCode will generate RFC 6238 code for base32 secret at given time
+func GenerateTOTPCode(secret string, t time.Time) (string, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return hotp(key, t.Unix()/totpPeriod), nil
+}
+
+// totpManager will create, encrypt and validate second factors
+type totpManager struct {
+	issuer string
+	enc    *crypto.GCM
+	now    func() time.Time
+}
+
+func newTOTPManager(issuer, key string) *totpManager {
+	var enc *crypto.GCM
+	if key != "" {
+		enc = crypto.NewGCM(key)
+	}
+
+	return &totpManager{
+		issuer: issuer,
+		enc:    enc,
+		now:    func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// generate returns new unconfirmed second factor, plain base32 secret and otpauth uri
+func (manager *totpManager) generate(accountId, email string) (*TOTP, string, string, error) {
+	if manager.enc == nil {
+		return nil, "", "", ErrTOTPNotConfigured
+	}
+
+	raw := make([]byte, totpSecretLen)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, "", "", err
+	}
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw)
+
+	encrypted, err := manager.encrypt(secret)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	return &TOTP{
+		AccountId: accountId,
+		Secret:    encrypted,
+		CreatedAt: manager.now(),
+	}, secret, manager.uri(email, secret), nil
+}
+
+// uri is otpauth key uri, usually rendered as QR code for authenticator apps
+func (manager *totpManager) uri(email, secret string) string {
+	label := url.PathEscape(manager.issuer + ":" + email)
+
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", manager.issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(totpPeriod))
+
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// validate checks code against secret, or against unused recovery codes if allowRecovery is set.
+// Used recovery code is removed and step of used totp code is remembered in totp, returned use has to be
+// stored atomically so concurrent requests can't use the same code.
+func (manager *totpManager) validate(totp *TOTP, code string, allowRecovery bool) (*totpUse, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return nil, ErrTOTPRequired
+	}
+
+	if allowRecovery && len(code) != totpDigits {
+		return manager.useRecoveryCode(totp, code)
+	}
+
+	if manager.enc == nil {
+		return nil, ErrTOTPNotConfigured
+	}
+
+	secret, err := manager.decrypt(totp.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	current := manager.now().Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= totp.LastUsedStep {
+			continue
+		}
+
+		if subtle.ConstantTimeCompare([]byte(hotp(key, step)), []byte(code)) == 1 {
+			totp.LastUsedStep = step
+			return &totpUse{step: step}, nil
+		}
+	}
+
+	return nil, ErrInvalidTOTP
+}
+
+// recoveryCodes generates new set of plain recovery codes and stores their hashes in totp
+func (manager *totpManager) recoveryCodes(totp *TOTP) ([]string, error) {
+	plain := make([]string, 0, recoveryCodes)
+	hashed := make([]string, 0, recoveryCodes)
+
+	for i := 0; i < recoveryCodes; i++ {
+		raw := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+
+		code := hex.EncodeToString(raw)
+		code = code[:5] + "-" + code[5:]
+
+		plain = append(plain, code)
+		hashed = append(hashed, hashRecoveryCode(code))
+	}
+
+	totp.RecoveryCodes = hashed
+
+	return plain, nil
+}
+
+func (manager *totpManager) useRecoveryCode(totp *TOTP, code string) (*totpUse, error) {
+	hashed := hashRecoveryCode(code)
+
+	for i, existing := range totp.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(existing), []byte(hashed)) == 1 {
+			totp.RecoveryCodes = append(totp.RecoveryCodes[:i:i], totp.RecoveryCodes[i+1:]...)
+			return &totpUse{recoveryCode: hashed}, nil
+		}
+	}
+
+	return nil, ErrInvalidTOTP
+}
+
+func (manager *totpManager) encrypt(secret string) (string, error) {
+	encrypted, _, _, err := manager.enc.Encrypt([]byte(secret))
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString([]byte(encrypted)), nil
+}
+
+func (manager *totpManager) decrypt(secret string) (string, error) {
+	encrypted, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return manager.enc.Decrypt(string(encrypted))
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	return base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+}
+
+// hotp is RFC 4226 HMAC-based one-time password
+func hotp(key []byte, counter int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
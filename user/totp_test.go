@@ -0,0 +1,17 @@
+package user_test
+
+import (
+	"github.com/semirm-dev/faceit/user"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestValidateTOTPKey(t *testing.T) {
+	assert.Nil(t, user.ValidateTOTPKey(""))
+	assert.Nil(t, user.ValidateTOTPKey("0123456789abcdef"))
+	assert.Nil(t, user.ValidateTOTPKey("0123456789abcdef0123456789abcdef"))
+
+	assert.NotNil(t, user.ValidateTOTPKey("too-short"))
+	assert.NotNil(t, user.ValidateTOTPKey("0123456789abcdef0"))
+	assert.NotNil(t, user.ValidateTOTPKey("\xff123456789abcdef"))
+}